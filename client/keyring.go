@@ -0,0 +1,123 @@
+/*
+Package client unwraps the keys of a user and decrypts their logs on the client, for zero-knowledge sessions.
+Those sessions only get wrapped keys and ciphertext from the API, so the server never holds the user symmetric key.
+The password never leaves the client either: the sign-in sends a verifier derived from the user symmetric key.
+The keys come from GET /auth/keys and the logs from GET /log/:id/sealed
+*/
+package client
+
+import (
+	eciesgo "github.com/ecies/go/v2"
+	"shareLog/lib"
+	"shareLog/lib/stackTrace"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"slices"
+)
+
+// Keyring holds the unwrapped private keys of a user. Every generation of a key is kept, since old logs use old ones
+type Keyring struct {
+	ownerKeys  []*eciesgo.PrivateKey
+	clientKeys []*eciesgo.PrivateKey
+	// The keys granting access to a single log, by the id of the original log
+	logKeys map[uint][]*eciesgo.PrivateKey
+}
+
+// Derives the user symmetric key from the password, the same way the server does
+func deriveUserSymmetricKey(password string, salt string, kdf dto.Kdf) []byte {
+	return encryption.NewKdfParamsFromDto(kdf).DeriveKey(password, salt)
+}
+
+/*
+DeriveKeyVerifier Returns the key verifier sent to POST /auth/signin instead of the password for zero-knowledge sign-ins.
+The params come from GET /auth/signin/params
+*/
+func DeriveKeyVerifier(password string, params dto.SignInParams) string {
+	return encryption.DeriveKeyVerifier(deriveUserSymmetricKey(password, params.Salt, params.Kdf))
+}
+
+// NewKeyring Derives the user symmetric key from the password and unwraps the keys of the user with it
+func NewKeyring(password string, userKeys dto.UserKeys) (*Keyring, error) {
+	userSymmetricKey := deriveUserSymmetricKey(password, userKeys.Salt, userKeys.Kdf)
+
+	// The rotated generations are wrapped to the personal key, it is unwrapped first
+	var personalPk *eciesgo.PrivateKey
+	for _, wrappedKey := range userKeys.Keys {
+		if wrappedKey.Grant != userGrant.Types.GrantPersonal.Name || wrappedKey.WrappedToPersonalKey {
+			continue
+		}
+
+		privateKey := encryption.PrivateKey{EncryptedHex: wrappedKey.WrappedPrivateKey}
+		pk, err := privateKey.Key(userSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+		personalPk = pk
+	}
+
+	keyring := Keyring{logKeys: make(map[uint][]*eciesgo.PrivateKey)}
+	for _, wrappedKey := range userKeys.Keys {
+		pk, err := unwrapKey(wrappedKey, userSymmetricKey, personalPk)
+		if err != nil {
+			return nil, err
+		}
+
+		switch wrappedKey.Grant {
+		case userGrant.Types.GrantOwner.Name:
+			keyring.ownerKeys = append(keyring.ownerKeys, pk)
+		case userGrant.Types.GrantClient.Name:
+			keyring.clientKeys = append(keyring.clientKeys, pk)
+		case userGrant.Types.GrantPartialOwner.Name:
+			if wrappedKey.LogId != nil {
+				keyring.logKeys[*wrappedKey.LogId] = append(keyring.logKeys[*wrappedKey.LogId], pk)
+			}
+		}
+	}
+
+	return &keyring, nil
+}
+
+func unwrapKey(wrappedKey dto.WrappedKey, userSymmetricKey []byte, personalPk *eciesgo.PrivateKey) (*eciesgo.PrivateKey, error) {
+	privateKey := encryption.PrivateKey{EncryptedHex: wrappedKey.WrappedPrivateKey}
+	if !wrappedKey.WrappedToPersonalKey {
+		return privateKey.Key(userSymmetricKey)
+	}
+
+	if personalPk == nil {
+		return nil, lib.Error{Msg: "No personal key to unwrap the rotated key"}
+	}
+
+	return privateKey.KeyForRecipient(personalPk)
+}
+
+// Removes the owner or shared layer, then the client layer of encryption
+func (k *Keyring) unwrapCipher(logId uint) func(data string) (string, error) {
+	outerKeys := slices.Concat(k.logKeys[logId], k.ownerKeys)
+	return func(data string) (string, error) {
+		clientLevelData, err := encryption.DecryptLayer(data, outerKeys...)
+		if err != nil {
+			return "", err
+		}
+
+		return encryption.DecryptLayer(clientLevelData, k.clientKeys...)
+	}
+}
+
+// DecryptLog Returns the log the same way the API returns logs it decrypts itself
+func (k *Keyring) DecryptLog(sealedLog dto.SealedLog) (*dto.Log, error) {
+	log, dataKey, err := models.NewLogFromSealedDto(sealedLog)
+	if err != nil {
+		return nil, err
+	}
+
+	decryptedLog, err := log.Decrypt(dataKey, k.unwrapCipher(sealedLog.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	decryptedLog.Frames = stackTrace.Parse(decryptedLog.StackTrace)
+	logDto := decryptedLog.ToDto()
+	return &logDto, nil
+}
@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+/*
+ApiKeyConfig
+
+	RotationGracePeriod: How long the previous key of a rotated api key keeps working, unless the rotation sets its own
+*/
+type ApiKeyConfig struct {
+	RotationGracePeriod time.Duration
+}
+
+const defaultApiKeyRotationGraceHours = 24
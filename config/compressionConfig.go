@@ -0,0 +1,15 @@
+package config
+
+/*
+CompressionConfig sizes are in bytes
+
+	MaxDecompressedBodySize: Request bodies bigger than this once decompressed are rejected
+	MinCompressedResponseSize: Responses smaller than this are sent uncompressed
+*/
+type CompressionConfig struct {
+	MaxDecompressedBodySize   int
+	MinCompressedResponseSize int
+}
+
+const defaultMaxDecompressedBodySize = 10 * 1024 * 1024
+const defaultMinCompressedResponseSize = 1024
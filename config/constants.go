@@ -5,13 +5,62 @@ const passwordConfigUpperLowerPasswordRule = "upperLowerPasswordRule"
 const passwordConfigSpecialCharPasswordRule = "specialCharPasswordRule"
 const passwordConfigMNumbersPasswordRule = "numbersPasswordRule"
 
-// RSA KEY
+// RSA or EC KEY
 const jwePubKeyPath = "jwePubKeyPath"
 const jwePkPath = "jwePkPath"
 
+// Comma separated
+const jweRetiredPkPaths = "jweRetiredPkPaths"
+
 // ECDSA in EC format
 // pk is in key format (might be just the ext and underlying is pem, no clue :) )
 const jwtPubKeyPath = "jwtPubKeyPath"
 const jwtPkPath = "jwtPkPath"
 
+// Comma separated
+const jwtRetiredPubKeyPaths = "jwtRetiredPubKeyPaths"
+
 const logSharingSecret = "logSharingSecret"
+const fingerprintSecret = "fingerprintSecret"
+const apiKeySecret = "apiKeySecret"
+const signInParamsSecret = "signInParamsSecret"
+
+const maxDecompressedBodySize = "maxDecompressedBodySize"
+const minCompressedResponseSize = "minCompressedResponseSize"
+
+const idempotencyWindowMinutes = "idempotencyWindowMinutes"
+
+const retentionDays = "retentionDays"
+const retentionPurgeIntervalMinutes = "retentionPurgeIntervalMinutes"
+
+const kdfIterations = "kdfIterations"
+const kdfMemoryKiB = "kdfMemoryKiB"
+const kdfParallelism = "kdfParallelism"
+
+const keyBackend = "keyBackend"
+const kekPath = "kekPath"
+const transitAddress = "transitAddress"
+const transitToken = "transitToken"
+const transitMount = "transitMount"
+const transitKeyName = "transitKeyName"
+const transitSigningKeyName = "transitSigningKeyName"
+
+const accessTokenMinutes = "accessTokenMinutes"
+const refreshTokenDays = "refreshTokenDays"
+const sessionFamilyDays = "sessionFamilyDays"
+
+// RFC 3339 timestamp
+const legacyTokensUntil = "legacyTokensUntil"
+
+const attemptStore = "attemptStore"
+const accountFreeAttempts = "accountFreeAttempts"
+const ipFreeAttempts = "ipFreeAttempts"
+const inviteFreeAttempts = "inviteFreeAttempts"
+const baseLockoutSeconds = "baseLockoutSeconds"
+const maxLockoutMinutes = "maxLockoutMinutes"
+const attemptResetHours = "attemptResetHours"
+
+// Comma separated
+const trustedProxies = "trustedProxies"
+
+const apiKeyRotationGraceHours = "apiKeyRotationGraceHours"
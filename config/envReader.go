@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func getEnvInt(key string, defValue int) int {
@@ -15,6 +17,16 @@ func getEnvInt(key string, defValue int) int {
 	return int(intVal)
 }
 
+// Returns the default value if the value is missing, malformed or not positive
+func getEnvPositiveInt(key string, defValue int) int {
+	intVal := getEnvInt(key, defValue)
+	if intVal <= 0 {
+		return defValue
+	}
+
+	return intVal
+}
+
 func getEnvBool(key string, defValue bool) bool {
 	strVal := os.Getenv(key)
 	boolVal, err := strconv.ParseBool(strVal)
@@ -25,6 +37,38 @@ func getEnvBool(key string, defValue bool) bool {
 	return boolVal
 }
 
+// Returns the zero time if the value is missing or isn't an RFC 3339 timestamp
+func getEnvTime(key string) time.Time {
+	timeVal, err := time.Parse(time.RFC3339, os.Getenv(key))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return timeVal
+}
+
+func getEnvString(key string, defValue string) string {
+	strVal := os.Getenv(key)
+	if strVal == "" {
+		return defValue
+	}
+
+	return strVal
+}
+
+// Returns the non empty values of a comma separated list
+func getEnvList(key string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
 func GetPasswordConfig() PasswordConfig {
 	return PasswordConfig{
 		MinPasswordLen:         getEnvInt(passwordConfigMinPasswordLen, defaultPasswordMinLen),
@@ -36,15 +80,93 @@ func GetPasswordConfig() PasswordConfig {
 
 func GetKeyPaths() KeysPathsConfig {
 	return KeysPathsConfig{
-		JwePkPath:     os.Getenv(jwePkPath),
-		JwePubKeyPath: os.Getenv(jwePubKeyPath),
-		JwtPubKeyPath: os.Getenv(jwtPubKeyPath),
-		JwtPkPath:     os.Getenv(jwtPkPath),
+		JwePkPath:             os.Getenv(jwePkPath),
+		JwePubKeyPath:         os.Getenv(jwePubKeyPath),
+		JweRetiredPkPaths:     getEnvList(jweRetiredPkPaths),
+		JwtPubKeyPath:         os.Getenv(jwtPubKeyPath),
+		JwtPkPath:             os.Getenv(jwtPkPath),
+		JwtRetiredPubKeyPaths: getEnvList(jwtRetiredPubKeyPaths),
 	}
 }
 
 func GetSecrets() SecretsConfig {
 	return SecretsConfig{
-		LogSharingSecret: os.Getenv(logSharingSecret),
+		LogSharingSecret:   os.Getenv(logSharingSecret),
+		FingerprintSecret:  os.Getenv(fingerprintSecret),
+		ApiKeySecret:       os.Getenv(apiKeySecret),
+		SignInParamsSecret: os.Getenv(signInParamsSecret),
+	}
+}
+
+func GetCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		MaxDecompressedBodySize:   getEnvPositiveInt(maxDecompressedBodySize, defaultMaxDecompressedBodySize),
+		MinCompressedResponseSize: getEnvPositiveInt(minCompressedResponseSize, defaultMinCompressedResponseSize),
+	}
+}
+
+func GetIngestionConfig() IngestionConfig {
+	return IngestionConfig{
+		IdempotencyWindow: time.Duration(getEnvInt(idempotencyWindowMinutes, defaultIdempotencyWindowMinutes)) * time.Minute,
+	}
+}
+
+func GetRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		DefaultRetention: time.Duration(getEnvInt(retentionDays, defaultRetentionDays)) * 24 * time.Hour,
+		PurgeInterval:    time.Duration(getEnvPositiveInt(retentionPurgeIntervalMinutes, defaultPurgeIntervalMinutes)) * time.Minute,
+	}
+}
+
+func GetKdfConfig() KdfConfig {
+	return KdfConfig{
+		Iterations:  getEnvInt(kdfIterations, defaultKdfIterations),
+		MemoryKiB:   getEnvInt(kdfMemoryKiB, defaultKdfMemoryKiB),
+		Parallelism: getEnvInt(kdfParallelism, defaultKdfParallelism),
+	}
+}
+
+func GetKeyBackendConfig() KeyBackendConfig {
+	return KeyBackendConfig{
+		Backend:               getEnvString(keyBackend, FileKeyBackend),
+		KekPath:               os.Getenv(kekPath),
+		TransitAddress:        os.Getenv(transitAddress),
+		TransitToken:          os.Getenv(transitToken),
+		TransitMount:          getEnvString(transitMount, defaultTransitMount),
+		TransitKeyName:        getEnvString(transitKeyName, defaultTransitKeyName),
+		TransitSigningKeyName: os.Getenv(transitSigningKeyName),
+	}
+}
+
+func GetSessionConfig() SessionConfig {
+	return SessionConfig{
+		AccessTokenLifetime:  time.Duration(getEnvInt(accessTokenMinutes, defaultAccessTokenMinutes)) * time.Minute,
+		RefreshTokenLifetime: time.Duration(getEnvInt(refreshTokenDays, defaultRefreshTokenDays)) * 24 * time.Hour,
+		FamilyLifetime:       time.Duration(getEnvInt(sessionFamilyDays, defaultSessionFamilyDays)) * 24 * time.Hour,
+		LegacyTokensUntil:    getEnvTime(legacyTokensUntil),
+	}
+}
+
+func GetLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		AttemptStore:        getEnvString(attemptStore, DatabaseAttemptStore),
+		AccountFreeAttempts: getEnvInt(accountFreeAttempts, defaultAccountFreeAttempts),
+		IpFreeAttempts:      getEnvInt(ipFreeAttempts, defaultIpFreeAttempts),
+		InviteFreeAttempts:  getEnvInt(inviteFreeAttempts, defaultInviteFreeAttempts),
+		BaseLockout:         time.Duration(getEnvInt(baseLockoutSeconds, defaultBaseLockoutSeconds)) * time.Second,
+		MaxLockout:          time.Duration(getEnvInt(maxLockoutMinutes, defaultMaxLockoutMinutes)) * time.Minute,
+		ResetAfter:          time.Duration(getEnvInt(attemptResetHours, defaultAttemptResetHours)) * time.Hour,
+	}
+}
+
+func GetServerConfig() ServerConfig {
+	return ServerConfig{
+		TrustedProxies: getEnvList(trustedProxies),
+	}
+}
+
+func GetApiKeyConfig() ApiKeyConfig {
+	return ApiKeyConfig{
+		RotationGracePeriod: time.Duration(getEnvInt(apiKeyRotationGraceHours, defaultApiKeyRotationGraceHours)) * time.Hour,
 	}
 }
@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+/*
+IngestionConfig
+
+	IdempotencyWindow: How long an idempotency key is remembered after the log was created
+*/
+type IngestionConfig struct {
+	IdempotencyWindow time.Duration
+}
+
+const defaultIdempotencyWindowMinutes = 24 * 60
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"math"
+)
+
+/*
+KdfConfig The Argon2id cost of new password derived keys. Keys derived with other costs are upgraded on sign in
+
+	Iterations: Number of passes over the memory
+	MemoryKiB: Memory used by a derivation
+	Parallelism: Number of threads used by a derivation
+*/
+type KdfConfig struct {
+	Iterations  int
+	MemoryKiB   int
+	Parallelism int
+}
+
+const defaultKdfIterations = 2
+const defaultKdfMemoryKiB = 19 * 1024
+const defaultKdfParallelism = 1
+
+// Validate Rejects the costs Argon2id can't use: a parallelism of 0 panics and the costs must fit their stored size
+func (c KdfConfig) Validate() error {
+	if c.Iterations < 1 || c.Iterations > math.MaxUint32 {
+		return errors.New("the KDF iterations must be between 1 and 2^32-1")
+	}
+
+	if c.Parallelism < 1 || c.Parallelism > math.MaxUint8 {
+		return errors.New("the KDF parallelism must be between 1 and 255")
+	}
+
+	// Argon2id needs at least 8 KiB per thread
+	if c.MemoryKiB < 8*c.Parallelism || c.MemoryKiB > math.MaxUint32 {
+		return errors.New("the KDF memory must be between 8 KiB per thread and 2^32-1 KiB")
+	}
+
+	return nil
+}
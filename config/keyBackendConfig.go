@@ -0,0 +1,29 @@
+package config
+
+const FileKeyBackend = "file"
+const TransitKeyBackend = "transit"
+
+/*
+KeyBackendConfig Where the key encryption key protecting the secrets of the server lives
+
+	Backend: FileKeyBackend keeps it in a local file, TransitKeyBackend delegates to a Vault transit compatible HTTP API
+	KekPath: File holding the base64 encoded 32 bytes key encryption key, for the file backend. Needed for wrapped secrets and TOTP enrollments
+	TransitAddress: Base URL of the transit API, e.g. http://127.0.0.1:8200
+	TransitToken: Sent in the X-Vault-Token header
+	TransitMount: Mount path of the transit engine
+	TransitKeyName: Name of the key wrapping the secrets
+	TransitSigningKeyName: Name of the ecdsa-p521 key signing the JWTs, the JWTs are verified with its public keys.
+	The local JWT key pair is used when empty
+*/
+type KeyBackendConfig struct {
+	Backend               string
+	KekPath               string
+	TransitAddress        string
+	TransitToken          string
+	TransitMount          string
+	TransitKeyName        string
+	TransitSigningKeyName string
+}
+
+const defaultTransitMount = "transit"
+const defaultTransitKeyName = "shareLog"
@@ -1,8 +1,18 @@
 package config
 
+/*
+KeysPathsConfig The key files of the server.
+To rotate a key, the new key replaces the current one and the old one is listed in the retired keys.
+Tokens issued with a retired key stay valid until they expire
+
+	JwtRetiredPubKeyPaths: Public keys of the previous JWT signing keys, still accepted to verify tokens
+	JweRetiredPkPaths: Private keys of the previous JWE encryption keys, still accepted to decrypt tokens
+*/
 type KeysPathsConfig struct {
-	JwtPubKeyPath string
-	JwtPkPath     string
-	JwePubKeyPath string
-	JwePkPath     string
+	JwtPubKeyPath         string
+	JwtPkPath             string
+	JwtRetiredPubKeyPaths []string
+	JwePubKeyPath         string
+	JwePkPath             string
+	JweRetiredPkPaths     []string
 }
@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+const DatabaseAttemptStore = "database"
+const MemoryAttemptStore = "memory"
+
+/*
+LockoutConfig Failed sign-in and invite attempts are counted per account, per IP and per invite.
+Once the free attempts are used, each failure locks the key for BaseLockout, doubling up to MaxLockout
+
+	AttemptStore: DatabaseAttemptStore keeps the counters in the database, shared by the instances using it.
+	MemoryAttemptStore keeps them in the memory of a single instance, they are lost on restart
+	ResetAfter: The counter of a key starts over when it had no failure for this long
+*/
+type LockoutConfig struct {
+	AttemptStore        string
+	AccountFreeAttempts int
+	IpFreeAttempts      int
+	InviteFreeAttempts  int
+	BaseLockout         time.Duration
+	MaxLockout          time.Duration
+	ResetAfter          time.Duration
+}
+
+const defaultAccountFreeAttempts = 5
+const defaultIpFreeAttempts = 20
+const defaultInviteFreeAttempts = 3
+const defaultBaseLockoutSeconds = 1
+const defaultMaxLockoutMinutes = 15
+const defaultAttemptResetHours = 24
@@ -0,0 +1,17 @@
+package config
+
+import "time"
+
+/*
+RetentionConfig
+
+	DefaultRetention: How long logs are kept when no retention policy matches them. 0 keeps them forever
+	PurgeInterval: How often expired logs are purged. Values that aren't positive fall back to the default
+*/
+type RetentionConfig struct {
+	DefaultRetention time.Duration
+	PurgeInterval    time.Duration
+}
+
+const defaultRetentionDays = 0
+const defaultPurgeIntervalMinutes = 60
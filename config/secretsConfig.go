@@ -2,4 +2,10 @@ package config
 
 type SecretsConfig struct {
 	LogSharingSecret string
+	// Key of the hash used to fingerprint stack traces
+	FingerprintSecret string
+	// Key of the hash api keys are stored with
+	ApiKeySecret string
+	// Key of the hash the sign-in parameters of unknown emails are made up with
+	SignInParamsSecret string
 }
@@ -0,0 +1,11 @@
+package config
+
+/*
+ServerConfig
+
+	TrustedProxies: Addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For header gives the client IP.
+	The IP of the connection is used when empty, so clients can't pick the IP their attempts are counted for
+*/
+type ServerConfig struct {
+	TrustedProxies []string
+}
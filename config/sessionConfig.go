@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+/*
+SessionConfig
+
+	AccessTokenLifetime: How long the tokens sent with each request are valid
+	RefreshTokenLifetime: How long a session can go without being refreshed. Each refresh extends it
+	FamilyLifetime: How long a session can be refreshed for after the user signed in. The user has to sign in again after it
+	LegacyTokensUntil: Until when the tokens issued before key ids and RSA-OAEP-256/ECDH-ES are still accepted,
+	checked against the current keys. A fixed date, so restarting the server doesn't extend it. Unset rejects them
+*/
+type SessionConfig struct {
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	FamilyLifetime       time.Duration
+	LegacyTokensUntil    time.Time
+}
+
+const defaultAccessTokenMinutes = 15
+const defaultRefreshTokenDays = 30
+const defaultSessionFamilyDays = 90
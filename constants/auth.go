@@ -2,6 +2,12 @@ package constants
 
 const ContextJWTKey = "jwt"
 
+// ContextApiKey The api key a request authenticated with
+const ContextApiKey = "apiKey"
+
+// ContextUser The user a request authenticated as, loaded once by the auth middleware
+const ContextUser = "user"
+
 const TokenHeaderPrefix = "Bearer "
 const UserAuthHeader = "Authorization"
 const ApiKeyHeader = "ApiKey"
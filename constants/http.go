@@ -0,0 +1,11 @@
+package constants
+
+const ContentEncodingHeader = "Content-Encoding"
+const AcceptEncodingHeader = "Accept-Encoding"
+const ContentLengthHeader = "Content-Length"
+const VaryHeader = "Vary"
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const GzipEncoding = "gzip"
+const ZstdEncoding = "zstd"
+const IdentityEncoding = "identity"
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"time"
+)
+
+func (a *authController) loadApiKeyRoutes(engine *gin.Engine) {
+	api := engine.Group("/auth/api")
+	a.WithAuth(api)
+	a.WithMinGrant(api, userGrant.Types.GrantClient)
+	{
+		api.POST("/", a.generateApiKey)
+		api.GET("", a.getApiKeys)
+		api.PATCH("/:id", a.updateApiKey)
+		api.DELETE("/:id", a.deleteApiKey)
+		api.POST("/:id/rotate", a.rotateApiKey)
+	}
+}
+
+func (a *authController) generateApiKey(context *gin.Context) {
+	user := a.GetUser(context)
+	if user == nil {
+		return
+	}
+
+	createDto := dto.CreateApiKey{}
+	if context.Request.ContentLength != 0 {
+		err := context.BindJSON(&createDto)
+		if err != nil {
+			context.Status(400)
+			return
+		}
+	}
+
+	apiKey, key, err := a.apiKeyService.CreateApiKey(user, createDto.Scopes)
+	if err != nil {
+		context.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	apiKeyDto := dto.ApiKey{
+		Id:  apiKey.ID,
+		Key: key,
+	}
+
+	context.JSON(200, models.GetResponse(apiKeyDto, nil))
+}
+
+func (a *authController) getApiKeys(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	apiKeys, err := a.apiKeyService.GetApiKeys(user)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(apiKeys, models.ApiKey.ToDto), nil))
+}
+
+func (a *authController) updateApiKey(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	id, err := a.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	updateDto := dto.UpdateApiKey{}
+	err = c.BindJSON(&updateDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	apiKey, err := a.apiKeyService.UpdateApiKey(user, id, updateDto)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(apiKey.ToDto(), nil))
+}
+
+func (a *authController) deleteApiKey(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	id, err := a.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	err = a.apiKeyService.DeleteApiKey(user, id)
+	if err != nil {
+		c.JSON(404, models.GetResponse(nil, &dto.Error{Code: 404, Message: err.Error()}))
+		return
+	}
+
+	c.Status(200)
+}
+
+// Sends back the new key. The body is optional, without it the configured grace period is used
+func (a *authController) rotateApiKey(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	id, err := a.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	rotateDto := dto.RotateApiKey{}
+	if c.Request.ContentLength != 0 {
+		err = c.BindJSON(&rotateDto)
+		if err != nil {
+			c.Status(400)
+			return
+		}
+	}
+
+	var gracePeriod *time.Duration
+	if rotateDto.GracePeriodMinutes != nil {
+		minutes := time.Duration(*rotateDto.GracePeriodMinutes) * time.Minute
+		gracePeriod = &minutes
+	}
+
+	apiKey, key, err := a.apiKeyService.RotateApiKey(user, id, gracePeriod)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.ApiKey{Id: apiKey.ID, Key: key}, nil))
+}
@@ -2,8 +2,10 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"math"
 	"shareLog/controllers/base"
 	"shareLog/data/repository"
 	"shareLog/di"
@@ -12,12 +14,19 @@ import (
 	"shareLog/models/dto"
 	"shareLog/models/userGrant"
 	"shareLog/services"
+	"strconv"
 )
 
 type authController struct {
 	base.BaseController
-	userRepo    repository.UserRepository
-	authService services.Auth
+	userRepo         repository.UserRepository
+	authService      services.Auth
+	recoveryService  services.Recovery
+	sessionsService  services.Sessions
+	twoFactorService services.TwoFactor
+	attemptLimiter   services.AttemptLimiter
+	apiKeyService    services.ApiKeys
+	keyManager       services.KeyManager
 }
 
 type Controller interface {
@@ -30,22 +39,59 @@ func (a *authController) LoadController(engine *gin.Engine) {
 	{
 		auth.POST("/signup", a.signUp)
 		auth.POST("/signin", a.signIn)
+		auth.GET("/signin/params", a.getSignInParams)
 		auth.POST("/signup/init", a.signUpFirstUser)
+		auth.POST("/recover", a.recover)
+		auth.POST("/refresh", a.refresh)
+	}
+
+	logout := engine.Group("/auth/logout")
+	a.WithAuth(logout)
+	a.WithMinGrant(logout, userGrant.Types.GrantClient)
+	{
+		logout.POST("", a.logout)
+	}
+
+	sessions := engine.Group("/auth/sessions")
+	a.WithAuth(sessions)
+	a.WithMinGrant(sessions, userGrant.Types.GrantClient)
+	{
+		sessions.GET("", a.getSessions)
+		sessions.DELETE("/:id", a.revokeSession)
 	}
 
 	invite := engine.Group("/auth/invite")
 	a.WithAuth(invite)
 	a.WithMinGrant(invite, userGrant.Types.GrantClient)
+	a.WithUserSymmetricKey(invite)
 	{
 		invite.POST("/", a.inviteUser)
 	}
 
-	api := engine.Group("/auth/api")
-	a.WithAuth(api)
-	a.WithMinGrant(api, userGrant.Types.GrantClient)
+	password := engine.Group("/auth/password")
+	a.WithAuth(password)
+	a.WithMinGrant(password, userGrant.Types.GrantClient)
+	{
+		password.POST("", a.changePassword)
+	}
+
+	recoveryCodes := engine.Group("/auth/recovery-codes")
+	a.WithAuth(recoveryCodes)
+	a.WithMinGrant(recoveryCodes, userGrant.Types.GrantClient)
+	a.WithUserSymmetricKey(recoveryCodes)
+	{
+		recoveryCodes.POST("", a.regenerateRecoveryCodes)
+	}
+
+	keys := engine.Group("/auth/keys")
+	a.WithAuth(keys)
+	a.WithMinGrant(keys, userGrant.Types.GrantClient)
 	{
-		api.POST("/", a.generateApiKey)
+		keys.GET("", a.getKeys)
 	}
+
+	a.loadApiKeyRoutes(engine)
+	a.loadTwoFactorRoutes(engine)
 }
 
 type ControllerProvider struct {
@@ -60,6 +106,12 @@ func (a ControllerProvider) Provide() any {
 		baseController,
 		userRepo,
 		authService,
+		di.Get[services.Recovery](),
+		di.Get[services.Sessions](),
+		di.Get[services.TwoFactor](),
+		di.Get[services.AttemptLimiter](),
+		di.Get[services.ApiKeys](),
+		di.Get[services.KeyManager](),
 	}
 
 	return &instance
@@ -94,18 +146,83 @@ func (a *authController) userAlreadyExists(c *gin.Context, email string) (bool,
 	return false, nil
 }
 
-func (a *authController) getAuthToken(user *models.User, password string) (*string, error) {
-	token, err := a.authService.GenerateUserAuthToken(user, password)
+// Reserves the attempt before it is verified. Returns nil if any of the keys is locked out, a 429 status is sent back with the time to wait
+func (a *authController) reserveAttempt(c *gin.Context, keys ...services.AttemptKey) *services.AttemptReservation {
+	reservation, err := a.attemptLimiter.Reserve(keys...)
+	var lockedOutErr services.LockedOutError
+	if errors.As(err, &lockedOutErr) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedOutErr.RetryAfter.Seconds()))))
+		c.JSON(429, models.GetResponse(nil, &dto.Error{Code: 429, Message: lockedOutErr.Error()}))
+		return nil
+	} else if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return nil
+	}
+
+	return reservation
+}
+
+func (a *authController) recordFailedAttempt(reservation *services.AttemptReservation) {
+	err := a.attemptLimiter.RecordFailure(reservation)
+	if err != nil {
+		fmt.Println("Failed to record a failed attempt: " + err.Error())
+	}
+}
+
+func (a *authController) recordSuccessfulAttempt(reservation *services.AttemptReservation, clearedKeys ...services.AttemptKey) {
+	err := a.attemptLimiter.RecordSuccess(reservation, clearedKeys...)
+	if err != nil {
+		fmt.Println("Failed to clear the failed attempts: " + err.Error())
+	}
+}
+
+func (a *authController) releaseAttempt(reservation *services.AttemptReservation) {
+	err := a.attemptLimiter.Release(reservation)
+	if err != nil {
+		fmt.Println("Failed to release an attempt: " + err.Error())
+	}
+}
+
+func getSessionClient(c *gin.Context) services.SessionClient {
+	return services.SessionClient{
+		Device: c.Request.UserAgent(),
+		Ip:     c.ClientIP(),
+	}
+}
+
+func (a *authController) startSession(c *gin.Context, user *models.User, userSymmetricKey string) (*dto.SignInResponse, error) {
+	tokens, err := a.sessionsService.StartSession(user, userSymmetricKey, getSessionClient(c))
 	if err != nil {
 		return nil, err
 	}
 
-	serializedToken, err := token.CompactSerialize()
+	return &dto.SignInResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+// Starts a session, or returns a challenge when the user has to pass a second factor first
+func (a *authController) completeSignIn(c *gin.Context, user *models.User, userSymmetricKey string) (*dto.SignInResponse, error) {
+	isRequired, isEnrollmentRequired := a.twoFactorService.GetSignInRequirement(user)
+	if !isRequired {
+		return a.startSession(c, user, userSymmetricKey)
+	}
+
+	challenge, err := a.authService.GenerateChallengeToken(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	challengeToken, err := challenge.CompactSerialize()
 	if err != nil {
 		return nil, err
 	}
 
-	return &serializedToken, nil
+	return &dto.SignInResponse{
+		ChallengeToken:     challengeToken,
+		EnrollmentRequired: isEnrollmentRequired,
+	}, nil
 }
 
 func (a *authController) doSignupValidations(c *gin.Context, email, password string) bool {
@@ -114,6 +231,10 @@ func (a *authController) doSignupValidations(c *gin.Context, email, password str
 		return false
 	}
 
+	return a.validatePassword(c, password)
+}
+
+func (a *authController) validatePassword(c *gin.Context, password string) bool {
 	passwordErrors := lib.IsPasswordValid(password)
 	if len(passwordErrors) != 0 {
 		reason := lib.Reduce(passwordErrors, func(acc string, err lib.PasswordError) string {
@@ -134,26 +255,34 @@ func (a *authController) signUp(c *gin.Context) {
 		return
 	}
 
+	inviteAttempts := services.InviteAttempts(signupDto.InviteId)
+	reservation := a.reserveAttempt(c, inviteAttempts, services.IpAttempts(c.ClientIP()))
+	if reservation == nil {
+		return
+	}
+
 	canSignUp := a.doSignupValidations(c, signupDto.Email, signupDto.Password)
 	if !canSignUp {
+		a.releaseAttempt(reservation)
 		return
 	}
 
-	user, err := a.authService.SignUpWithEmail(signupDto.Email, signupDto.Password, signupDto.Code, signupDto.InviteId)
+	user, recoveryCodes, err := a.authService.SignUpWithEmail(signupDto.Email, signupDto.Password, signupDto.Code, signupDto.InviteId)
 	if err != nil {
+		a.recordFailedAttempt(reservation)
 		c.Status(400)
 		return
 	}
 
-	token, err := a.getAuthToken(user, signupDto.Password)
+	a.recordSuccessfulAttempt(reservation, inviteAttempts)
+
+	response, err := a.completeSignIn(c, user, a.authService.DeriveUserSymmetricKey(user, signupDto.Password))
 	if err != nil {
 		c.Status(500)
 		return
 	}
 
-	response := dto.SignInResponse{
-		Token: *token,
-	}
+	response.RecoveryCodes = recoveryCodes
 
 	c.JSON(200, models.GetResponse(response, nil))
 }
@@ -182,21 +311,19 @@ func (a *authController) signUpFirstUser(c *gin.Context) {
 		return
 	}
 
-	user, err := a.authService.SignUpFirstUser(signupDto.Email, signupDto.Password)
+	user, recoveryCodes, err := a.authService.SignUpFirstUser(signupDto.Email, signupDto.Password)
 	if err != nil {
 		c.Status(400)
 		return
 	}
 
-	token, err := a.getAuthToken(user, signupDto.Password)
+	response, err := a.completeSignIn(c, user, a.authService.DeriveUserSymmetricKey(user, signupDto.Password))
 	if err != nil {
 		c.Status(500)
 		return
 	}
 
-	response := dto.SignInResponse{
-		Token: *token,
-	}
+	response.RecoveryCodes = recoveryCodes
 
 	c.JSON(200, models.GetResponse(response, nil))
 }
@@ -206,58 +333,265 @@ func (a *authController) shouldDoApiKeyAuth(c *gin.Context) bool {
 	return apiKey != nil
 }
 
-// Return the auth token for this user
-func (a *authController) signInUser(c *gin.Context) (string, error) {
+// Return the tokens of a new session for this user, or the second factor challenge
+func (a *authController) signInUser(c *gin.Context) (*dto.SignInResponse, error) {
 	loginDto := dto.Login{}
 	err := c.BindJSON(&loginDto)
 	if err != nil {
 		c.Status(400)
-		return "", err
+		return nil, err
+	}
+
+	accountAttempts := services.AccountAttempts(loginDto.Email)
+	reservation := a.reserveAttempt(c, accountAttempts, services.IpAttempts(c.ClientIP()))
+	if reservation == nil {
+		return nil, services.LockedOutError{}
 	}
 
-	user, err := a.authService.SignInWithEmail(loginDto.Email, loginDto.Password)
+	// Zero-knowledge sign-ins never send the password, so the server can't derive the user symmetric key
+	var user *models.User
+	if loginDto.ZeroKnowledge {
+		user, err = a.authService.SignInWithKeyVerifier(loginDto.Email, loginDto.KeyVerifier)
+	} else {
+		user, err = a.authService.SignInWithEmail(loginDto.Email, loginDto.Password)
+	}
 	if err != nil {
+		a.recordFailedAttempt(reservation)
 		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: "Wrong credentials"}))
-		return "", err
+		return nil, err
+	}
+
+	a.recordSuccessfulAttempt(reservation, accountAttempts)
+
+	userSymmetricKey := ""
+	if !loginDto.ZeroKnowledge {
+		userSymmetricKey = a.authService.DeriveUserSymmetricKey(user, loginDto.Password)
 	}
 
-	token, err := a.getAuthToken(user, loginDto.Password)
+	response, err := a.completeSignIn(c, user, userSymmetricKey)
 	if err != nil {
 		c.Status(500)
-		return "", err
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (a *authController) getSignInParams(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.Status(400)
+		return
+	}
+
+	params, err := a.authService.GetSignInParams(email)
+	if err != nil {
+		c.Status(500)
+		return
 	}
 
-	return *token, nil
+	c.JSON(200, models.GetResponse(params, nil))
 }
 
 func (a *authController) signIn(c *gin.Context) {
-	token, err := a.signInUser(c)
+	response, err := a.signInUser(c)
+	if err != nil {
+		return
+	}
+
+	c.JSON(200, models.GetResponse(response, nil))
+}
+
+func (a *authController) changePassword(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	changePasswordDto := dto.ChangePassword{}
+	err := c.BindJSON(&changePasswordDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	if !a.validatePassword(c, changePasswordDto.NewPassword) {
+		return
+	}
+
+	// The old password is guessable with a stolen access token, it counts against the account like a sign-in
+	accountAttempts := services.AccountAttempts(user.Email)
+	reservation := a.reserveAttempt(c, accountAttempts, services.IpAttempts(c.ClientIP()))
+	if reservation == nil {
+		return
+	}
+
+	user, err = a.authService.ChangePassword(user, changePasswordDto.OldPassword, changePasswordDto.NewPassword)
+	if errors.Is(err, services.ErrWrongPassword) {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: "Wrong credentials"}))
+		return
+	} else if err != nil {
+		a.releaseAttempt(reservation)
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, accountAttempts)
+
+	response, err := a.startSession(c, user, a.authService.DeriveUserSymmetricKey(user, changePasswordDto.NewPassword))
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(response, nil))
+}
+
+func (a *authController) recover(c *gin.Context) {
+	recoverDto := dto.Recover{}
+	err := c.BindJSON(&recoverDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	if !a.validatePassword(c, recoverDto.NewPassword) {
+		return
+	}
+
+	accountAttempts := services.AccountAttempts(recoverDto.Email)
+	reservation := a.reserveAttempt(c, accountAttempts, services.IpAttempts(c.ClientIP()))
+	if reservation == nil {
+		return
+	}
+
+	user, err := a.recoveryService.Recover(recoverDto.Email, recoverDto.RecoveryCode, recoverDto.NewPassword)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: "Wrong credentials"}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, accountAttempts)
+
+	response, err := a.completeSignIn(c, user, a.authService.DeriveUserSymmetricKey(user, recoverDto.NewPassword))
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(response, nil))
+}
+
+func (a *authController) regenerateRecoveryCodes(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	codes, err := a.recoveryService.GenerateCodes(user, a.GetUserSymmetricKey(c))
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.RecoveryCodes{Codes: codes}, nil))
+}
+
+func (a *authController) getKeys(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	// Zero-knowledge sessions never acquire the rotated keys on the server, the client unwraps them itself
+	rotatedKeys, err := a.keyManager.GetRotatedKeys(user)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(user.ToKeysDto(rotatedKeys), nil))
+}
+
+func (a *authController) refresh(c *gin.Context) {
+	refreshDto := dto.RefreshSession{}
+	err := c.BindJSON(&refreshDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	tokens, err := a.sessionsService.Refresh(refreshDto.RefreshToken, getSessionClient(c))
 	if err != nil {
+		c.JSON(401, models.GetResponse(nil, &dto.Error{Code: 401, Message: err.Error()}))
 		return
 	}
 
 	response := dto.SignInResponse{
-		Token: token,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}
 
 	c.JSON(200, models.GetResponse(response, nil))
 }
 
-func (a *authController) generateApiKey(context *gin.Context) {
-	user := a.GetUser(context)
+func (a *authController) logout(c *gin.Context) {
+	sessionId := a.GetSessionId(c)
+	if sessionId == "" {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "The token isn't bound to a session"}))
+		return
+	}
+
+	err := a.sessionsService.RevokeFamily(sessionId)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.Status(200)
+}
+
+func (a *authController) getSessions(c *gin.Context) {
+	user := a.GetUser(c)
 	if user == nil {
+		c.Status(401)
 		return
 	}
 
-	apiKey, err := a.authService.GenerateApiKey(user)
+	sessions, err := a.sessionsService.GetActiveSessions(user)
 	if err != nil {
-		context.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
 		return
 	}
 
-	apiKeyDto := dto.ApiKey{
-		Key: apiKey.Key,
+	currentSessionId := a.GetSessionId(c)
+	c.JSON(200, models.GetResponse(lib.Map(sessions, func(session models.Session) dto.Session {
+		return session.ToDto(currentSessionId)
+	}), nil))
+}
+
+func (a *authController) revokeSession(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	id, err := a.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	err = a.sessionsService.RevokeSession(user, id)
+	if err != nil {
+		c.JSON(404, models.GetResponse(nil, &dto.Error{Code: 404, Message: err.Error()}))
+		return
 	}
 
-	context.JSON(200, models.GetResponse(apiKeyDto, nil))
+	c.Status(200)
 }
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"shareLog/services"
+)
+
+func (a *authController) loadTwoFactorRoutes(engine *gin.Engine) {
+	// Second step of the sign-in, authenticated with the challenge token
+	signIn := engine.Group("/auth/signin/2fa")
+	{
+		signIn.POST("", a.completeChallenge)
+		signIn.POST("/enroll", a.enrollWithChallenge)
+		signIn.POST("/confirm", a.confirmWithChallenge)
+	}
+
+	twoFactor := engine.Group("/auth/2fa")
+	a.WithAuth(twoFactor)
+	a.WithMinGrant(twoFactor, userGrant.Types.GrantClient)
+	{
+		twoFactor.GET("", a.getTwoFactorStatus)
+		twoFactor.POST("/enroll", a.enrollTwoFactor)
+		twoFactor.POST("/confirm", a.confirmTwoFactor)
+		twoFactor.POST("/disable", a.disableTwoFactor)
+		twoFactor.POST("/backup-codes", a.regenerateBackupCodes)
+	}
+
+	policies := engine.Group("/auth/2fa/policies")
+	a.WithAuth(policies)
+	a.WithMinGrant(policies, userGrant.Types.GrantOwner)
+	{
+		policies.GET("", a.getTwoFactorPolicies)
+		policies.PUT("", a.setTwoFactorPolicy)
+	}
+}
+
+// Return the user of the challenge and their symmetric key. If the challenge is invalid, a 401 status is sent back
+func (a *authController) parseChallenge(c *gin.Context, challengeToken string) (*models.User, string, bool) {
+	user, userSymmetricKey, err := a.authService.ParseChallengeToken(challengeToken)
+	if err != nil {
+		c.JSON(401, models.GetResponse(nil, &dto.Error{Code: 401, Message: "Invalid or expired challenge"}))
+		return nil, "", false
+	}
+
+	return user, userSymmetricKey, true
+}
+
+func getTwoFactorAttempts(c *gin.Context, user *models.User) []services.AttemptKey {
+	return []services.AttemptKey{services.TwoFactorAttempts(user.ID), services.IpAttempts(c.ClientIP())}
+}
+
+func (a *authController) completeChallenge(c *gin.Context) {
+	challengeDto := dto.TwoFactorChallenge{}
+	err := c.BindJSON(&challengeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	user, userSymmetricKey, ok := a.parseChallenge(c, challengeDto.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	reservation := a.reserveAttempt(c, getTwoFactorAttempts(c, user)...)
+	if reservation == nil {
+		return
+	}
+
+	err = a.twoFactorService.Verify(user, challengeDto.Code, challengeDto.BackupCode)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, services.TwoFactorAttempts(user.ID))
+
+	response, err := a.startSession(c, user, userSymmetricKey)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(response, nil))
+}
+
+func (a *authController) enrollWithChallenge(c *gin.Context) {
+	challengeDto := dto.TwoFactorChallenge{}
+	err := c.BindJSON(&challengeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	user, _, ok := a.parseChallenge(c, challengeDto.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	a.enroll(c, user)
+}
+
+// Enables two-factor authentication and completes the sign-in. The backup codes are sent along the token
+func (a *authController) confirmWithChallenge(c *gin.Context) {
+	challengeDto := dto.TwoFactorChallenge{}
+	err := c.BindJSON(&challengeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	user, userSymmetricKey, ok := a.parseChallenge(c, challengeDto.ChallengeToken)
+	if !ok {
+		return
+	}
+
+	reservation := a.reserveAttempt(c, getTwoFactorAttempts(c, user)...)
+	if reservation == nil {
+		return
+	}
+
+	backupCodes, err := a.twoFactorService.Confirm(user, challengeDto.Code)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, services.TwoFactorAttempts(user.ID))
+
+	response, err := a.startSession(c, user, userSymmetricKey)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	response.BackupCodes = backupCodes
+	c.JSON(200, models.GetResponse(response, nil))
+}
+
+func (a *authController) getTwoFactorStatus(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	status, err := a.twoFactorService.GetStatus(user)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(status, nil))
+}
+
+func (a *authController) enroll(c *gin.Context, user *models.User) {
+	secret, uri, err := a.twoFactorService.Enroll(user)
+	if errors.Is(err, services.ErrTwoFactorUnavailable) {
+		c.JSON(503, models.GetResponse(nil, &dto.Error{Code: 503, Message: err.Error()}))
+		return
+	} else if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.TwoFactorEnrollment{Secret: secret, Uri: uri}, nil))
+}
+
+func (a *authController) enrollTwoFactor(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	a.enroll(c, user)
+}
+
+func (a *authController) confirmTwoFactor(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	codeDto := dto.TwoFactorCode{}
+	err := c.BindJSON(&codeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	reservation := a.reserveAttempt(c, getTwoFactorAttempts(c, user)...)
+	if reservation == nil {
+		return
+	}
+
+	backupCodes, err := a.twoFactorService.Confirm(user, codeDto.Code)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, services.TwoFactorAttempts(user.ID))
+
+	c.JSON(200, models.GetResponse(dto.TwoFactorBackupCodes{BackupCodes: backupCodes}, nil))
+}
+
+func (a *authController) disableTwoFactor(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	codeDto := dto.TwoFactorCode{}
+	err := c.BindJSON(&codeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	reservation := a.reserveAttempt(c, getTwoFactorAttempts(c, user)...)
+	if reservation == nil {
+		return
+	}
+
+	err = a.twoFactorService.Disable(user, codeDto.Code, codeDto.BackupCode)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, services.TwoFactorAttempts(user.ID))
+
+	c.Status(200)
+}
+
+func (a *authController) regenerateBackupCodes(c *gin.Context) {
+	user := a.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	codeDto := dto.TwoFactorCode{}
+	err := c.BindJSON(&codeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	reservation := a.reserveAttempt(c, getTwoFactorAttempts(c, user)...)
+	if reservation == nil {
+		return
+	}
+
+	backupCodes, err := a.twoFactorService.RegenerateBackupCodes(user, codeDto.Code)
+	if err != nil {
+		a.recordFailedAttempt(reservation)
+		c.JSON(403, models.GetResponse(nil, &dto.Error{Code: 403, Message: err.Error()}))
+		return
+	}
+
+	a.recordSuccessfulAttempt(reservation, services.TwoFactorAttempts(user.ID))
+
+	c.JSON(200, models.GetResponse(dto.TwoFactorBackupCodes{BackupCodes: backupCodes}, nil))
+}
+
+func (a *authController) getTwoFactorPolicies(c *gin.Context) {
+	policies, err := a.twoFactorService.GetPolicies()
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(policies, models.TwoFactorPolicy.ToDto), nil))
+}
+
+func (a *authController) setTwoFactorPolicy(c *gin.Context) {
+	policyDto := dto.TwoFactorPolicy{}
+	err := c.BindJSON(&policyDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	grant := userGrant.Types.GetByName(policyDto.Grant)
+	if grant == nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "Unknown grant"}))
+		return
+	}
+
+	policy, err := a.twoFactorService.SetPolicy(*grant, policyDto.Required)
+	if errors.Is(err, services.ErrTwoFactorUnavailable) {
+		c.JSON(503, models.GetResponse(nil, &dto.Error{Code: 503, Message: err.Error()}))
+		return
+	} else if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(policy.ToDto(), nil))
+}
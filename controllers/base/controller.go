@@ -16,28 +16,47 @@ import (
 	"strconv"
 )
 
+const defaultPageSize = 50
+const maxPageSize = 200
+
 type BaseController interface {
 	GetUser(c *gin.Context) *models.User
 	//  WithAuth(g *gin.RouterGroup)
 
 	// GetUserSymmetricKey Return the key this user uses to encrypt and decrypt all their other keys
 	GetUserSymmetricKey(c *gin.Context) string
-	WithAuth(g *gin.RouterGroup)
+	/*
+		WithAuth Authenticate the requests of this group. Api keys are rejected unless the group lets them in
+		with the scopes they need, users aren't limited by scopes
+	*/
+	WithAuth(g *gin.RouterGroup, apiKeyScopes ...models.ApiKeyScope)
 	WithMinGrant(g *gin.RouterGroup, grant userGrant.Type)
+	// WithUserSymmetricKey Reject the tokens of zero-knowledge sessions on this group, they don't carry the user symmetric key
+	WithUserSymmetricKey(g *gin.RouterGroup)
+	// WithCompressedBodies Accept gzip and zstd encoded request bodies on this group
+	WithCompressedBodies(g *gin.RouterGroup)
+	// WithCompressedResponses Compress big responses of this group for clients that accept it
+	WithCompressedResponses(g *gin.RouterGroup)
 	// GetUIntParam Try to get a param as uint. If the paring fails, an error is returned
 	// and a 400 status is sent back as response
 	GetUIntParam(c *gin.Context, paramName string) (uint, error)
+	// ValidatePageQuery Applies the default page size. If the page size is invalid, false is returned
+	// and a 400 status is sent back as response
+	ValidatePageQuery(c *gin.Context, query *dto.PageQuery) bool
 	IsApiKeyAuth(c *gin.Context) bool
+	// GetSessionId Return the session family of the user token, empty if it isn't bound to a session
+	GetSessionId(c *gin.Context) string
 	GetApiKey(c *gin.Context) (*models.ApiKey, error)
 }
 
 type baseController struct {
-	authService      services.Auth
-	authMiddleware   middleware.Auth
-	grantMiddleware  middleware.Grant
-	userRepository   repository.UserRepository
-	keyManager       services.KeyManager
-	apiKeyRepository repository.ApiKeyRepository
+	authService     services.Auth
+	authMiddleware  middleware.Auth
+	grantMiddleware middleware.Grant
+	scopeMiddleware middleware.Scope
+	compression     middleware.Compression
+	userRepository  repository.UserRepository
+	keyManager      services.KeyManager
 }
 
 type BaseControllerProvider struct {
@@ -45,12 +64,13 @@ type BaseControllerProvider struct {
 
 func (b BaseControllerProvider) Provide() any {
 	return &baseController{
-		authService:      di.Get[services.Auth](),
-		authMiddleware:   di.Get[middleware.Auth](),
-		userRepository:   di.Get[repository.UserRepository](),
-		grantMiddleware:  di.Get[middleware.Grant](),
-		keyManager:       di.Get[services.KeyManager](),
-		apiKeyRepository: di.Get[repository.ApiKeyRepository](),
+		authService:     di.Get[services.Auth](),
+		authMiddleware:  di.Get[middleware.Auth](),
+		userRepository:  di.Get[repository.UserRepository](),
+		grantMiddleware: di.Get[middleware.Grant](),
+		scopeMiddleware: di.Get[middleware.Scope](),
+		compression:     di.Get[middleware.Compression](),
+		keyManager:      di.Get[services.KeyManager](),
 	}
 }
 
@@ -74,6 +94,15 @@ func (b *baseController) IsApiKeyAuth(c *gin.Context) bool {
 	return b.authService.GetAuthGrant(*parsedJwt) == userGrant.Types.GrantApp
 }
 
+func (b *baseController) GetSessionId(c *gin.Context) string {
+	parsedJwt := getJwtFromContext(c)
+	if parsedJwt == nil {
+		return ""
+	}
+
+	return b.authService.GetSessionId(*parsedJwt)
+}
+
 func (b *baseController) GetUser(c *gin.Context) *models.User {
 	if b.IsApiKeyAuth(c) {
 		c.Status(403)
@@ -101,8 +130,11 @@ func (b *baseController) GetUserSymmetricKey(c *gin.Context) string {
 	return key
 }
 
-func (b *baseController) WithAuth(g *gin.RouterGroup) {
+func (b *baseController) WithAuth(g *gin.RouterGroup, apiKeyScopes ...models.ApiKeyScope) {
 	g.Use(b.authMiddleware.DoAuth)
+	g.Use(func(c *gin.Context) {
+		b.scopeMiddleware.CheckApiKeyScope(c, apiKeyScopes...)
+	})
 }
 
 func (b *baseController) WithMinGrant(g *gin.RouterGroup, grant userGrant.Type) {
@@ -111,6 +143,31 @@ func (b *baseController) WithMinGrant(g *gin.RouterGroup, grant userGrant.Type)
 	})
 }
 
+func (b *baseController) WithUserSymmetricKey(g *gin.RouterGroup) {
+	g.Use(func(c *gin.Context) {
+		parsedJwt := getJwtFromContext(c)
+		if parsedJwt == nil {
+			c.AbortWithStatus(401)
+			return
+		}
+
+		if b.authService.IsZeroKnowledge(*parsedJwt) {
+			c.AbortWithStatusJSON(403, models.GetResponse(nil, &dto.Error{
+				Code:    403,
+				Message: "Not available in zero-knowledge mode",
+			}))
+		}
+	})
+}
+
+func (b *baseController) WithCompressedBodies(g *gin.RouterGroup) {
+	g.Use(b.compression.DecompressBody)
+}
+
+func (b *baseController) WithCompressedResponses(g *gin.RouterGroup) {
+	g.Use(b.compression.CompressResponse)
+}
+
 func (b *baseController) GetUIntParam(c *gin.Context, paramName string) (uint, error) {
 	paramString := c.Param(paramName)
 	paramInt64, err := strconv.ParseInt(paramString, 10, 64)
@@ -126,15 +183,27 @@ func (b *baseController) GetUIntParam(c *gin.Context, paramName string) (uint, e
 	return paramUint, nil
 }
 
-func (b *baseController) GetApiKey(c *gin.Context) (*models.ApiKey, error) {
-	apiKey := c.GetHeader(constants.ApiKeyHeader)
-	if apiKey == "" {
-		return nil, lib.Error{Msg: "No api key provided"}
+func (b *baseController) ValidatePageQuery(c *gin.Context, query *dto.PageQuery) bool {
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
 	}
-	apiKeyModel := b.apiKeyRepository.GetByKey(apiKey)
-	if apiKeyModel == nil {
-		return nil, lib.Error{Msg: "API key not found"}
+
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{
+			Code:    400,
+			Message: "Invalid limit",
+		}))
+		return false
+	}
+
+	return true
+}
+
+func (b *baseController) GetApiKey(c *gin.Context) (*models.ApiKey, error) {
+	apiKey, exists := c.Get(constants.ContextApiKey)
+	if !exists {
+		return nil, lib.Error{Msg: "Not authenticated with an api key"}
 	}
 
-	return apiKeyModel, nil
+	return apiKey.(*models.ApiKey), nil
 }
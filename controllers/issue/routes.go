@@ -0,0 +1,258 @@
+package issue
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/controllers/base"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"shareLog/services"
+)
+
+type controller struct {
+	base.BaseController
+	issueService  services.Issue
+	issueWorkflow services.IssueWorkflow
+}
+
+type Controller interface {
+	base.LoadableController
+}
+
+type ControllerProvider struct {
+}
+
+func (p ControllerProvider) Provide() any {
+	var instance Controller = &controller{
+		BaseController: di.Get[base.BaseController](),
+		issueService:   di.Get[services.Issue](),
+		issueWorkflow:  di.Get[services.IssueWorkflow](),
+	}
+	return instance
+}
+
+func (i *controller) LoadController(engine *gin.Engine) {
+	ownerGroup := engine.Group("/issues")
+	i.WithAuth(ownerGroup)
+	i.WithMinGrant(ownerGroup, userGrant.Types.GrantOwner)
+	{
+		ownerGroup.GET("", i.getIssues)
+		ownerGroup.GET("/:id/logs", i.getIssueLogs)
+	}
+
+	workflowGroup := engine.Group("/issues/:id")
+	i.WithAuth(workflowGroup)
+	i.WithMinGrant(workflowGroup, userGrant.Types.GrantClient)
+	{
+		workflowGroup.PATCH("/status", i.setStatus)
+		workflowGroup.PATCH("/assignee", i.setAssignee)
+		workflowGroup.POST("/notes", i.addNote)
+		workflowGroup.GET("/history", i.getHistory)
+	}
+
+	notesGroup := engine.Group("/issues/:id")
+	i.WithAuth(notesGroup)
+	i.WithMinGrant(notesGroup, userGrant.Types.GrantClient)
+	i.WithUserSymmetricKey(notesGroup)
+	{
+		notesGroup.GET("/notes", i.getNotes)
+	}
+}
+
+// Returns the id of the issue in the url if the user can triage it.
+// Otherwise, the response is sent and false is returned
+func (i *controller) getAccessibleIssue(c *gin.Context) (uint, *models.User, bool) {
+	issueId, err := i.GetUIntParam(c, "id")
+	if err != nil {
+		return 0, nil, false
+	}
+
+	user := i.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return 0, nil, false
+	}
+
+	hasAccess, err := i.issueWorkflow.HaveAccessToIssue(issueId, user)
+	if err != nil {
+		c.Status(404)
+		return 0, nil, false
+	}
+
+	if !hasAccess {
+		c.Status(403)
+		return 0, nil, false
+	}
+
+	return issueId, user, true
+}
+
+func (i *controller) getIssues(c *gin.Context) {
+	var query dto.PageQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	if !i.ValidatePageQuery(c, &query) {
+		return
+	}
+
+	page, err := i.issueService.GetIssues(query.Cursor, query.Limit)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.Page[dto.Issue]{
+		Items:      lib.Map(page.Items, models.Issue.ToDto),
+		NextCursor: page.NextCursor,
+	}, nil))
+}
+
+func (i *controller) getIssueLogs(c *gin.Context) {
+	issueId, err := i.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	var query dto.PageQuery
+	err = c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	if !i.ValidatePageQuery(c, &query) {
+		return
+	}
+
+	page, err := i.issueService.GetIssueLogs(issueId, query.Cursor, query.Limit)
+	if err != nil {
+		c.Status(404)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.Page[dto.LogSummary]{
+		Items:      lib.Map(page.Items, models.Log.ToSummaryDto),
+		NextCursor: page.NextCursor,
+	}, nil))
+}
+
+func (i *controller) setStatus(c *gin.Context) {
+	issueId, user, ok := i.getAccessibleIssue(c)
+	if !ok {
+		return
+	}
+
+	var statusDto dto.IssueStatusUpdate
+	err := c.BindJSON(&statusDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	status := models.IssueStatuses.GetByName(statusDto.Status)
+	if status == nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "Unknown status"}))
+		return
+	}
+
+	err = i.issueWorkflow.SetStatus(issueId, user, *status)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.Status(200)
+}
+
+func (i *controller) setAssignee(c *gin.Context) {
+	issueId, user, ok := i.getAccessibleIssue(c)
+	if !ok {
+		return
+	}
+
+	var assigneeDto dto.IssueAssigneeUpdate
+	err := c.BindJSON(&assigneeDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	err = i.issueWorkflow.SetAssignee(issueId, user, assigneeDto.AssigneeId)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.Status(200)
+}
+
+func (i *controller) addNote(c *gin.Context) {
+	issueId, user, ok := i.getAccessibleIssue(c)
+	if !ok {
+		return
+	}
+
+	var noteDto dto.CreateIssueNote
+	err := c.BindJSON(&noteDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	level := &user.Grant
+	if noteDto.Level != "" {
+		level = userGrant.Types.GetByName(noteDto.Level)
+	}
+
+	if level == nil || noteDto.Content == "" {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "Invalid note"}))
+		return
+	}
+
+	note, err := i.issueWorkflow.AddNote(issueId, user, noteDto.Content, *level)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(201, models.GetResponse(models.DecryptedIssueNote{
+		IssueNote: *note,
+		Content:   noteDto.Content,
+	}.ToDto(), nil))
+}
+
+func (i *controller) getNotes(c *gin.Context) {
+	issueId, user, ok := i.getAccessibleIssue(c)
+	if !ok {
+		return
+	}
+
+	notes, err := i.issueWorkflow.GetNotes(issueId, user, i.GetUserSymmetricKey(c))
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(notes, models.DecryptedIssueNote.ToDto), nil))
+}
+
+func (i *controller) getHistory(c *gin.Context) {
+	issueId, _, ok := i.getAccessibleIssue(c)
+	if !ok {
+		return
+	}
+
+	events, err := i.issueWorkflow.GetHistory(issueId)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(events, models.IssueEvent.ToDto), nil))
+}
@@ -0,0 +1,126 @@
+package keyRotation
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/controllers/base"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"shareLog/services"
+)
+
+type controller struct {
+	base.BaseController
+	keyRotationService services.KeyRotation
+}
+
+type Controller interface {
+	base.LoadableController
+}
+
+type ControllerProvider struct {
+}
+
+func (p ControllerProvider) Provide() any {
+	var instance Controller = &controller{
+		BaseController:     di.Get[base.BaseController](),
+		keyRotationService: di.Get[services.KeyRotation](),
+	}
+	return instance
+}
+
+func (k *controller) LoadController(engine *gin.Engine) {
+	ownerGroup := engine.Group("/keys/rotations")
+	k.WithAuth(ownerGroup)
+	k.WithMinGrant(ownerGroup, userGrant.Types.GrantOwner)
+	{
+		ownerGroup.GET("", k.getRotations)
+		ownerGroup.GET("/:id", k.getRotation)
+	}
+
+	rotateGroup := engine.Group("/keys/rotations")
+	k.WithAuth(rotateGroup)
+	k.WithMinGrant(rotateGroup, userGrant.Types.GrantOwner)
+	k.WithUserSymmetricKey(rotateGroup)
+	{
+		rotateGroup.POST("", k.startRotation)
+		rotateGroup.POST("/:id/resume", k.resumeRotation)
+	}
+}
+
+func (k *controller) getRotations(c *gin.Context) {
+	rotations, err := k.keyRotationService.GetRotations()
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(rotations, models.KeyRotation.ToDto), nil))
+}
+
+func (k *controller) startRotation(c *gin.Context) {
+	user := k.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	var rotationDto dto.StartKeyRotation
+	err := c.BindJSON(&rotationDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	grant := userGrant.Types.GetByName(rotationDto.Grant)
+	if grant == nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "Unknown grant"}))
+		return
+	}
+
+	rotation, err := k.keyRotationService.StartRotation(user, k.GetUserSymmetricKey(c), *grant)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(202, models.GetResponse(rotation.ToDto(), nil))
+}
+
+func (k *controller) getRotation(c *gin.Context) {
+	rotationId, err := k.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	rotation, err := k.keyRotationService.GetRotation(rotationId)
+	if err != nil {
+		c.Status(404)
+		return
+	}
+
+	c.JSON(200, models.GetResponse(rotation.ToDto(), nil))
+}
+
+func (k *controller) resumeRotation(c *gin.Context) {
+	rotationId, err := k.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	user := k.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	rotation, err := k.keyRotationService.ResumeRotation(rotationId, user, k.GetUserSymmetricKey(c))
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(202, models.GetResponse(rotation.ToDto(), nil))
+}
@@ -1,15 +1,25 @@
 package log
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"shareLog/constants"
 	"shareLog/controllers/base"
 	"shareLog/di"
+	"shareLog/lib"
 	"shareLog/models"
 	"shareLog/models/dto"
 	"shareLog/models/userGrant"
 	"shareLog/services"
 )
 
+const maxBatchSize = 500
+const maxNdjsonLineSize = 1024 * 1024 // bytes
+const ndjsonContentType = "application/x-ndjson"
+
 type logController struct {
 	base.BaseController
 	logService services.Logger
@@ -34,32 +44,92 @@ func (l LogControllerProvider) Provide() any {
 }
 
 func (l *logController) LoadController(engine *gin.Engine) {
-	baseGroup := engine.Group("/log")
+	uploadGroup := engine.Group("/log")
 	authGroup := engine.Group("/log")
 
-	l.WithAuth(baseGroup)
+	l.WithAuth(uploadGroup, models.ApiKeyScopes.LogsWrite)
+	l.WithCompressedBodies(uploadGroup)
 	{
-		baseGroup.POST("/", l.createLog)
+		uploadGroup.POST("/", l.createLog)
+	}
+
+	batchGroup := engine.Group("/log")
+	l.WithAuth(batchGroup, models.ApiKeyScopes.LogsBatch)
+	l.WithCompressedBodies(batchGroup)
+	{
+		batchGroup.POST("/batch", l.createLogBatch)
 	}
 
 	l.WithAuth(authGroup)
 	l.WithMinGrant(authGroup, userGrant.Types.GrantClient)
+	l.WithCompressedResponses(authGroup)
 	{
-		authGroup.GET("/:id", l.getLog)
+		authGroup.GET("", l.listLogs)
+		authGroup.GET("/:id/sealed", l.getSealedLog)
+	}
+
+	decryptGroup := engine.Group("/log")
+	l.WithAuth(decryptGroup)
+	l.WithMinGrant(decryptGroup, userGrant.Types.GrantClient)
+	l.WithUserSymmetricKey(decryptGroup)
+	l.WithCompressedResponses(decryptGroup)
+	{
+		decryptGroup.GET("/:id", l.getLog)
+	}
+
+	ownerGroup := engine.Group("/log")
+	l.WithAuth(ownerGroup)
+	l.WithMinGrant(ownerGroup, userGrant.Types.GrantOwner)
+	{
+		ownerGroup.DELETE("/:id", l.deleteLog)
+	}
+}
+
+func validateLog(logDto dto.Log) *dto.Error {
+	if logDto.Severity != "" && models.LogSeverities.GetByName(logDto.Severity) == nil {
+		return &dto.Error{
+			Code:    400,
+			Message: "Unknown severity"}
+	}
+
+	return nil
+}
+
+// Returns 413 when the body was cut at the max size, 400 when it is malformed
+func getBodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return 413
 	}
+
+	return 400
 }
 
 func (l *logController) createLog(c *gin.Context) {
 	var logDto dto.Log
-	err := c.BindJSON(&logDto)
+	err := c.ShouldBindJSON(&logDto)
 	if err != nil {
-		c.JSON(400, models.GetResponse(nil, &dto.Error{
-			Code:    400,
+		status := getBodyErrorStatus(err)
+		c.JSON(status, models.GetResponse(nil, &dto.Error{
+			Code:    status,
 			Message: err.Error()}))
 		return
 	}
 
-	_, err = l.logService.SaveLog(logDto)
+	validationError := validateLog(logDto)
+	if validationError != nil {
+		c.JSON(400, models.GetResponse(nil, validationError))
+		return
+	}
+
+	headerIdempotencyKey := c.GetHeader(constants.IdempotencyKeyHeader)
+	if headerIdempotencyKey != "" {
+		logDto.IdempotencyKey = headerIdempotencyKey
+	}
+
+	// Idempotency keys are scoped per api key, so they are ignored for users
+	apiKey, _ := l.GetApiKey(c)
+	log, replayed, err := l.logService.SaveLog(logDto, apiKey)
 	if err != nil {
 		c.JSON(500, models.GetResponse(nil, &dto.Error{
 			Code:    500,
@@ -67,7 +137,12 @@ func (l *logController) createLog(c *gin.Context) {
 		return
 	}
 
-	c.Status(201)
+	status := 201
+	if replayed {
+		status = 200
+	}
+
+	c.JSON(status, models.GetResponse(dto.CreatedLog{Id: log.ID}, nil))
 }
 
 func (l *logController) getLog(c *gin.Context) {
@@ -83,6 +158,14 @@ func (l *logController) getLog(c *gin.Context) {
 	}
 
 	hasAccess, err := l.logService.HaveAccessToLog(logId, user)
+	if errors.Is(err, services.ErrNoLog) {
+		c.Status(404)
+		return
+	}
+	if err != nil {
+		c.Status(500)
+		return
+	}
 	if !hasAccess {
 		c.Status(403)
 		return
@@ -98,7 +181,195 @@ func (l *logController) getLog(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, models.GetResponse(dto.Log{
-		StackTrace: decryptedLog.StackTrace,
+	c.JSON(200, models.GetResponse(decryptedLog.ToDto(), nil))
+}
+
+func (l *logController) getSealedLog(c *gin.Context) {
+	logId, err := l.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	user := l.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	hasAccess, err := l.logService.HaveAccessToLog(logId, user)
+	if errors.Is(err, services.ErrNoLog) {
+		c.Status(404)
+		return
+	}
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	if !hasAccess {
+		c.Status(403)
+		return
+	}
+
+	sealedLog, err := l.logService.GetSealedLog(logId, user)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{
+			Code:    500,
+			Message: err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(sealedLog, nil))
+}
+
+func (l *logController) listLogs(c *gin.Context) {
+	var query dto.LogListQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(400, models.GetResponse(nil, &dto.Error{
+			Code:    400,
+			Message: err.Error()}))
+		return
+	}
+
+	if !l.ValidatePageQuery(c, &query.PageQuery) {
+		return
+	}
+
+	filter := models.LogFilter{
+		CreatedFrom:   query.CreatedFrom,
+		CreatedTo:     query.CreatedTo,
+		TimestampFrom: query.TimestampFrom,
+		TimestampTo:   query.TimestampTo,
+		AppVersion:    query.AppVersion,
+		Platform:      query.Platform,
+	}
+
+	if query.Severity != "" {
+		filter.Severity = models.LogSeverities.GetByName(query.Severity)
+		if filter.Severity == nil {
+			c.JSON(400, models.GetResponse(nil, &dto.Error{
+				Code:    400,
+				Message: "Unknown severity"}))
+			return
+		}
+	}
+
+	user := l.GetUser(c)
+	if user == nil {
+		c.Status(401)
+		return
+	}
+
+	page, err := l.logService.GetLogs(user, filter, query.Cursor, query.Limit)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{
+			Code:    500,
+			Message: err.Error(),
+		}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.Page[dto.LogSummary]{
+		Items:      lib.Map(page.Items, models.Log.ToSummaryDto),
+		NextCursor: page.NextCursor,
 	}, nil))
 }
+
+// readBatch Returns the raw items of a batch sent either as a JSON array or as NDJSON
+func readBatch(c *gin.Context) ([]json.RawMessage, error) {
+	if c.ContentType() != ndjsonContentType {
+		var items []json.RawMessage
+		err := json.NewDecoder(c.Request.Body).Decode(&items)
+		return items, err
+	}
+
+	items := make([]json.RawMessage, 0)
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxNdjsonLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		// The scanner reuses its buffer
+		items = append(items, append(json.RawMessage{}, line...))
+	}
+
+	return items, scanner.Err()
+}
+
+func (l *logController) createLogBatch(c *gin.Context) {
+	items, err := readBatch(c)
+	if err != nil {
+		status := getBodyErrorStatus(err)
+		c.JSON(status, models.GetResponse(nil, &dto.Error{
+			Code:    status,
+			Message: err.Error()}))
+		return
+	}
+
+	if len(items) > maxBatchSize {
+		c.JSON(413, models.GetResponse(nil, &dto.Error{
+			Code:    413,
+			Message: "Too many logs in batch"}))
+		return
+	}
+
+	results := make([]dto.BatchLogResult, len(items))
+	validLogs := make([]dto.Log, 0, len(items))
+	validIndexes := make([]int, 0, len(items))
+	for i, item := range items {
+		results[i].Index = i
+
+		var logDto dto.Log
+		err := json.Unmarshal(item, &logDto)
+		if err != nil {
+			results[i].Error = &dto.Error{Code: 400, Message: err.Error()}
+			continue
+		}
+
+		validationError := validateLog(logDto)
+		if validationError != nil {
+			results[i].Error = validationError
+			continue
+		}
+
+		validLogs = append(validLogs, logDto)
+		validIndexes = append(validIndexes, i)
+	}
+
+	apiKey, _ := l.GetApiKey(c)
+	savedLogs := l.logService.SaveLogs(validLogs, apiKey)
+	for i, savedLog := range savedLogs {
+		index := validIndexes[i]
+		if savedLog.Second != nil {
+			results[index].Error = &dto.Error{Code: 500, Message: savedLog.Second.Error()}
+			continue
+		}
+
+		results[index].Id = &savedLog.First.ID
+	}
+
+	c.JSON(200, models.GetResponse(results, nil))
+}
+
+func (l *logController) deleteLog(c *gin.Context) {
+	logId, err := l.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	err = l.logService.DeleteLog(logId)
+	if errors.Is(err, services.ErrNoLog) {
+		c.Status(404)
+		return
+	}
+	if err != nil {
+		c.Status(500)
+		return
+	}
+
+	c.Status(200)
+}
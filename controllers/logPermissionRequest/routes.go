@@ -1,6 +1,7 @@
 package logPermissionRequest
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"shareLog/controllers/base"
 	"shareLog/data/repository"
@@ -42,6 +43,7 @@ func (p ControllerProvider) Provide() any {
 func (l *controller) LoadController(engine *gin.Engine) {
 	rootGroup := engine.Group("/log")
 	l.WithAuth(rootGroup)
+	l.WithMinGrant(rootGroup, userGrant.Types.GrantClient)
 	{
 		rootGroup.GET("/list", l.getPermissionRequests)
 	}
@@ -49,16 +51,27 @@ func (l *controller) LoadController(engine *gin.Engine) {
 	clientGroup := rootGroup.Group("/:id/permission")
 	{
 		clientGroup.POST("/", l.requestPermission)
-		clientGroup.POST("/acquire", l.acquireSharedKey)
 		clientGroup.PATCH("/reset", l.resetPermissionRequest)
 	}
 
+	acquireGroup := rootGroup.Group("/:id/permission")
+	l.WithUserSymmetricKey(acquireGroup)
+	{
+		acquireGroup.POST("/acquire", l.acquireSharedKey)
+	}
+
 	ownerGroup := rootGroup.Group("/:id/permission/owner")
 	l.WithMinGrant(ownerGroup, userGrant.Types.GrantOwner)
 	{
-		ownerGroup.PATCH("/", l.acceptPermissionRequest)
 		ownerGroup.DELETE("/", l.denyPermissionRequest)
 	}
+
+	approveGroup := rootGroup.Group("/:id/permission/owner")
+	l.WithMinGrant(approveGroup, userGrant.Types.GrantOwner)
+	l.WithUserSymmetricKey(approveGroup)
+	{
+		approveGroup.PATCH("/", l.acceptPermissionRequest)
+	}
 }
 
 func (l *controller) requestPermission(c *gin.Context) {
@@ -67,7 +80,8 @@ func (l *controller) requestPermission(c *gin.Context) {
 		return
 	}
 
-	err = l.permissionRequestService.RequestPermission(logId)
+	user := l.GetUser(c)
+	err = l.permissionRequestService.RequestPermission(user, logId)
 	if err != nil {
 		c.JSON(500, models.GetResponse(nil, &dto.Error{
 			Code:    500,
@@ -142,7 +156,12 @@ func (l *controller) resetPermissionRequest(c *gin.Context) {
 		return
 	}
 
-	err = l.permissionRequestService.ResetPermissionRequest(*request)
+	user := l.GetUser(c)
+	err = l.permissionRequestService.ResetPermissionRequest(user, *request)
+	if errors.Is(err, services.ErrNotRequester) {
+		c.Status(403)
+		return
+	}
 	if err != nil {
 		c.JSON(500, models.GetResponse(nil, &dto.Error{
 			Code:    500,
@@ -0,0 +1,113 @@
+package retention
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/controllers/base"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"shareLog/services"
+)
+
+type controller struct {
+	base.BaseController
+	retentionService services.Retention
+}
+
+type Controller interface {
+	base.LoadableController
+}
+
+type ControllerProvider struct {
+}
+
+func (p ControllerProvider) Provide() any {
+	var instance Controller = &controller{
+		BaseController:   di.Get[base.BaseController](),
+		retentionService: di.Get[services.Retention](),
+	}
+	return instance
+}
+
+func (r *controller) LoadController(engine *gin.Engine) {
+	ownerGroup := engine.Group("/retention")
+	r.WithAuth(ownerGroup)
+	r.WithMinGrant(ownerGroup, userGrant.Types.GrantOwner)
+	{
+		ownerGroup.GET("/policies", r.getPolicies)
+		ownerGroup.POST("/policies", r.createPolicy)
+		ownerGroup.DELETE("/policies/:id", r.deletePolicy)
+		ownerGroup.GET("/report", r.getReport)
+	}
+}
+
+func (r *controller) getPolicies(c *gin.Context) {
+	policies, err := r.retentionService.GetPolicies()
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(lib.Map(policies, models.RetentionPolicy.ToDto), nil))
+}
+
+func (r *controller) createPolicy(c *gin.Context) {
+	var policyDto dto.CreateRetentionPolicy
+	err := c.BindJSON(&policyDto)
+	if err != nil {
+		c.Status(400)
+		return
+	}
+
+	policy := models.RetentionPolicy{
+		ApiKeyId: policyDto.ApiKeyId,
+		Days:     policyDto.Days,
+	}
+
+	if policyDto.Severity != nil {
+		policy.Severity = models.LogSeverities.GetByName(*policyDto.Severity)
+		if policy.Severity == nil {
+			c.JSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: "Unknown severity"}))
+			return
+		}
+	}
+
+	err = r.retentionService.CreatePolicy(&policy)
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(201, models.GetResponse(policy.ToDto(), nil))
+}
+
+func (r *controller) deletePolicy(c *gin.Context) {
+	policyId, err := r.GetUIntParam(c, "id")
+	if err != nil {
+		return
+	}
+
+	err = r.retentionService.DeletePolicy(policyId)
+	if err != nil {
+		c.Status(404)
+		return
+	}
+
+	c.Status(200)
+}
+
+// The dry run of the next purge
+func (r *controller) getReport(c *gin.Context) {
+	expiredIds, err := r.retentionService.GetExpiredLogIds()
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, models.GetResponse(dto.RetentionReport{
+		ExpiredLogCount: len(expiredIds),
+		ExpiredLogIds:   expiredIds,
+	}, nil))
+}
@@ -0,0 +1,50 @@
+package wellKnown
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/controllers/base"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/services"
+)
+
+type wellKnownController struct {
+	base.BaseController
+	serverKeys services.ServerKeys
+}
+
+type Controller interface {
+	base.LoadableController
+}
+
+type ControllerProvider struct {
+}
+
+func (w ControllerProvider) Provide() any {
+	instance := wellKnownController{
+		BaseController: di.Get[base.BaseController](),
+		serverKeys:     di.Get[services.ServerKeys](),
+	}
+
+	return &instance
+}
+
+func (w *wellKnownController) LoadController(engine *gin.Engine) {
+	wellKnown := engine.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", w.getJwks)
+	}
+}
+
+// The public keys verifying the JWTs, the current signing key and the retired ones still accepted.
+// Not wrapped in the usual response so standard JWKS clients can read it
+func (w *wellKnownController) getJwks(c *gin.Context) {
+	verificationKeys, err := w.serverKeys.GetJwtVerificationKeys()
+	if err != nil {
+		c.JSON(500, models.GetResponse(nil, &dto.Error{Code: 500, Message: err.Error()}))
+		return
+	}
+
+	c.JSON(200, verificationKeys)
+}
@@ -17,7 +17,7 @@ func (d DatabaseProvider) Provide() any {
 	}
 
 	// Run migrations
-	err = db.AutoMigrate(getEntities()...)
+	err = Migrate(db)
 	if err != nil {
 		return nil
 	}
@@ -25,13 +25,30 @@ func (d DatabaseProvider) Provide() any {
 	return db
 }
 
+// Migrate Creates the tables of the entities and adds their missing columns and indexes
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(getEntities()...)
+}
+
 func getEntities() []interface{} {
 	return []interface{}{
 		&models.Log{},
+		&models.LogDataKey{},
 		&encryption.Key{},
 		&models.User{},
 		&models.Invite{},
 		&models.PermissionRequest{},
 		&models.ApiKey{},
+		&models.Issue{},
+		&models.IssueNote{},
+		&models.IssueEvent{},
+		&models.RetentionPolicy{},
+		&models.RecoveryCode{},
+		&models.KeyRotation{},
+		&models.Session{},
+		&models.TwoFactor{},
+		&models.TwoFactorBackupCode{},
+		&models.TwoFactorPolicy{},
+		&models.AttemptCounter{},
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"gorm.io/gorm"
 	"shareLog/di"
 	"shareLog/models"
+	"time"
 )
 
 type apiKeyRepository struct {
@@ -12,7 +13,19 @@ type apiKeyRepository struct {
 
 type ApiKeyRepository interface {
 	BaseRepository[models.ApiKey]
-	GetByKey(key string) *models.ApiKey
+	/*
+		GetByPrefix Returns the api keys that aren't expired with this prefix,
+		or whose previous key has this prefix and is still in its grace period
+	*/
+	GetByPrefix(prefix string) ([]models.ApiKey, error)
+	// GetByKeyHash Same as GetByPrefix, for the keys sent without a prefix
+	GetByKeyHash(keyHash string) ([]models.ApiKey, error)
+	// GetByUserId Returns the api keys of the user. Without a user id, returns all the api keys
+	GetByUserId(userId *uint) ([]models.ApiKey, error)
+	// GetWithPlaintextKeys Returns the api keys still storing their current or previous key in plaintext
+	GetWithPlaintextKeys() ([]models.ApiKey, error)
+	// UpdateLastUsedAt Sets the last use of the api key, if it wasn't used since staleBefore
+	UpdateLastUsedAt(id uint, usedAt time.Time, staleBefore time.Time) error
 }
 
 type ApiKeyRepositoryProvider struct {
@@ -24,15 +37,48 @@ func (a ApiKeyRepositoryProvider) Provide() any {
 	return instance
 }
 
-func (a *apiKeyRepository) GetByKey(key string) *models.ApiKey {
-	var model models.ApiKey
-	err := a.db.Preload("EncryptionKey").Where(models.ApiKey{
-		Key: key,
-	}).First(&model).Error
+func (a *apiKeyRepository) GetByPrefix(prefix string) ([]models.ApiKey, error) {
+	var apiKeys []models.ApiKey
+	now := time.Now()
+	err := a.db.Preload("EncryptionKey").
+		Where("prefix = ? OR (previous_prefix = ? AND previous_key_expires_at > ?)", prefix, prefix, now).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Find(&apiKeys).Error
 
-	if err != nil {
-		return nil
-	} else {
-		return &model
+	return apiKeys, err
+}
+
+func (a *apiKeyRepository) GetByKeyHash(keyHash string) ([]models.ApiKey, error) {
+	var apiKeys []models.ApiKey
+	now := time.Now()
+	err := a.db.Preload("EncryptionKey").
+		Where("key_hash = ? OR (previous_key_hash = ? AND previous_key_expires_at > ?)", keyHash, keyHash, now).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Find(&apiKeys).Error
+
+	return apiKeys, err
+}
+
+func (a *apiKeyRepository) GetByUserId(userId *uint) ([]models.ApiKey, error) {
+	var apiKeys []models.ApiKey
+	query := a.db.Order("id desc")
+	if userId != nil {
+		query = query.Where(models.ApiKey{UserId: *userId})
 	}
+
+	err := query.Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func (a *apiKeyRepository) GetWithPlaintextKeys() ([]models.ApiKey, error) {
+	var apiKeys []models.ApiKey
+	err := a.db.Where("key != '' OR previous_key != ''").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func (a *apiKeyRepository) UpdateLastUsedAt(id uint, usedAt time.Time, staleBefore time.Time) error {
+	return a.db.
+		Model(&models.ApiKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, staleBefore).
+		Update("last_used_at", usedAt).Error
 }
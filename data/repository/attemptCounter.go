@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shareLog/di"
+	"shareLog/models"
+	"time"
+)
+
+type attemptCounterRepository struct {
+	baseRepository[models.AttemptCounter]
+}
+
+type AttemptCounterRepository interface {
+	BaseRepository[models.AttemptCounter]
+	// GetByKey Returns nil when there is no counter for the key
+	GetByKey(key string) (*models.AttemptCounter, error)
+	/*
+		Reserve Counts a failure for the key with a single upsert, starting over if the last one was before resetBefore.
+		Once the failures are past freeAttempts the key is locked until lockedUntil. Returns nil and counts nothing
+		if the key is locked
+	*/
+	Reserve(key string, freeAttempts int, resetBefore time.Time, lockedUntil time.Time) (*models.AttemptCounter, error)
+	// Uncount Takes back a failure counted by Reserve
+	Uncount(key string) error
+	SetLockedUntil(key string, lockedUntil time.Time) error
+	DeleteByKey(key string) error
+}
+
+type AttemptCounterRepositoryProvider struct {
+}
+
+func (a AttemptCounterRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance AttemptCounterRepository = &attemptCounterRepository{baseRepository: newBaseRepository[models.AttemptCounter](db)}
+	return instance
+}
+
+func (a *attemptCounterRepository) GetByKey(key string) (*models.AttemptCounter, error) {
+	var counter models.AttemptCounter
+	err := a.getDb().Where(models.AttemptCounter{Key: key}).First(&counter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &counter, nil
+}
+
+func (a *attemptCounterRepository) Reserve(key string, freeAttempts int, resetBefore time.Time, lockedUntil time.Time) (*models.AttemptCounter, error) {
+	now := time.Now()
+	counter := models.AttemptCounter{Key: key, Failures: 1, LastFailureAt: now}
+	if counter.Failures > freeAttempts {
+		counter.LockedUntil = lockedUntil
+	}
+
+	// The expressions of the update read the row as it was before it
+	failures := gorm.Expr("CASE WHEN attempt_counters.last_failure_at < ? THEN 1 ELSE attempt_counters.failures + 1 END", resetBefore)
+	result := a.getDb().Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures":        failures,
+				"last_failure_at": now,
+				"locked_until":    gorm.Expr("CASE WHEN ? > ? THEN ? ELSE attempt_counters.locked_until END", failures, freeAttempts, lockedUntil),
+				"updated_at":      now,
+			}),
+			// A locked key isn't counted
+			Where: clause.Where{Exprs: []clause.Expression{gorm.Expr("attempt_counters.locked_until <= ?", now)}},
+		},
+		clause.Returning{},
+	).Create(&counter)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &counter, nil
+}
+
+func (a *attemptCounterRepository) Uncount(key string) error {
+	return a.getDb().
+		Model(&models.AttemptCounter{}).
+		Where(models.AttemptCounter{Key: key}).
+		Where("failures > 0").
+		Update("failures", gorm.Expr("failures - 1")).Error
+}
+
+func (a *attemptCounterRepository) SetLockedUntil(key string, lockedUntil time.Time) error {
+	return a.getDb().
+		Model(&models.AttemptCounter{}).
+		Where(models.AttemptCounter{Key: key}).
+		Update("locked_until", lockedUntil).Error
+}
+
+func (a *attemptCounterRepository) DeleteByKey(key string) error {
+	return a.getDb().Unscoped().Where(models.AttemptCounter{Key: key}).Delete(&models.AttemptCounter{}).Error
+}
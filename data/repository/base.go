@@ -3,6 +3,7 @@ package repository
 import (
 	"fmt"
 	"gorm.io/gorm"
+	"shareLog/lib"
 )
 
 type baseRepository[T any] struct {
@@ -13,11 +14,23 @@ type BaseRepository[T any] interface {
 	getDb() *gorm.DB
 	Save(model *T) error
 	SaveAll(model []T) error
+	SaveAllAtomically(models []*T) error
 	GetById(id uint) *T
 	Count() (int64, error)
 	Delete(entity *T) error
 	DeletePermanently(entity *T) error
 	BatchDeletePermanently(entities []T) error
+	GetPage(cursor *uint, limit int, scopes ...func(*gorm.DB) *gorm.DB) (*Page[T], error)
+}
+
+/*
+Page is a slice of entities ordered by descending id
+
+	NextCursor: The cursor to pass to get the following page. Nil if this is the last page
+*/
+type Page[T any] struct {
+	Items      []T
+	NextCursor *uint
 }
 
 func newBaseRepository[T any](db *gorm.DB) baseRepository[T] {
@@ -44,6 +57,23 @@ func (r *baseRepository[T]) SaveAll(models []T) error {
 	return nil
 }
 
+/*
+SaveAllAtomically saves all the entities in a single transaction. Either all of them are saved or none is
+The ids of the entities are set by the database on the objects passed in
+*/
+func (r *baseRepository[T]) SaveAllAtomically(models []*T) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		for _, model := range models {
+			err := tx.Save(model).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (r *baseRepository[T]) getDb() *gorm.DB {
 	return r.db
 }
@@ -88,3 +118,46 @@ func (r *baseRepository[T]) BatchDeletePermanently(entities []T) error {
 	db := r.getDb()
 	return db.Unscoped().Delete(entities).Error
 }
+
+/*
+GetPage returns at most limit entities with an id lower than the cursor, newest first.
+A nil cursor starts from the newest entity. The scopes are applied to filter the entities.
+*/
+func (r *baseRepository[T]) GetPage(cursor *uint, limit int, scopes ...func(*gorm.DB) *gorm.DB) (*Page[T], error) {
+	if limit <= 0 {
+		return nil, lib.Error{Msg: "Invalid page size"}
+	}
+
+	db := r.getDb()
+	var model T
+	query := db.Model(&model).Scopes(scopes...)
+	if cursor != nil {
+		query = query.Where("id < ?", *cursor)
+	}
+
+	// Fetch one extra id to know if there is a next page
+	var ids []uint
+	err := query.Order("id desc").Limit(limit+1).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var nextCursor *uint
+	if len(ids) > limit {
+		ids = ids[:limit]
+		nextCursor = &ids[limit-1]
+	}
+
+	items := make([]T, 0)
+	if len(ids) != 0 {
+		err = db.Order("id desc").Find(&items, ids).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Page[T]{
+		Items:      items,
+		NextCursor: nextCursor,
+	}, nil
+}
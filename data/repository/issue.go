@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"time"
+)
+
+type issueRepository struct {
+	baseRepository[models.Issue]
+}
+
+type IssueRepository interface {
+	BaseRepository[models.Issue]
+	GetByFingerprint(fingerprint string) (*models.Issue, error)
+	// UpdateWithEvent Updates only the given columns of the issue and saves the event of the change in one transaction
+	UpdateWithEvent(issueId uint, columns map[string]any, event *models.IssueEvent) error
+	// IsAcquiredByUser Returns whether the user acquired the shared key of at least one log of the issue
+	IsAcquiredByUser(issueId uint, userId uint) (bool, error)
+}
+
+type IssueRepositoryProvider struct {
+}
+
+func (i IssueRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance IssueRepository = &issueRepository{baseRepository: newBaseRepository[models.Issue](db)}
+	return instance
+}
+
+func (r *issueRepository) GetByFingerprint(fingerprint string) (*models.Issue, error) {
+	var issue models.Issue
+	err := r.getDb().Where(models.Issue{
+		Fingerprint: fingerprint,
+	}).First(&issue).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &issue, nil
+}
+
+// Adds count logs to the issue, seen last at lastSeen
+func recordIssueOccurrences(db *gorm.DB, issueId uint, count int, lastSeen time.Time) error {
+	return db.Model(&models.Issue{}).
+		Where("id = ?", issueId).
+		Updates(map[string]any{
+			"count":     gorm.Expr("count + ?", count),
+			"last_seen": gorm.Expr("CASE WHEN last_seen < ? THEN ? ELSE last_seen END", lastSeen, lastSeen),
+		}).Error
+}
+
+// Returns the issue with the fingerprint, creating it if it doesn't exist yet
+func getOrCreateIssue(db *gorm.DB, fingerprint string) (*models.Issue, error) {
+	newIssue := models.NewIssue(fingerprint)
+	// An issue created concurrently with the same fingerprint is kept
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&newIssue).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var issue models.Issue
+	err = db.Where(models.Issue{Fingerprint: fingerprint}).First(&issue).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &issue, nil
+}
+
+func (r *issueRepository) UpdateWithEvent(issueId uint, columns map[string]any, event *models.IssueEvent) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		// The count and last seen date are updated concurrently by the ingestion, they must not be overwritten
+		err := tx.Model(&models.Issue{}).Where("id = ?", issueId).Updates(columns).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(event).Error
+	})
+}
+
+func (r *issueRepository) IsAcquiredByUser(issueId uint, userId uint) (bool, error) {
+	acquiredLogIds := r.getDb().Model(&encryption.Key{}).
+		Select("log_id").
+		Where(encryption.Key{
+			UserOwnerId: &userId,
+			UserGrant:   userGrant.Types.GrantPartialOwner,
+		})
+
+	var count int64
+	err := r.getDb().Model(&models.Log{}).
+		Where(models.Log{IssueId: &issueId}).
+		Where("id IN (?)", acquiredLogIds).
+		Count(&count).Error
+
+	return count > 0, err
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+)
+
+type issueEventRepository struct {
+	baseRepository[models.IssueEvent]
+}
+
+type IssueEventRepository interface {
+	BaseRepository[models.IssueEvent]
+	// GetByIssueId Returns the history of the issue, oldest first
+	GetByIssueId(issueId uint) ([]models.IssueEvent, error)
+}
+
+type IssueEventRepositoryProvider struct {
+}
+
+func (i IssueEventRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance IssueEventRepository = &issueEventRepository{baseRepository: newBaseRepository[models.IssueEvent](db)}
+	return instance
+}
+
+func (r *issueEventRepository) GetByIssueId(issueId uint) ([]models.IssueEvent, error) {
+	var events []models.IssueEvent
+	err := r.getDb().
+		Where(models.IssueEvent{IssueId: issueId}).
+		Order("id asc").
+		Find(&events).Error
+
+	return events, err
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/userGrant"
+	"strconv"
+)
+
+type issueNoteRepository struct {
+	baseRepository[models.IssueNote]
+}
+
+type IssueNoteRepository interface {
+	BaseRepository[models.IssueNote]
+	// GetByIssueId Returns the notes of the issue encrypted with one of the given levels, oldest first
+	GetByIssueId(issueId uint, levels []userGrant.Type) ([]models.IssueNote, error)
+	// SaveWithEvent Saves the note and the event of its addition in one transaction, the event points to the saved note
+	SaveWithEvent(note *models.IssueNote, event *models.IssueEvent) error
+}
+
+type IssueNoteRepositoryProvider struct {
+}
+
+func (i IssueNoteRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance IssueNoteRepository = &issueNoteRepository{baseRepository: newBaseRepository[models.IssueNote](db)}
+	return instance
+}
+
+func (r *issueNoteRepository) GetByIssueId(issueId uint, levels []userGrant.Type) ([]models.IssueNote, error) {
+	var notes []models.IssueNote
+	err := r.getDb().
+		Where(models.IssueNote{IssueId: issueId}).
+		Where("level IN ?", levels).
+		Order("id asc").
+		Find(&notes).Error
+
+	return notes, err
+}
+
+func (r *issueNoteRepository) SaveWithEvent(note *models.IssueNote, event *models.IssueEvent) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(note).Error
+		if err != nil {
+			return err
+		}
+
+		event.To = strconv.Itoa(int(note.ID))
+		return tx.Save(event).Error
+	})
+}
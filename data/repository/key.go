@@ -1,14 +1,9 @@
 package repository
 
 import (
-	"crypto/ecdsa"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"gorm.io/gorm"
-	"os"
-	"shareLog/config"
 	"shareLog/di"
+	"shareLog/models"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
 )
@@ -20,13 +15,33 @@ type keyRepository struct {
 type KeyRepository interface {
 	BaseRepository[encryption.Key]
 	GetPublicKey(t userGrant.Type) *encryption.PublicKey
-	GetJwePublicKey() (*rsa.PublicKey, error)
-	GetJWTPubKey() (*ecdsa.PublicKey, error)
-	GetJWTPrivateKey() (*ecdsa.PrivateKey, error)
-	GetJWEPrivateKey() (*rsa.PrivateKey, error)
 	GetUnacquiredSharedKey(userId uint, logId uint) (*encryption.Key, error)
 	GetUnacquiredSharedKeys(userId uint) ([]encryption.Key, error)
 	GetAcquiredSharedKeyForLogId(userId, logId uint) (*encryption.Key, error)
+	// GetCurrentGeneration Returns the newest generation of the keys with the given grant
+	GetCurrentGeneration(t userGrant.Type) (uint, error)
+	// GetDistributionKeys Returns the keys of rotated generations wrapped to the user, waiting to be acquired by them
+	GetDistributionKeys(userId uint, t userGrant.Type) ([]encryption.Key, error)
+	// GetHolderIds Returns the ids of the users holding a key with the given grant
+	GetHolderIds(t userGrant.Type) ([]uint, error)
+	// GetInviteTransportKeys Returns the personal keys of the pending invites holding a key with the given grant
+	GetInviteTransportKeys(t userGrant.Type) ([]encryption.Key, error)
+	// GetSharedKeyForLog Returns the key shared with the client who was given access to the log
+	GetSharedKeyForLog(logId uint) (*encryption.Key, error)
+	// GetAcquiredKeyForLog Returns the key a client acquired for the log, it holds the same key pair as the shared key
+	GetAcquiredKeyForLog(logId uint) (*encryption.Key, error)
+	GetByUserOwnerId(userId uint) ([]encryption.Key, error)
+	// GetLegacySharedKeys Returns the shared keys wrapped with the log sharing secret instead of their recipient's key
+	GetLegacySharedKeys() ([]encryption.Key, error)
+	// IsLogKeyAcquired Returns whether a user acquired a key for the log
+	IsLogKeyAcquired(logId uint) (bool, error)
+	/*
+		RevokeLegacySharedKey Deletes the legacy shared key and the copy of its log made for the client,
+		and makes the permission request of the log pending again, in one transaction
+	*/
+	RevokeLegacySharedKey(key *encryption.Key) error
+	// GetPersonalKey Returns the personal key of the user, or nil if they don't have one yet
+	GetPersonalKey(userId uint) (*encryption.Key, error)
 }
 
 type KeyRepositoryProvider struct {
@@ -41,7 +56,7 @@ func (k KeyRepositoryProvider) Provide() any {
 
 func (k *keyRepository) GetPublicKey(t userGrant.Type) *encryption.PublicKey {
 	var key encryption.Key
-	err := k.getDb().Where(&encryption.Key{UserGrant: t}).First(&key).Error
+	err := k.getDb().Where(&encryption.Key{UserGrant: t}).Order("generation desc").First(&key).Error
 	if err != nil {
 		println(err)
 		return nil
@@ -49,84 +64,6 @@ func (k *keyRepository) GetPublicKey(t userGrant.Type) *encryption.PublicKey {
 	return key.PublicKey
 }
 
-func (k *keyRepository) GetJWTPubKey() (*ecdsa.PublicKey, error) {
-	key, err := k.getPemPublicKey(config.GetKeyPaths().JwtPubKeyPath)
-
-	if err != nil {
-		return nil, err
-	}
-	castedKey := (key).(*ecdsa.PublicKey)
-	return castedKey, nil
-}
-
-func (k *keyRepository) GetJWTPrivateKey() (*ecdsa.PrivateKey, error) {
-	key, err := k.getPemPrivateKey(config.GetKeyPaths().JwtPkPath)
-
-	if err != nil {
-		return nil, err
-	}
-	castedKey := (key).(*ecdsa.PrivateKey)
-	return castedKey, nil
-}
-func (k *keyRepository) GetJWEPrivateKey() (*rsa.PrivateKey, error) {
-	key, err := k.getPemPrivateKey(config.GetKeyPaths().JwePkPath)
-
-	if err != nil {
-		return nil, err
-	}
-	castedKey := (key).(*rsa.PrivateKey)
-	return castedKey, nil
-}
-
-func (k *keyRepository) GetJwePublicKey() (*rsa.PublicKey, error) {
-	key, err := k.getPemPublicKey(config.GetKeyPaths().JwePubKeyPath)
-	if err != nil {
-		return nil, err
-	}
-	rsaKey := (key).(*rsa.PublicKey)
-	return rsaKey, nil
-}
-
-func (k *keyRepository) getPemPublicKey(path string) (any, error) {
-	// Read the public key file
-	keyBytes, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	pemDecoded, _ := pem.Decode(keyBytes)
-
-	key, err := x509.ParsePKIXPublicKey(pemDecoded.Bytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return key, nil
-}
-
-func (k *keyRepository) getPemPrivateKey(path string) (any, error) {
-	// Read the public key file
-	keyBytes, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	pemDecoded, _ := pem.Decode(keyBytes)
-
-	key, err := x509.ParsePKCS8PrivateKey(pemDecoded.Bytes)
-	if err != nil {
-		// try EC format instead of PKIX format
-		ecKey, err := x509.ParseECPrivateKey(pemDecoded.Bytes)
-		if err != nil {
-			return nil, err
-		}
-
-		return ecKey, nil
-	}
-
-	return key, nil
-}
-
 func (k *keyRepository) GetUnacquiredSharedKeys(userId uint) ([]encryption.Key, error) {
 	var keys []encryption.Key
 
@@ -141,6 +78,8 @@ func (k *keyRepository) GetUnacquiredSharedKeys(userId uint) ([]encryption.Key,
 	err := k.db.
 		Table("keys as main").
 		Where("user_owner_id IS NULL").
+		// Only keys wrapped to the user can be acquired
+		Where("recipient_id = ?", userId).
 		Where(encryption.Key{
 			UserGrant: userGrant.Types.GrantShared,
 		}).
@@ -164,6 +103,8 @@ func (k *keyRepository) GetUnacquiredSharedKey(userId uint, logId uint) (*encryp
 	err := k.db.
 		Table("keys as main").
 		Where("user_owner_id IS NULL").
+		// Only keys wrapped to the user can be acquired
+		Where("recipient_id = ?", userId).
 		Where(encryption.Key{
 			LogId:     &logId,
 			UserGrant: userGrant.Types.GrantShared,
@@ -187,3 +128,141 @@ func (k *keyRepository) GetAcquiredSharedKeyForLogId(userId, logId uint) (*encry
 
 	return &key, err
 }
+
+func (k *keyRepository) GetCurrentGeneration(t userGrant.Type) (uint, error) {
+	var generation uint
+	err := k.getDb().
+		Model(&encryption.Key{}).
+		Where(&encryption.Key{UserGrant: t}).
+		Select("COALESCE(MAX(generation), 0)").
+		Scan(&generation).Error
+
+	return generation, err
+}
+
+func (k *keyRepository) GetDistributionKeys(userId uint, t userGrant.Type) ([]encryption.Key, error) {
+	var keys []encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{UserGrant: t, RecipientId: &userId}).
+		Where("user_owner_id IS NULL AND invite_owner_id IS NULL AND log_id IS NULL").
+		Order("generation asc").
+		Find(&keys).Error
+
+	return keys, err
+}
+
+func (k *keyRepository) GetHolderIds(t userGrant.Type) ([]uint, error) {
+	var userIds []uint
+	err := k.getDb().
+		Model(&encryption.Key{}).
+		Where(&encryption.Key{UserGrant: t}).
+		Where("user_owner_id IS NOT NULL").
+		Distinct().
+		Pluck("user_owner_id", &userIds).Error
+
+	return userIds, err
+}
+
+func (k *keyRepository) GetInviteTransportKeys(t userGrant.Type) ([]encryption.Key, error) {
+	holderInviteIds := k.getDb().
+		Model(&encryption.Key{}).
+		Select("invite_owner_id").
+		Where(&encryption.Key{UserGrant: t}).
+		Where("invite_owner_id IS NOT NULL")
+
+	var keys []encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{UserGrant: userGrant.Types.GrantPersonal}).
+		Where("invite_owner_id IN (?)", holderInviteIds).
+		Find(&keys).Error
+
+	return keys, err
+}
+
+func (k *keyRepository) GetSharedKeyForLog(logId uint) (*encryption.Key, error) {
+	var key encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{LogId: &logId, UserGrant: userGrant.Types.GrantShared}).
+		First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (k *keyRepository) GetAcquiredKeyForLog(logId uint) (*encryption.Key, error) {
+	var key encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{LogId: &logId, UserGrant: userGrant.Types.GrantPartialOwner}).
+		First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (k *keyRepository) GetByUserOwnerId(userId uint) ([]encryption.Key, error) {
+	var keys []encryption.Key
+	err := k.getDb().Where(&encryption.Key{UserOwnerId: &userId}).Find(&keys).Error
+	return keys, err
+}
+
+func (k *keyRepository) GetLegacySharedKeys() ([]encryption.Key, error) {
+	var keys []encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{UserGrant: userGrant.Types.GrantShared}).
+		Where("recipient_id IS NULL AND user_owner_id IS NULL AND log_id IS NOT NULL").
+		Find(&keys).Error
+
+	return keys, err
+}
+
+func (k *keyRepository) IsLogKeyAcquired(logId uint) (bool, error) {
+	var count int64
+	err := k.getDb().
+		Model(&encryption.Key{}).
+		Where(&encryption.Key{LogId: &logId}).
+		Where("user_owner_id IS NOT NULL").
+		Count(&count).Error
+
+	return count != 0, err
+}
+
+func (k *keyRepository) RevokeLegacySharedKey(key *encryption.Key) error {
+	return k.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Delete(key).Error
+		if err != nil {
+			return err
+		}
+
+		copyIds := tx.Model(&models.Log{}).Select("id").Where("ref_log_id = ?", *key.LogId)
+		err = tx.Unscoped().Where("log_id IN (?)", copyIds).Delete(&models.LogDataKey{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("ref_log_id = ?", *key.LogId).Delete(&models.Log{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&models.PermissionRequest{}).
+			Where("log_id = ?", *key.LogId).
+			Update("status", models.PermissionRequestStatuses.Pending).Error
+	})
+}
+
+func (k *keyRepository) GetPersonalKey(userId uint) (*encryption.Key, error) {
+	var keys []encryption.Key
+	err := k.getDb().
+		Where(&encryption.Key{UserOwnerId: &userId, UserGrant: userGrant.Types.GrantPersonal}).
+		Limit(1).
+		Find(&keys).Error
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	return &keys[0], nil
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+)
+
+type keyRotationRepository struct {
+	baseRepository[models.KeyRotation]
+}
+
+type KeyRotationRepository interface {
+	BaseRepository[models.KeyRotation]
+	// GetAll Returns the rotations newest first
+	GetAll() ([]models.KeyRotation, error)
+	// GetUnfinished Returns the running, paused or failed rotation, or nil if there is none.
+	// A failed rotation can be resumed, so it blocks new ones like a paused one
+	GetUnfinished() *models.KeyRotation
+	// PauseRunning Marks the running rotations as paused
+	PauseRunning() error
+}
+
+type KeyRotationRepositoryProvider struct {
+}
+
+func (k KeyRotationRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance KeyRotationRepository = &keyRotationRepository{baseRepository: newBaseRepository[models.KeyRotation](db)}
+	return instance
+}
+
+func (r *keyRotationRepository) GetAll() ([]models.KeyRotation, error) {
+	var rotations []models.KeyRotation
+	err := r.getDb().Order("id desc").Find(&rotations).Error
+	return rotations, err
+}
+
+func (r *keyRotationRepository) GetUnfinished() *models.KeyRotation {
+	var rotation models.KeyRotation
+	err := r.getDb().
+		Where("status IN ?", []models.KeyRotationStatus{
+			models.KeyRotationStatuses.Running,
+			models.KeyRotationStatuses.Paused,
+			models.KeyRotationStatuses.Failed,
+		}).
+		First(&rotation).Error
+	if err != nil {
+		return nil
+	}
+
+	return &rotation
+}
+
+func (r *keyRotationRepository) PauseRunning() error {
+	return r.getDb().
+		Model(&models.KeyRotation{}).
+		Where(models.KeyRotation{Status: models.KeyRotationStatuses.Running}).
+		Update("status", models.KeyRotationStatuses.Paused).Error
+}
@@ -5,6 +5,8 @@ import (
 	"gorm.io/gorm"
 	"shareLog/di"
 	"shareLog/models"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
 )
 
 type logRepository struct {
@@ -14,6 +16,30 @@ type logRepository struct {
 type LogRepository interface {
 	BaseRepository[models.Log]
 	GetByRefId(logId uint) *models.Log
+	GetByIdempotencyKey(apiKeyId uint, idempotencyKey string) *models.Log
+	ClearIdempotencyKey(log *models.Log) error
+	// GetClientCopy Returns the copy of the log made for the client given access to it, or nil if there is none
+	GetClientCopy(logId uint) (*models.Log, error)
+	// SaveWithNewDataKey Saves the log and replaces its data key in one transaction
+	SaveWithNewDataKey(log *models.Log) error
+	// SaveClientCopy Saves the copy of a log made for a client and the shared key it is encrypted with in one transaction
+	SaveClientCopy(clientCopy *models.Log, sharedKey *encryption.Key) error
+	/*
+		SaveAllWithIssues Saves the logs in a single transaction and groups those with a fingerprint in the issue of
+		their fingerprint. The missing issues are created and the count and last seen date of the issues are updated
+		in the same transaction, so either everything is saved or nothing is
+	*/
+	SaveAllWithIssues(logs []*models.Log) error
+	// DeletePermanentlyWithRelations Hard deletes the logs together with their data keys, their client copies,
+	// their permission requests and every key tied to them. The logs are removed from the count of their issues
+	DeletePermanentlyWithRelations(logIds []uint) error
+	// CountOriginals Counts the logs sent by the apps with an id lower than the cursor, or all of them for a nil cursor
+	CountOriginals(cursor *uint) (int64, error)
+	// GetPageOfOriginals Returns the logs sent by the apps, leaving out the copies made for clients
+	GetPageOfOriginals(filter models.LogFilter, cursor *uint, limit int) (*Page[models.Log], error)
+	GetPageOfIssue(issueId uint, cursor *uint, limit int) (*Page[models.Log], error)
+	// GetPageOfAcquired Returns the logs the user has acquired a shared key for
+	GetPageOfAcquired(userId uint, filter models.LogFilter, cursor *uint, limit int) (*Page[models.Log], error)
 }
 
 type LogRepositoryProvider struct {
@@ -39,3 +65,247 @@ func (r *baseRepository[T]) GetByRefId(logId uint) *T {
 
 	return &result
 }
+
+func (r *logRepository) GetByIdempotencyKey(apiKeyId uint, idempotencyKey string) *models.Log {
+	var log models.Log
+	err := r.getDb().Where(models.Log{
+		ApiKeyId:       &apiKeyId,
+		IdempotencyKey: &idempotencyKey,
+	}).First(&log).Error
+
+	if err != nil {
+		return nil
+	}
+
+	return &log
+}
+
+// ClearIdempotencyKey Frees the idempotency key of the log so it can be reused
+func (r *logRepository) ClearIdempotencyKey(log *models.Log) error {
+	log.IdempotencyKey = nil
+	return r.getDb().Model(log).Update("idempotency_key", nil).Error
+}
+
+func (r *logRepository) GetClientCopy(logId uint) (*models.Log, error) {
+	var logs []models.Log
+	err := r.getDb().Where(models.Log{RefLogId: &logId}).Limit(1).Find(&logs).Error
+	if err != nil || len(logs) == 0 {
+		return nil, err
+	}
+
+	return &logs[0], nil
+}
+
+func (r *logRepository) SaveWithNewDataKey(log *models.Log) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("DataKey").Save(log).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("log_id = ?", log.ID).Delete(&models.LogDataKey{}).Error
+		if err != nil {
+			return err
+		}
+
+		log.DataKey.LogId = log.ID
+		return tx.Create(log.DataKey).Error
+	})
+}
+
+func (r *logRepository) SaveClientCopy(clientCopy *models.Log, sharedKey *encryption.Key) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(clientCopy).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(sharedKey).Error
+	})
+}
+
+func (r *logRepository) SaveAllWithIssues(logs []*models.Log) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		issuesByFingerprint := make(map[string]*models.Issue)
+		for _, log := range logs {
+			log.IssueId = nil
+			if log.IssueFingerprint == "" {
+				continue
+			}
+
+			logIssue, exists := issuesByFingerprint[log.IssueFingerprint]
+			if !exists {
+				var err error
+				logIssue, err = getOrCreateIssue(tx, log.IssueFingerprint)
+				if err != nil {
+					return err
+				}
+				issuesByFingerprint[log.IssueFingerprint] = logIssue
+			}
+
+			log.IssueId = &logIssue.ID
+		}
+
+		for _, log := range logs {
+			err := tx.Save(log).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, logIssue := range issuesByFingerprint {
+			var count int
+			lastSeen := logIssue.LastSeen
+			for _, log := range logs {
+				if log.IssueId != nil && *log.IssueId == logIssue.ID {
+					count++
+					if log.CreatedAt.After(lastSeen) {
+						lastSeen = log.CreatedAt
+					}
+				}
+			}
+
+			err := recordIssueOccurrences(tx, logIssue.ID, count, lastSeen)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *logRepository) DeletePermanentlyWithRelations(logIds []uint) error {
+	if len(logIds) == 0 {
+		return nil
+	}
+
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		err := removeFromIssueCounts(tx, logIds)
+		if err != nil {
+			return err
+		}
+
+		copyIds := tx.Model(&models.Log{}).Select("id").Where("ref_log_id IN ?", logIds)
+		err = tx.Unscoped().
+			Where("log_id IN ? OR log_id IN (?)", logIds, copyIds).
+			Delete(&models.LogDataKey{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("log_id IN ?", logIds).Delete(&encryption.Key{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("log_id IN ?", logIds).Delete(&models.PermissionRequest{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Unscoped().Where("ref_log_id IN ?", logIds).Delete(&models.Log{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("id IN ?", logIds).Delete(&models.Log{}).Error
+	})
+}
+
+// Subtracts the logs from the count of the issues grouping them
+func removeFromIssueCounts(tx *gorm.DB, logIds []uint) error {
+	var issueCounts []struct {
+		IssueId uint
+		Count   int
+	}
+	err := tx.Model(&models.Log{}).
+		Select("issue_id, COUNT(*) AS count").
+		Where("id IN ? AND issue_id IS NOT NULL", logIds).
+		Group("issue_id").
+		Scan(&issueCounts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, issueCount := range issueCounts {
+		err = tx.Model(&models.Issue{}).
+			Where("id = ?", issueCount.IssueId).
+			Update("count", gorm.Expr("CASE WHEN count > ? THEN count - ? ELSE 0 END", issueCount.Count, issueCount.Count)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func filterScope(filter models.LogFilter) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if filter.CreatedFrom != nil {
+			db = db.Where("created_at >= ?", *filter.CreatedFrom)
+		}
+		if filter.CreatedTo != nil {
+			db = db.Where("created_at <= ?", *filter.CreatedTo)
+		}
+		if filter.TimestampFrom != nil {
+			db = db.Where("timestamp >= ?", *filter.TimestampFrom)
+		}
+		if filter.TimestampTo != nil {
+			db = db.Where("timestamp <= ?", *filter.TimestampTo)
+		}
+		if filter.Severity != nil {
+			db = db.Where("severity = ?", filter.Severity.Name)
+		}
+		if filter.AppVersion != "" {
+			db = db.Where("app_version = ?", filter.AppVersion)
+		}
+		if filter.Platform != "" {
+			db = db.Where("platform = ?", filter.Platform)
+		}
+
+		return db
+	}
+}
+
+func originalsScope(db *gorm.DB) *gorm.DB {
+	return db.Where("ref_log_id IS NULL")
+}
+
+func (r *logRepository) CountOriginals(cursor *uint) (int64, error) {
+	var count int64
+	query := r.getDb().Model(&models.Log{}).Scopes(originalsScope)
+	if cursor != nil {
+		query = query.Where("id < ?", *cursor)
+	}
+
+	err := query.Count(&count).Error
+	return count, err
+}
+
+func (r *logRepository) GetPageOfOriginals(filter models.LogFilter, cursor *uint, limit int) (*Page[models.Log], error) {
+	return r.GetPage(cursor, limit, originalsScope, filterScope(filter))
+}
+
+func (r *logRepository) GetPageOfIssue(issueId uint, cursor *uint, limit int) (*Page[models.Log], error) {
+	issueScope := func(db *gorm.DB) *gorm.DB {
+		return db.Where(models.Log{IssueId: &issueId})
+	}
+
+	return r.GetPage(cursor, limit, originalsScope, issueScope)
+}
+
+func (r *logRepository) GetPageOfAcquired(userId uint, filter models.LogFilter, cursor *uint, limit int) (*Page[models.Log], error) {
+	acquiredLogIds := r.getDb().Model(&encryption.Key{}).
+		Select("log_id").
+		Where(encryption.Key{
+			UserOwnerId: &userId,
+			UserGrant:   userGrant.Types.GrantPartialOwner,
+		})
+
+	acquiredScope := func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN (?)", acquiredLogIds)
+	}
+
+	return r.GetPage(cursor, limit, originalsScope, acquiredScope, filterScope(filter))
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+)
+
+type logDataKeyRepository struct {
+	baseRepository[models.LogDataKey]
+}
+
+type LogDataKeyRepository interface {
+	BaseRepository[models.LogDataKey]
+	// GetByLogId Returns nil if the log has no data key
+	GetByLogId(logId uint) *models.LogDataKey
+}
+
+type LogDataKeyRepositoryProvider struct {
+}
+
+func (l LogDataKeyRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance LogDataKeyRepository = &logDataKeyRepository{baseRepository: newBaseRepository[models.LogDataKey](db)}
+	return instance
+}
+
+func (r *logDataKeyRepository) GetByLogId(logId uint) *models.LogDataKey {
+	var dataKey models.LogDataKey
+	err := r.getDb().Where(models.LogDataKey{LogId: logId}).First(&dataKey).Error
+	if err != nil {
+		return nil
+	}
+
+	return &dataKey
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+)
+
+type recoveryCodeRepository struct {
+	baseRepository[models.RecoveryCode]
+}
+
+type RecoveryCodeRepository interface {
+	BaseRepository[models.RecoveryCode]
+	GetUnusedByUserId(userId uint) ([]models.RecoveryCode, error)
+	GetUnusedByLookupId(userId uint, lookupId string) (*models.RecoveryCode, error)
+	// ReplaceForUser Deletes all the codes of the user and saves the new ones in one transaction
+	ReplaceForUser(userId uint, codes []models.RecoveryCode) error
+}
+
+type RecoveryCodeRepositoryProvider struct {
+}
+
+func (r RecoveryCodeRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance RecoveryCodeRepository = &recoveryCodeRepository{baseRepository: newBaseRepository[models.RecoveryCode](db)}
+	return instance
+}
+
+func (r *recoveryCodeRepository) GetUnusedByUserId(userId uint) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	err := r.getDb().
+		Where(models.RecoveryCode{UserId: userId}).
+		Where("used_at IS NULL").
+		Find(&codes).Error
+
+	return codes, err
+}
+
+func (r *recoveryCodeRepository) GetUnusedByLookupId(userId uint, lookupId string) (*models.RecoveryCode, error) {
+	var code models.RecoveryCode
+	err := r.getDb().
+		Where(models.RecoveryCode{UserId: userId, LookupId: lookupId}).
+		Where("used_at IS NULL").
+		First(&code).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+func (r *recoveryCodeRepository) ReplaceForUser(userId uint, codes []models.RecoveryCode) error {
+	return r.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
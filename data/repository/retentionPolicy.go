@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+)
+
+type retentionPolicyRepository struct {
+	baseRepository[models.RetentionPolicy]
+}
+
+type RetentionPolicyRepository interface {
+	BaseRepository[models.RetentionPolicy]
+	GetAll() ([]models.RetentionPolicy, error)
+}
+
+type RetentionPolicyRepositoryProvider struct {
+}
+
+func (r RetentionPolicyRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance RetentionPolicyRepository = &retentionPolicyRepository{
+		baseRepository: newBaseRepository[models.RetentionPolicy](db),
+	}
+	return instance
+}
+
+func (r *retentionPolicyRepository) GetAll() ([]models.RetentionPolicy, error) {
+	var policies []models.RetentionPolicy
+	err := r.getDb().Order("id asc").Find(&policies).Error
+	return policies, err
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+	"sync"
+	"time"
+)
+
+// How long a family found not revoked is trusted without checking the database again.
+// Families revoked by another instance keep working for at most this long
+const familyRevocationCacheLifetime = 10 * time.Second
+
+type sessionRepository struct {
+	baseRepository[models.Session]
+	lock sync.Mutex
+	// A revoked family stays revoked, so it is cached for good
+	revokedFamilies map[string]bool
+	// When each family was last found not revoked
+	checkedFamilies map[string]time.Time
+}
+
+type SessionRepository interface {
+	BaseRepository[models.Session]
+	GetByTokenHash(tokenHash string) *models.Session
+	// GetActiveByUserId Returns the latest session of each family of the user that is neither revoked nor expired
+	GetActiveByUserId(userId uint) ([]models.Session, error)
+	/*
+		Replace Marks the session replaced and saves the next session of its family in one transaction.
+		Returns false and saves nothing if the session was already replaced, by a concurrent refresh for example
+	*/
+	Replace(session *models.Session, nextSession *models.Session) (bool, error)
+	RevokeFamily(familyId string) error
+	// IsFamilyRevoked The result is cached, the revocations made by other instances are seen after a few seconds
+	IsFamilyRevoked(familyId string) (bool, error)
+	// DeleteExpired Deletes the expired sessions of the user, their refresh tokens can't be used anymore
+	DeleteExpired(userId uint) error
+}
+
+type SessionRepositoryProvider struct {
+}
+
+func (s SessionRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance SessionRepository = &sessionRepository{
+		baseRepository:  newBaseRepository[models.Session](db),
+		revokedFamilies: make(map[string]bool),
+		checkedFamilies: make(map[string]time.Time),
+	}
+	return instance
+}
+
+func (s *sessionRepository) GetByTokenHash(tokenHash string) *models.Session {
+	var session models.Session
+	err := s.getDb().Where(models.Session{TokenHash: tokenHash}).First(&session).Error
+	if err != nil {
+		return nil
+	}
+
+	return &session
+}
+
+func (s *sessionRepository) GetActiveByUserId(userId uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.getDb().
+		Where(models.Session{UserId: userId}).
+		Where("replaced_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+func (s *sessionRepository) Replace(session *models.Session, nextSession *models.Session) (bool, error) {
+	now := time.Now()
+	isReplaced := false
+	err := s.getDb().Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&models.Session{}).
+			Where("id = ? AND replaced_at IS NULL", session.ID).
+			Update("replaced_at", now)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return result.Error
+		}
+
+		isReplaced = true
+		return tx.Save(nextSession).Error
+	})
+	if err != nil || !isReplaced {
+		return false, err
+	}
+
+	session.ReplacedAt = &now
+	return true, nil
+}
+
+func (s *sessionRepository) RevokeFamily(familyId string) error {
+	err := s.getDb().
+		Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	s.cacheRevocation(familyId, true)
+	return nil
+}
+
+func (s *sessionRepository) cacheRevocation(familyId string, isRevoked bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if isRevoked {
+		s.revokedFamilies[familyId] = true
+		delete(s.checkedFamilies, familyId)
+		return
+	}
+
+	now := time.Now()
+	s.checkedFamilies[familyId] = now
+	// Drop the stale entries so the cache doesn't grow with every family ever checked
+	for checkedFamilyId, checkedAt := range s.checkedFamilies {
+		if now.Sub(checkedAt) > familyRevocationCacheLifetime {
+			delete(s.checkedFamilies, checkedFamilyId)
+		}
+	}
+}
+
+// Returns whether the family is revoked and whether the answer was cached
+func (s *sessionRepository) getCachedRevocation(familyId string) (bool, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.revokedFamilies[familyId] {
+		return true, true
+	}
+
+	checkedAt, exists := s.checkedFamilies[familyId]
+	return false, exists && time.Since(checkedAt) <= familyRevocationCacheLifetime
+}
+
+func (s *sessionRepository) IsFamilyRevoked(familyId string) (bool, error) {
+	isRevoked, isCached := s.getCachedRevocation(familyId)
+	if isCached {
+		return isRevoked, nil
+	}
+
+	var revokedCount int64
+	err := s.getDb().
+		Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NOT NULL", familyId).
+		Count(&revokedCount).Error
+	if err != nil {
+		return false, err
+	}
+
+	s.cacheRevocation(familyId, revokedCount != 0)
+	return revokedCount != 0, nil
+}
+
+func (s *sessionRepository) DeleteExpired(userId uint) error {
+	return s.getDb().
+		Unscoped().
+		Where("user_id = ? AND expires_at < ?", userId, time.Now()).
+		Delete(&models.Session{}).Error
+}
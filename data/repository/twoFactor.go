@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+	"time"
+)
+
+type twoFactorRepository struct {
+	baseRepository[models.TwoFactor]
+}
+
+type TwoFactorRepository interface {
+	BaseRepository[models.TwoFactor]
+	GetByUserId(userId uint) *models.TwoFactor
+	/*
+		UseStep Records the time step of an accepted code.
+		Returns false if the step or a later one was already used, by a concurrent request for example
+	*/
+	UseStep(twoFactor *models.TwoFactor, step int64) (bool, error)
+	// DeleteForUser Deletes the enrollment and the backup codes of the user
+	DeleteForUser(userId uint) error
+	GetUnusedBackupCodes(userId uint) ([]models.TwoFactorBackupCode, error)
+	// UseBackupCode Returns false if the code was already used
+	UseBackupCode(code *models.TwoFactorBackupCode) (bool, error)
+	// ReplaceBackupCodes Deletes all the backup codes of the user and saves the new ones in one transaction
+	ReplaceBackupCodes(userId uint, codes []models.TwoFactorBackupCode) error
+}
+
+type TwoFactorRepositoryProvider struct {
+}
+
+func (t TwoFactorRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance TwoFactorRepository = &twoFactorRepository{baseRepository: newBaseRepository[models.TwoFactor](db)}
+	return instance
+}
+
+func (t *twoFactorRepository) GetByUserId(userId uint) *models.TwoFactor {
+	var twoFactor models.TwoFactor
+	err := t.getDb().Where(models.TwoFactor{UserId: userId}).First(&twoFactor).Error
+	if err != nil {
+		return nil
+	}
+
+	return &twoFactor
+}
+
+func (t *twoFactorRepository) UseStep(twoFactor *models.TwoFactor, step int64) (bool, error) {
+	result := t.getDb().
+		Model(&models.TwoFactor{}).
+		Where("id = ? AND last_used_step < ?", twoFactor.ID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	twoFactor.LastUsedStep = step
+	return result.RowsAffected == 1, nil
+}
+
+func (t *twoFactorRepository) DeleteForUser(userId uint) error {
+	return t.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("user_id = ?", userId).Delete(&models.TwoFactorBackupCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("user_id = ?", userId).Delete(&models.TwoFactor{}).Error
+	})
+}
+
+func (t *twoFactorRepository) GetUnusedBackupCodes(userId uint) ([]models.TwoFactorBackupCode, error) {
+	var codes []models.TwoFactorBackupCode
+	err := t.getDb().
+		Where(models.TwoFactorBackupCode{UserId: userId}).
+		Where("used_at IS NULL").
+		Find(&codes).Error
+
+	return codes, err
+}
+
+func (t *twoFactorRepository) UseBackupCode(code *models.TwoFactorBackupCode) (bool, error) {
+	now := time.Now()
+	result := t.getDb().
+		Model(&models.TwoFactorBackupCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	code.UsedAt = &now
+	return result.RowsAffected == 1, nil
+}
+
+func (t *twoFactorRepository) ReplaceBackupCodes(userId uint, codes []models.TwoFactorBackupCode) error {
+	return t.getDb().Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("user_id = ?", userId).Delete(&models.TwoFactorBackupCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/userGrant"
+)
+
+type twoFactorPolicyRepository struct {
+	baseRepository[models.TwoFactorPolicy]
+}
+
+type TwoFactorPolicyRepository interface {
+	BaseRepository[models.TwoFactorPolicy]
+	GetAll() ([]models.TwoFactorPolicy, error)
+	// GetByGrant Returns nil when no policy was set for the grant
+	GetByGrant(grant userGrant.Type) *models.TwoFactorPolicy
+}
+
+type TwoFactorPolicyRepositoryProvider struct {
+}
+
+func (t TwoFactorPolicyRepositoryProvider) Provide() any {
+	var db = di.Get[*gorm.DB]()
+	var instance TwoFactorPolicyRepository = &twoFactorPolicyRepository{baseRepository: newBaseRepository[models.TwoFactorPolicy](db)}
+	return instance
+}
+
+func (t *twoFactorPolicyRepository) GetAll() ([]models.TwoFactorPolicy, error) {
+	var policies []models.TwoFactorPolicy
+	err := t.getDb().Order("id asc").Find(&policies).Error
+	return policies, err
+}
+
+func (t *twoFactorPolicyRepository) GetByGrant(grant userGrant.Type) *models.TwoFactorPolicy {
+	var policy models.TwoFactorPolicy
+	err := t.getDb().Where(&models.TwoFactorPolicy{Grant: grant}).First(&policy).Error
+	if err != nil {
+		return nil
+	}
+
+	return &policy
+}
@@ -4,6 +4,7 @@ import (
 	"gorm.io/gorm"
 	"shareLog/di"
 	"shareLog/models"
+	"time"
 )
 
 type userRepository struct {
@@ -20,6 +21,16 @@ type UserRepository interface {
 	BaseRepository[models.User]
 	GetByIdWithPrivateKeys(id uint) *models.User
 	GetByEmail(email string) (*models.User, error)
+	// SaveWithEncryptionKeys Saves the user and updates its encryption keys and recovery codes in one transaction
+	SaveWithEncryptionKeys(user *models.User) error
+	UpdateKeyVerifierHash(user *models.User) error
+	/*
+		SaveRecoveredUser Marks the recovery code as used and saves the user with its keys and recovery codes in one transaction.
+		Returns false without saving anything if the code was already used, by a concurrent recovery for example
+	*/
+	SaveRecoveredUser(user *models.User, usedCode *models.RecoveryCode) (bool, error)
+	// CountLegacyKdf Returns the number of users still on the legacy KDF parameters and the number of users
+	CountLegacyKdf() (int64, int64, error)
 }
 
 type UserRepositoryProvider struct {
@@ -35,3 +46,78 @@ func (u *userRepository) GetByEmail(email string) (*models.User, error) {
 	err := u.getDb().Where("email = ?", email).First(&user).Error
 	return &user, err
 }
+
+func (u *userRepository) SaveWithEncryptionKeys(user *models.User) error {
+	return u.getDb().Transaction(func(tx *gorm.DB) error {
+		return saveWithEncryptionKeys(tx, user)
+	})
+}
+
+func (u *userRepository) UpdateKeyVerifierHash(user *models.User) error {
+	return u.getDb().Model(user).Update("key_verifier_hash", user.KeyVerifierHash).Error
+}
+
+func saveWithEncryptionKeys(tx *gorm.DB, user *models.User) error {
+	err := tx.Omit("EncryptionKeys", "RecoveryCodes").Save(user).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range user.EncryptionKeys {
+		err = tx.Save(&user.EncryptionKeys[i]).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range user.RecoveryCodes {
+		err = tx.Save(&user.RecoveryCodes[i]).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *userRepository) SaveRecoveredUser(user *models.User, usedCode *models.RecoveryCode) (bool, error) {
+	isClaimed := false
+	err := u.getDb().Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.
+			Model(&models.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", usedCode.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected != 1 {
+			return nil
+		}
+
+		usedCode.UsedAt = &now
+		isClaimed = true
+		return saveWithEncryptionKeys(tx, user)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return isClaimed, nil
+}
+
+func (u *userRepository) CountLegacyKdf() (int64, int64, error) {
+	var total int64
+	err := u.getDb().Model(&models.User{}).Count(&total).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var legacy int64
+	err = u.getDb().Model(&models.User{}).
+		Where("kdf_algorithm IS NULL OR kdf_algorithm = ''").
+		Count(&legacy).Error
+
+	return legacy, total, err
+}
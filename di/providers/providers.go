@@ -5,8 +5,12 @@ import (
 	"shareLog/controllers/auth"
 	"shareLog/controllers/base"
 	"shareLog/controllers/config"
+	"shareLog/controllers/issue"
+	"shareLog/controllers/keyRotation"
 	"shareLog/controllers/log"
 	"shareLog/controllers/logPermissionRequest"
+	"shareLog/controllers/retention"
+	"shareLog/controllers/wellKnown"
 	"shareLog/data"
 	"shareLog/data/repository"
 	"shareLog/di"
@@ -17,14 +21,28 @@ import (
 
 func InitDi() {
 	diLib.RegisterProvider[repository.KeyRepository](di.Container, repository.KeyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.KeyEncryptionBackend](di.Container, services.KeyEncryptionBackendProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.ServerKeys](di.Container, services.ServerKeysProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[*gorm.DB](di.Container, data.DatabaseProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[services.Crypto](di.Container, services.CryptoProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[services.Logger](di.Container, services.LoggerProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[repository.LogRepository](di.Container, repository.LogRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.LogDataKeyRepository](di.Container, repository.LogDataKeyRepositoryProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[repository.UserRepository](di.Container, repository.UserRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.SessionRepository](di.Container, repository.SessionRepositoryProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[services.Auth](di.Container, services.AuthProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.Sessions](di.Container, services.SessionsProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.TwoFactorRepository](di.Container, repository.TwoFactorRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.TwoFactorPolicyRepository](di.Container, repository.TwoFactorPolicyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.TwoFactor](di.Container, services.TwoFactorProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.AttemptCounterRepository](di.Container, repository.AttemptCounterRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.AttemptStore](di.Container, services.AttemptStoreProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.AttemptLimiter](di.Container, services.AttemptLimiterProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.ApiKeys](di.Container, services.ApiKeysProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[middleware.Auth](di.Container, middleware.AuthProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[middleware.Grant](di.Container, middleware.GrantProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[middleware.Scope](di.Container, middleware.ScopeProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[middleware.Compression](di.Container, middleware.CompressionProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[base.BaseController](di.Container, base.BaseControllerProvider{}, diLib.FactoryProvider)
 	diLib.RegisterProvider[repository.InviteRepository](di.Container, repository.InviteRepositoryProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[auth.Controller](di.Container, auth.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
@@ -35,5 +53,20 @@ func InitDi() {
 	diLib.RegisterProvider[services.Mailer](di.Container, services.MailerProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[services.KeyManager](di.Container, services.KeyManagerProvider{}, diLib.SingletonProvider)
 	diLib.RegisterProvider[repository.ApiKeyRepository](di.Container, repository.ApiKeyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.IssueRepository](di.Container, repository.IssueRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.Issue](di.Container, services.IssueProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.IssueNoteRepository](di.Container, repository.IssueNoteRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.IssueEventRepository](di.Container, repository.IssueEventRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.IssueWorkflow](di.Container, services.IssueWorkflowProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[issue.Controller](di.Container, issue.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
+	diLib.RegisterProvider[repository.RetentionPolicyRepository](di.Container, repository.RetentionPolicyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.Retention](di.Container, services.RetentionProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[retention.Controller](di.Container, retention.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
+	diLib.RegisterProvider[repository.RecoveryCodeRepository](di.Container, repository.RecoveryCodeRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.Recovery](di.Container, services.RecoveryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.KeyRotationRepository](di.Container, repository.KeyRotationRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[services.KeyRotation](di.Container, services.KeyRotationProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[keyRotation.Controller](di.Container, keyRotation.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
 	diLib.RegisterProvider[config.Controller](di.Container, config.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
+	diLib.RegisterProvider[wellKnown.Controller](di.Container, wellKnown.ControllerProvider{}, diLib.SingletonProvider, diLib.Binding[base.LoadableController]{})
 }
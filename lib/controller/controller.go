@@ -9,6 +9,10 @@ import (
 )
 
 func GetUser(c *gin.Context, authService services.Auth) *models.User {
+	if user, exists := c.Get(constants.ContextUser); exists {
+		return user.(*models.User)
+	}
+
 	jwt, exists := c.Get(constants.ContextJWTKey)
 	if !exists {
 		c.Status(401)
@@ -13,35 +13,9 @@ const derivePasswordHashIter = 32
 const derivePasswordKeyLen = 32
 
 /*
-*
-@returns cypher text, iv, err
+PerformSymmetricDecryption decrypts data encrypted with the legacy AES-CBC scheme.
+New data is sealed in envelopes, see SealEnvelope
 */
-func PerformSymmetricEncryption(data string, key []byte) (string, string, error) {
-	paddedKeyBytes := Pad(key, aesKeyLength)
-	// Save a new cipher block
-	block, err := aes.NewCipher(paddedKeyBytes)
-	if err != nil {
-		return "", "", err
-	}
-
-	iv := make([]byte, block.BlockSize())
-	_, err = rand.Read(iv)
-	if err != nil {
-		return "", "", err
-	}
-
-	// Pad the data to the block size
-	paddedData := Pad([]byte(data), block.BlockSize())
-	// Save a new cipher
-	cipherText := make([]byte, len(paddedData))
-	// Save a new CBC encrypter
-	encrypter := cipher.NewCBCEncrypter(block, iv)
-	// Encrypt the data
-	encrypter.CryptBlocks(cipherText, paddedData)
-	// Return the encrypted data
-	return string(cipherText), string(iv), nil
-}
-
 func PerformSymmetricDecryption(cipherText string, plainTextLen int, iv string, key []byte) (string, error) {
 	// Convert the key to a byte array
 	paddedKeyBytes := Pad(key, aesKeyLength)
@@ -80,6 +54,12 @@ func Unpad(src []byte, unpaddedLength int) []byte {
 	return src[:len(src)-padding]
 }
 
+func GenerateRandomKey() ([]byte, error) {
+	key := make([]byte, aesKeyLength)
+	_, err := rand.Read(key)
+	return key, err
+}
+
 func HashPassword(password string, salt string) (string, error) {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.DefaultCost)
 	return string(hashedBytes), err
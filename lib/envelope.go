@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// EnvelopeAlgorithm Identifies the cipher an envelope was sealed with
+type EnvelopeAlgorithm byte
+
+const (
+	EnvelopeAes256Gcm EnvelopeAlgorithm = 1
+)
+
+// DefaultKeyVersion The key version of envelopes sealed with keys that were never rotated
+const DefaultKeyVersion uint32 = 1
+
+const envelopeFormatVersion byte = 1
+
+// Format version, algorithm and key version
+const envelopeHeaderSize = 1 + 1 + 4
+const gcmTagSize = 16
+
+/*
+Envelope is a self-describing authenticated cipher text.
+Encoded, it is the base64 of: format version (1 byte), algorithm (1 byte), key version (4 bytes, big endian),
+nonce, cipher text and tag. The header is authenticated together with the cipher text
+*/
+type Envelope struct {
+	Algorithm  EnvelopeAlgorithm
+	KeyVersion uint32
+	Nonce      []byte
+	CipherText []byte
+	Tag        []byte
+}
+
+func newAead(algorithm EnvelopeAlgorithm, key []byte) (cipher.AEAD, error) {
+	if algorithm != EnvelopeAes256Gcm {
+		return nil, Error{Msg: "Unknown envelope algorithm"}
+	}
+
+	if len(key) != aesKeyLength {
+		return nil, Error{Msg: "Invalid envelope key length"}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (e *Envelope) header() []byte {
+	header := make([]byte, envelopeHeaderSize)
+	header[0] = envelopeFormatVersion
+	header[1] = byte(e.Algorithm)
+	binary.BigEndian.PutUint32(header[2:], e.KeyVersion)
+	return header
+}
+
+func (e *Envelope) String() string {
+	encoded := e.header()
+	encoded = append(encoded, e.Nonce...)
+	encoded = append(encoded, e.CipherText...)
+	encoded = append(encoded, e.Tag...)
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+func (e *Envelope) Open(key []byte) ([]byte, error) {
+	aead, err := newAead(e.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(append([]byte{}, e.CipherText...), e.Tag...)
+	return aead.Open(nil, e.Nonce, sealed, e.header())
+}
+
+// ParseEnvelope Decodes an envelope without decrypting it
+func ParseEnvelope(encoded string) (*Envelope, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) < envelopeHeaderSize || decoded[0] != envelopeFormatVersion {
+		return nil, Error{Msg: "Invalid envelope"}
+	}
+
+	envelope := Envelope{
+		Algorithm:  EnvelopeAlgorithm(decoded[1]),
+		KeyVersion: binary.BigEndian.Uint32(decoded[2:envelopeHeaderSize]),
+	}
+	if envelope.Algorithm != EnvelopeAes256Gcm {
+		return nil, Error{Msg: "Unknown envelope algorithm"}
+	}
+
+	body := decoded[envelopeHeaderSize:]
+	nonceSize := 12
+	if len(body) < nonceSize+gcmTagSize {
+		return nil, Error{Msg: "Invalid envelope"}
+	}
+
+	envelope.Nonce = body[:nonceSize]
+	envelope.CipherText = body[nonceSize : len(body)-gcmTagSize]
+	envelope.Tag = body[len(body)-gcmTagSize:]
+	return &envelope, nil
+}
+
+/*
+SealEnvelope encrypts the data with AES-256-GCM.
+The key must be 32 bytes long.
+@returns the encoded envelope, err
+*/
+func SealEnvelope(data []byte, key []byte, keyVersion uint32) (string, error) {
+	envelope := Envelope{Algorithm: EnvelopeAes256Gcm, KeyVersion: keyVersion}
+	aead, err := newAead(envelope.Algorithm, key)
+	if err != nil {
+		return "", err
+	}
+
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(envelope.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nil, envelope.Nonce, data, envelope.header())
+	envelope.CipherText = sealed[:len(sealed)-aead.Overhead()]
+	envelope.Tag = sealed[len(sealed)-aead.Overhead():]
+	return envelope.String(), nil
+}
+
+func OpenEnvelope(encoded string, key []byte) ([]byte, error) {
+	envelope, err := ParseEnvelope(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope.Open(key)
+}
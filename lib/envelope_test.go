@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	key, err := GenerateRandomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := GenerateRandomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealEnvelope([]byte("secret"), key, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Algorithm != EnvelopeAes256Gcm || envelope.KeyVersion != 7 {
+		t.Errorf("algorithm = %d, key version = %d", envelope.Algorithm, envelope.KeyVersion)
+	}
+
+	opened, err := OpenEnvelope(sealed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, []byte("secret")) {
+		t.Errorf("opened = %q", opened)
+	}
+
+	_, err = OpenEnvelope(sealed, otherKey)
+	if err == nil {
+		t.Error("the envelope was opened with another key")
+	}
+
+	// The header is authenticated, a changed key version must not open
+	envelope.KeyVersion = 8
+	_, err = OpenEnvelope(envelope.String(), key)
+	if err == nil {
+		t.Error("the envelope was opened with a tampered header")
+	}
+
+	_, err = SealEnvelope([]byte("secret"), key[:16], DefaultKeyVersion)
+	if err == nil {
+		t.Error("the envelope was sealed with a short key")
+	}
+
+	_, err = ParseEnvelope("AQ==")
+	if err == nil {
+		t.Error("a truncated envelope was parsed")
+	}
+}
@@ -0,0 +1,41 @@
+package stackTrace
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+Swift and Objective-C crash logs:
+
+	0   MyApp        0x0000000100a1b2c4 MyApp.ViewController.crash() -> () + 52 (ViewController.swift:30)
+	1   UIKitCore    0x00000001b2c3d4e5 -[UIApplication sendAction:to:from:forEvent:] + 96
+*/
+var appleFrameRegex = regexp.MustCompile(`^\s*\d+\s+(\S+)\s+0x[0-9a-fA-F]+\s+(.+?)(?:\s+\+\s+\d+)?(?:\s+\(([^()]+?):(\d+)\))?$`)
+
+var appleSystemModules = []string{
+	"libsystem_", "libdispatch", "libdyld", "dyld", "libobjc", "libswift", "libc++",
+	"CoreFoundation", "Foundation", "UIKit", "UIKitCore", "GraphicsServices", "QuartzCore",
+	"CFNetwork", "SwiftUI", "AppKit", "WebKit",
+}
+
+func parseApple(lines []string) []Frame {
+	frames := make([]Frame, 0)
+	for _, line := range lines {
+		match := appleFrameRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		module := match[1]
+		frames = append(frames, Frame{
+			Module:   module,
+			Function: strings.TrimPrefix(match[2], module+"."),
+			File:     match[3],
+			Line:     parseLineNumber(match[4]),
+			InApp:    !hasAnyPrefix(module, appleSystemModules),
+		})
+	}
+
+	return frames
+}
@@ -0,0 +1,44 @@
+package stackTrace
+
+/*
+Frame is a single call of a parsed stack trace
+
+	Module: The package, class or binary the function belongs to
+	Line: 0 if the stack trace doesn't include it
+	InApp: False for frames of the runtime, the standard library or well known third party code
+*/
+type Frame struct {
+	Module   string
+	Function string
+	File     string
+	Line     int
+	InApp    bool
+}
+
+type parser func(lines []string) []Frame
+
+var parsers = []parser{
+	parseGo,
+	parseJava,
+	parseJavaScript,
+	parsePython,
+	parseApple,
+}
+
+/*
+Parse returns the frames of a stack trace, innermost call first.
+Every known format is tried and the one recognizing the most frames wins.
+An unrecognized stack trace returns no frames.
+*/
+func Parse(stackTrace string) []Frame {
+	lines := splitLines(stackTrace)
+	frames := make([]Frame, 0)
+	for _, parse := range parsers {
+		parsedFrames := parse(lines)
+		if len(parsedFrames) > len(frames) {
+			frames = parsedFrames
+		}
+	}
+
+	return frames
+}
@@ -0,0 +1,64 @@
+package stackTrace
+
+import (
+	"regexp"
+	"strings"
+)
+
+// main.main() or github.com/org/repo/pkg.(*Type).Method(0x1, ...)
+var goFunctionRegex = regexp.MustCompile(`^(?:created by )?(\S.*?)(?:\([^()]*\))?(?: in goroutine \d+)?$`)
+
+// \t/path/to/file.go:12 +0x1d
+var goLocationRegex = regexp.MustCompile(`^\s+(\S+\.go):(\d+)(?:\s+\+0x[0-9a-f]+)?$`)
+
+/*
+Go panics print each frame on two lines: the function, then its file and line
+*/
+func parseGo(lines []string) []Frame {
+	frames := make([]Frame, 0)
+	for i := 0; i+1 < len(lines); i++ {
+		location := goLocationRegex.FindStringSubmatch(lines[i+1])
+		if location == nil || strings.HasPrefix(lines[i], "goroutine ") {
+			continue
+		}
+
+		function := goFunctionRegex.FindStringSubmatch(lines[i])
+		if function == nil {
+			continue
+		}
+
+		module, functionName := splitGoFunction(function[1])
+		frames = append(frames, Frame{
+			Module:   module,
+			Function: functionName,
+			File:     location[1],
+			Line:     parseLineNumber(location[2]),
+			InApp:    isGoInApp(module),
+		})
+		i++
+	}
+
+	return frames
+}
+
+// Splits github.com/org/repo/pkg.(*Type).Method into the package path and the function
+func splitGoFunction(qualifiedName string) (string, string) {
+	lastSlash := strings.LastIndex(qualifiedName, "/")
+	dot := strings.Index(qualifiedName[lastSlash+1:], ".")
+	if dot == -1 {
+		return "", qualifiedName
+	}
+
+	splitAt := lastSlash + 1 + dot
+	return qualifiedName[:splitAt], qualifiedName[splitAt+1:]
+}
+
+// Standard library packages are the only ones without a domain in their first path element
+func isGoInApp(module string) bool {
+	if module == "main" {
+		return true
+	}
+
+	firstElement, _, _ := strings.Cut(module, "/")
+	return strings.Contains(firstElement, ".")
+}
@@ -0,0 +1,41 @@
+package stackTrace
+
+import (
+	"strconv"
+	"strings"
+)
+
+func splitLines(stackTrace string) []string {
+	normalized := strings.ReplaceAll(stackTrace, "\r\n", "\n")
+	return strings.Split(normalized, "\n")
+}
+
+// Returns 0 if the line number is missing or malformed
+func parseLineNumber(line string) int {
+	lineNumber, err := strconv.Atoi(line)
+	if err != nil {
+		return 0
+	}
+
+	return lineNumber
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+
+	return false
+}
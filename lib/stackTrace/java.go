@@ -0,0 +1,53 @@
+package stackTrace
+
+import (
+	"regexp"
+	"strings"
+)
+
+// at java.base/java.lang.Thread.run(Thread.java:833) or at com.app.Foo.bar(Foo.kt:12)
+var javaFrameRegex = regexp.MustCompile(`^\s*at\s+(?:[\w.$-]+/)?([\w.$<>-]+)\.([\w$<>-]+)\(([^:)]*)(?::(\d+))?\)`)
+
+const javaCausedByPrefix = "Caused by:"
+
+var javaNotInAppPrefixes = []string{
+	"java.", "javax.", "jdk.", "sun.", "com.sun.",
+	"kotlin.", "kotlinx.",
+	"android.", "androidx.", "com.android.", "dalvik.", "libcore.",
+}
+
+/*
+Java and Kotlin exceptions, including the frames of their "Caused by" chains.
+Each cause is printed after the exception it caused, so the causes are put first:
+the frames of the root cause come before the frames of the exceptions wrapping it
+*/
+func parseJava(lines []string) []Frame {
+	causes := [][]Frame{make([]Frame, 0)}
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), javaCausedByPrefix) {
+			causes = append(causes, make([]Frame, 0))
+			continue
+		}
+
+		match := javaFrameRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		last := len(causes) - 1
+		causes[last] = append(causes[last], Frame{
+			Module:   match[1],
+			Function: match[2],
+			File:     match[3],
+			Line:     parseLineNumber(match[4]),
+			InApp:    !hasAnyPrefix(match[1], javaNotInAppPrefixes),
+		})
+	}
+
+	frames := make([]Frame, 0)
+	for i := len(causes) - 1; i >= 0; i-- {
+		frames = append(frames, causes[i]...)
+	}
+
+	return frames
+}
@@ -0,0 +1,39 @@
+package stackTrace
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// V8: at foo (/app/index.js:1:2), at async foo (/app/index.js:1:2) or at /app/index.js:1:2
+var v8FrameRegex = regexp.MustCompile(`^\s*at\s+(?:(?:async\s+)?(.+?)\s+\()?(.+?):(\d+):(\d+)\)?$`)
+
+// Firefox and Safari: foo@https://app.com/index.js:1:2
+var geckoFrameRegex = regexp.MustCompile(`^\s*(.*?)@(.+?):(\d+):(\d+)$`)
+
+var javaScriptNotInAppMarkers = []string{"node_modules/", "node:", "internal/", "<anonymous>"}
+
+func parseJavaScript(lines []string) []Frame {
+	frames := make([]Frame, 0)
+	for _, line := range lines {
+		match := v8FrameRegex.FindStringSubmatch(line)
+		if match == nil {
+			match = geckoFrameRegex.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+
+		file := match[2]
+		frames = append(frames, Frame{
+			Module:   strings.TrimSuffix(path.Base(file), path.Ext(file)),
+			Function: match[1],
+			File:     file,
+			Line:     parseLineNumber(match[3]),
+			InApp:    !containsAny(file, javaScriptNotInAppMarkers),
+		})
+	}
+
+	return frames
+}
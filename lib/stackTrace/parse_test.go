@@ -0,0 +1,118 @@
+package stackTrace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		stackTrace string
+		want       []Frame
+	}{
+		{
+			name: "go panic",
+			stackTrace: `panic: runtime error: index out of range [3] with length 3
+
+goroutine 1 [running]:
+github.com/org/app/handler.(*Server).Serve(0xc000010000, {0x0, 0x0})
+	/home/dev/app/handler/server.go:42 +0x1d
+net/http.HandlerFunc.ServeHTTP(0x0?, {0x0?, 0x0?}, 0x0?)
+	/usr/local/go/src/net/http/server.go:2136 +0x29
+main.main()
+	/home/dev/app/main.go:12 +0x25`,
+			want: []Frame{
+				{Module: "github.com/org/app/handler", Function: "(*Server).Serve", File: "/home/dev/app/handler/server.go", Line: 42, InApp: true},
+				{Module: "net/http", Function: "HandlerFunc.ServeHTTP", File: "/usr/local/go/src/net/http/server.go", Line: 2136, InApp: false},
+				{Module: "main", Function: "main", File: "/home/dev/app/main.go", Line: 12, InApp: true},
+			},
+		},
+		{
+			name: "java exception",
+			stackTrace: `java.lang.IllegalStateException: Boom
+	at com.app.Foo.bar(Foo.java:12)
+	at java.base/java.lang.Thread.run(Thread.java:833)`,
+			want: []Frame{
+				{Module: "com.app.Foo", Function: "bar", File: "Foo.java", Line: 12, InApp: true},
+				{Module: "java.lang.Thread", Function: "run", File: "Thread.java", Line: 833, InApp: false},
+			},
+		},
+		{
+			name: "java exception with a cause puts the root cause first",
+			stackTrace: `java.lang.RuntimeException: Wrapped
+	at com.app.Service.call(Service.kt:20)
+	at com.app.Main.main(Main.kt:5)
+Caused by: java.io.IOException: Disk full
+	at java.io.FileOutputStream.write(FileOutputStream.java:326)
+	at com.app.Storage.save(Storage.kt:8)
+	... 2 more`,
+			want: []Frame{
+				{Module: "java.io.FileOutputStream", Function: "write", File: "FileOutputStream.java", Line: 326, InApp: false},
+				{Module: "com.app.Storage", Function: "save", File: "Storage.kt", Line: 8, InApp: true},
+				{Module: "com.app.Service", Function: "call", File: "Service.kt", Line: 20, InApp: true},
+				{Module: "com.app.Main", Function: "main", File: "Main.kt", Line: 5, InApp: true},
+			},
+		},
+		{
+			name: "python traceback is reversed",
+			stackTrace: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in <module>
+    run()
+  File "/usr/lib/python3.11/site-packages/requests/api.py", line 59, in request
+    return session.request(method=method, url=url, **kwargs)
+  File "/app/worker.py", line 3, in run
+    raise ValueError("bad")
+ValueError: bad`,
+			want: []Frame{
+				{Module: "worker", Function: "run", File: "/app/worker.py", Line: 3, InApp: true},
+				{Module: "api", Function: "request", File: "/usr/lib/python3.11/site-packages/requests/api.py", Line: 59, InApp: false},
+				{Module: "main", Function: "<module>", File: "/app/main.py", Line: 10, InApp: true},
+			},
+		},
+		{
+			name: "v8 javascript",
+			stackTrace: `TypeError: Cannot read properties of undefined (reading 'id')
+    at getUser (/app/src/users.js:14:21)
+    at async handle (/app/node_modules/express/lib/router.js:95:5)
+    at /app/src/index.js:3:1`,
+			want: []Frame{
+				{Module: "users", Function: "getUser", File: "/app/src/users.js", Line: 14, InApp: true},
+				{Module: "router", Function: "handle", File: "/app/node_modules/express/lib/router.js", Line: 95, InApp: false},
+				{Module: "index", Function: "", File: "/app/src/index.js", Line: 3, InApp: true},
+			},
+		},
+		{
+			name: "gecko javascript",
+			stackTrace: `render@https://app.com/static/app.js:10:5
+@https://app.com/static/vendor.js:1:200`,
+			want: []Frame{
+				{Module: "app", Function: "render", File: "https://app.com/static/app.js", Line: 10, InApp: true},
+				{Module: "vendor", Function: "", File: "https://app.com/static/vendor.js", Line: 1, InApp: true},
+			},
+		},
+		{
+			name: "apple crash log",
+			stackTrace: `0   MyApp        0x0000000100a1b2c4 MyApp.ViewController.crash() -> () + 52 (ViewController.swift:30)
+1   UIKitCore    0x00000001b2c3d4e5 -[UIApplication sendAction:to:from:forEvent:] + 96`,
+			want: []Frame{
+				{Module: "MyApp", Function: "ViewController.crash() -> ()", File: "ViewController.swift", Line: 30, InApp: true},
+				{Module: "UIKitCore", Function: "-[UIApplication sendAction:to:from:forEvent:]", File: "", Line: 0, InApp: false},
+			},
+		},
+		{
+			name:       "unrecognized stack trace",
+			stackTrace: "something went wrong",
+			want:       []Frame{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Parse(test.stackTrace)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package stackTrace
+
+import (
+	"path"
+	"regexp"
+	"shareLog/lib"
+	"strings"
+)
+
+// File "/app/main.py", line 10, in <module>
+var pythonFrameRegex = regexp.MustCompile(`^\s*File "(.+)", line (\d+), in (.+)$`)
+
+var pythonNotInAppMarkers = []string{"site-packages", "dist-packages", "/lib/python", "<frozen "}
+
+/*
+Python tracebacks list the innermost call last, so the frames are reversed
+*/
+func parsePython(lines []string) []Frame {
+	frames := make([]Frame, 0)
+	for _, line := range lines {
+		match := pythonFrameRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		file := match[1]
+		frames = append(frames, Frame{
+			Module:   strings.TrimSuffix(path.Base(file), ".py"),
+			Function: strings.TrimSpace(match[3]),
+			File:     file,
+			Line:     parseLineNumber(match[2]),
+			InApp:    !containsAny(file, pythonNotInAppMarkers),
+		})
+	}
+
+	return lib.Reverse(frames)
+}
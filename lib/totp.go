@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters, the defaults of authenticator apps
+const totpSecretSize = 20 // bytes
+const totpPeriod = 30     // seconds
+const totpDigits = 6
+
+// Codes of the previous and next time steps are accepted too, for clock skew
+const totpSkewSteps = 1
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret Returns a new base32 encoded secret
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TotpUri Returns the otpauth URI authenticator apps enroll with, usually shown as a QR code
+func TotpUri(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("period", fmt.Sprint(totpPeriod))
+	query.Set("digits", fmt.Sprint(totpDigits))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range totpDigits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, truncated%modulo)
+}
+
+/*
+ValidateTotpCode Returns the time step the code was generated for, if it is valid at the given time.
+Callers should reject steps that were already used, so a code can't be replayed
+*/
+func ValidateTotpCode(secret string, code string, now time.Time) (int64, bool) {
+	secretBytes, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	currentStep := now.Unix() / totpPeriod
+	for step := currentStep - totpSkewSteps; step <= currentStep+totpSkewSteps; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secretBytes, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"io"
 	"os"
+	"shareLog/config"
 	"shareLog/controllers"
+	"shareLog/di"
 	"shareLog/di/providers"
+	"shareLog/lib"
+	"shareLog/services"
 )
 
 const shouldLoadLocalEnvArgIndex = 1
+const commandArgIndex = 2
+
+// Reads a secret or key file from stdin and prints it wrapped with the key encryption backend
+const wrapSecretCommand = "wrap-secret"
 
 func loadLocalEnv() {
 	if os.Args[shouldLoadLocalEnvArgIndex] == "true" {
@@ -16,10 +26,34 @@ func loadLocalEnv() {
 	}
 }
 
+func wrapSecret() {
+	secret, err := io.ReadAll(os.Stdin)
+	lib.PanicOnError(err, "Failed to read the secret")
+
+	wrappedSecret, err := di.Get[services.ServerKeys]().WrapSecret(secret)
+	lib.PanicOnError(err, "Failed to wrap the secret")
+	fmt.Println(wrappedSecret)
+}
+
 func main() {
 	loadLocalEnv()
+	lib.PanicOnError(config.GetKdfConfig().Validate(), "Invalid KDF config")
 	providers.InitDi()
+	if len(os.Args) > commandArgIndex && os.Args[commandArgIndex] == wrapSecretCommand {
+		wrapSecret()
+		return
+	}
+
+	_, err := di.Get[services.ServerKeys]().GetFingerprintSecret()
+	lib.PanicOnError(err, "Failed to load the fingerprint secret")
+	_, err = di.Get[services.ServerKeys]().GetSignInParamsSecret()
+	lib.PanicOnError(err, "Failed to load the sign-in params secret")
+	lib.PanicOnError(di.Get[services.KeyRotation]().PauseInterruptedRotations(), "Failed to pause interrupted key rotations")
+	lib.PanicOnError(di.Get[services.ApiKeys]().HashPlaintextKeys(), "Failed to hash the plaintext api keys")
+	lib.PanicOnError(di.Get[services.KeyManager]().MigrateLegacySharedKeys(), "Failed to migrate the legacy shared keys")
+	di.Get[services.Retention]().StartPurger()
 	engine := gin.Default()
+	lib.PanicOnError(engine.SetTrustedProxies(config.GetServerConfig().TrustedProxies), "Invalid trusted proxies")
 	controllers.LoadAllController(engine)
 	engine.Run()
 }
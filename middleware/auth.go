@@ -14,6 +14,7 @@ type auth struct {
 	userRepository repository.UserRepository
 	cryptoService  services.Crypto
 	authService    services.Auth
+	apiKeyService  services.ApiKeys
 }
 
 type Auth interface {
@@ -28,11 +29,13 @@ func (p AuthProvider) Provide() any {
 
 	cryptoService := di.Get[services.Crypto]()
 	authService := di.Get[services.Auth]()
+	apiKeyService := di.Get[services.ApiKeys]()
 
 	authMiddleware := auth{
 		userRepository: userRepository,
 		cryptoService:  cryptoService,
 		authService:    authService,
+		apiKeyService:  apiKeyService,
 	}
 
 	return &authMiddleware
@@ -81,17 +84,31 @@ func (a *auth) authUser(authHeaderVal string, c *gin.Context) *jwt.Token {
 		return nil
 	}
 
+	user := a.authService.GetUserIfNotRevoked(*parsedJwt)
+	if user == nil {
+		c.Status(401)
+		c.Abort()
+		return nil
+	}
+
+	// The handlers reuse the user loaded to check the token instead of loading it again
+	c.Set(constants.ContextUser, user)
 	return parsedJwt
 }
 
 func (a *auth) authApp(apiKey string, c *gin.Context) *jwt.Token {
-	appJwt, err := a.authService.GenerateAppAuthToken(apiKey)
-
+	apiKeyModel, err := a.apiKeyService.Authenticate(apiKey)
 	if err != nil {
 		c.Status(401)
 		c.Abort()
 		return nil
 	}
 
-	return appJwt
+	err = a.apiKeyService.RecordUse(apiKeyModel)
+	if err != nil {
+		println(err.Error())
+	}
+
+	c.Set(constants.ContextApiKey, apiKeyModel)
+	return a.authService.GenerateAppAuthToken(apiKeyModel)
 }
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"shareLog/config"
+	"shareLog/constants"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"strings"
+)
+
+type compression struct {
+	zstdEncoder *zstd.Encoder
+	config      config.CompressionConfig
+}
+
+type Compression interface {
+	// DecompressBody Transparently decompresses gzip and zstd request bodies
+	DecompressBody(c *gin.Context)
+	// CompressResponse Compresses big responses if the client accepts it
+	CompressResponse(c *gin.Context)
+}
+
+type CompressionProvider struct {
+}
+
+func (p CompressionProvider) Provide() any {
+	// An encoder without a writer is only used through EncodeAll, which is safe for concurrent use
+	zstdEncoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic("failed to create zstd encoder")
+	}
+
+	return &compression{
+		zstdEncoder: zstdEncoder,
+		config:      config.GetCompressionConfig(),
+	}
+}
+
+// multiCloseReadCloser Reads from the decompressor and closes it along with the underlying body
+type multiCloseReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (l multiCloseReadCloser) Close() error {
+	for _, closer := range l.closers {
+		err := closer.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *compression) DecompressBody(c *gin.Context) {
+	maxSize := int64(m.config.MaxDecompressedBodySize)
+	body := c.Request.Body
+	encoding := strings.ToLower(strings.TrimSpace(c.GetHeader(constants.ContentEncodingHeader)))
+
+	var decompressed io.ReadCloser
+	switch encoding {
+	case "", constants.IdentityEncoding:
+		decompressed = body
+	case constants.GzipEncoding:
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+			return
+		}
+		decompressed = multiCloseReadCloser{gzipReader, []io.Closer{gzipReader, body}}
+	case constants.ZstdEncoding:
+		zstdReader, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err != nil {
+			c.AbortWithStatusJSON(400, models.GetResponse(nil, &dto.Error{Code: 400, Message: err.Error()}))
+			return
+		}
+		decompressed = multiCloseReadCloser{zstdReader, []io.Closer{zstdReader.IOReadCloser(), body}}
+	default:
+		c.AbortWithStatusJSON(415, models.GetResponse(nil, &dto.Error{Code: 415, Message: "Unsupported content encoding"}))
+		return
+	}
+
+	// Guards against decompression bombs: reading past the limit fails
+	c.Request.Body = http.MaxBytesReader(c.Writer, decompressed, maxSize)
+	c.Request.Header.Del(constants.ContentEncodingHeader)
+	c.Request.Header.Del(constants.ContentLengthHeader)
+	c.Request.ContentLength = -1
+	c.Next()
+}
+
+// bufferedWriter Holds back the response body so it can be compressed once the handler is done
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body    *bytes.Buffer
+	written bool
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	// The headers are written once the body is flushed
+	w.written = true
+}
+
+// Written Report if the handler has written the response, even though nothing was sent to the client yet
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+// Return the preferred encoding the client accepts, or "" if it accepts none of ours
+func getAcceptedEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		params = strings.ReplaceAll(params, " ", "")
+		if params == "q=0" || params == "q=0.0" {
+			continue
+		}
+		accepted[strings.ToLower(encoding)] = true
+	}
+
+	for _, encoding := range []string{constants.ZstdEncoding, constants.GzipEncoding} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+
+	return ""
+}
+
+func (m *compression) compress(data []byte, encoding string) ([]byte, error) {
+	if encoding == constants.ZstdEncoding {
+		return m.zstdEncoder.EncodeAll(data, nil), nil
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gzipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+func (m *compression) CompressResponse(c *gin.Context) {
+	encoding := getAcceptedEncoding(c.GetHeader(constants.AcceptEncodingHeader))
+	if encoding == "" {
+		c.Next()
+		return
+	}
+
+	originalWriter := c.Writer
+	writer := &bufferedWriter{ResponseWriter: originalWriter, body: &bytes.Buffer{}}
+	c.Writer = writer
+	c.Next()
+	c.Writer = originalWriter
+
+	body := writer.body.Bytes()
+	originalWriter.Header().Add(constants.VaryHeader, constants.AcceptEncodingHeader)
+	if len(body) >= m.config.MinCompressedResponseSize {
+		compressed, err := m.compress(body, encoding)
+		if err == nil {
+			originalWriter.Header().Set(constants.ContentEncodingHeader, encoding)
+			originalWriter.Header().Del(constants.ContentLengthHeader)
+			body = compressed
+		}
+	}
+
+	if len(body) == 0 {
+		return
+	}
+
+	_, _ = originalWriter.Write(body)
+}
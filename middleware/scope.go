@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"shareLog/constants"
+	"shareLog/models"
+	"shareLog/models/dto"
+)
+
+type scope struct {
+}
+
+type Scope interface {
+	/*
+		CheckApiKeyScope Rejects the requests authenticated with an api key missing any of the scopes,
+		and all of them when no scope is given. Users aren't limited by scopes
+	*/
+	CheckApiKeyScope(c *gin.Context, scopes ...models.ApiKeyScope)
+}
+
+type ScopeProvider struct {
+}
+
+func (p ScopeProvider) Provide() any {
+	return &scope{}
+}
+
+func (s *scope) CheckApiKeyScope(c *gin.Context, scopes ...models.ApiKeyScope) {
+	apiKey, exists := c.Get(constants.ContextApiKey)
+	if !exists {
+		c.Next()
+		return
+	}
+
+	if len(scopes) == 0 {
+		c.AbortWithStatusJSON(403, models.GetResponse(nil, &dto.Error{
+			Code:    403,
+			Message: "Api keys can't access this route",
+		}))
+		return
+	}
+
+	for _, scope := range scopes {
+		if !apiKey.(*models.ApiKey).Scopes.Has(scope) {
+			c.AbortWithStatusJSON(403, models.GetResponse(nil, &dto.Error{
+				Code:    403,
+				Message: "The api key is missing the scope " + scope.Name,
+			}))
+			return
+		}
+	}
+
+	c.Next()
+}
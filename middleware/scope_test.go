@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"shareLog/constants"
+	"shareLog/models"
+	"testing"
+)
+
+func TestCheckApiKeyScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	uploadOnlyApiKey := &models.ApiKey{Scopes: models.ApiKeyScopeList{models.ApiKeyScopes.LogsWrite}}
+	tests := []struct {
+		name       string
+		apiKey     *models.ApiKey
+		scopes     []models.ApiKeyScope
+		wantStatus int
+	}{
+		{name: "user", scopes: []models.ApiKeyScope{models.ApiKeyScopes.LogsBatch}, wantStatus: http.StatusOK},
+		{name: "user on a route closed to api keys", wantStatus: http.StatusOK},
+		{name: "api key with the scope", apiKey: uploadOnlyApiKey, scopes: []models.ApiKeyScope{models.ApiKeyScopes.LogsWrite}, wantStatus: http.StatusOK},
+		{name: "api key missing the scope", apiKey: uploadOnlyApiKey, scopes: []models.ApiKeyScope{models.ApiKeyScopes.LogsBatch}, wantStatus: http.StatusForbidden},
+		{
+			name:       "api key missing one of the scopes",
+			apiKey:     uploadOnlyApiKey,
+			scopes:     []models.ApiKeyScope{models.ApiKeyScopes.LogsWrite, models.ApiKeyScopes.LogsBatch},
+			wantStatus: http.StatusForbidden,
+		},
+		{name: "api key on a route closed to api keys", apiKey: uploadOnlyApiKey, wantStatus: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				if test.apiKey != nil {
+					c.Set(constants.ContextApiKey, test.apiKey)
+				}
+				(&scope{}).CheckApiKeyScope(c, test.scopes...)
+			}, func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+		})
+	}
+}
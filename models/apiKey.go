@@ -2,13 +2,54 @@ package models
 
 import (
 	"gorm.io/gorm"
+	"shareLog/models/dto"
 	"shareLog/models/encryption"
+	"time"
 )
 
+/*
+ApiKey authenticates an app uploading logs. The key given to the app is a public prefix and a secret,
+only its keyed hash is stored
+
+	Prefix: Public part of the key, to find the api key without the secret. Generated for the keys from before the prefixes,
+	those are still sent without one and found by their hash
+	UserId: The user who created the key. Zero for keys created before it was recorded, only owners manage those
+	Name: Label to tell the keys apart
+	Scopes: What the requests authenticated with the key can do. Keys created before the scopes can upload logs
+	ExpiresAt: The key stops working after this. Nil for keys that don't expire
+	PreviousPrefix, PreviousKeyHash: The key replaced by the last rotation, working until PreviousKeyExpiresAt so the apps can switch over
+	Key, PreviousKey: Plaintext keys from before they were hashed. Emptied when the server starts
+*/
 type ApiKey struct {
 	gorm.Model
-	Key             string
-	EncryptionKeyId uint
+	Prefix               string `gorm:"index"`
+	KeyHash              string `gorm:"index"`
+	UserId               uint   `gorm:"index"`
+	Name                 string
+	Scopes               ApiKeyScopeList `gorm:"default:'logs:write logs:batch'"`
+	ExpiresAt            *time.Time
+	LastUsedAt           *time.Time
+	PreviousPrefix       string `gorm:"index"`
+	PreviousKeyHash      string `gorm:"index"`
+	PreviousKeyExpiresAt *time.Time
+	RotatedAt            *time.Time
+	Key                  string
+	PreviousKey          string
+	EncryptionKeyId      uint
 	// Client level encryption key to use on the app client
 	EncryptionKey *encryption.Key `gorm:"foreignKey:EncryptionKeyId"`
 }
+
+func (a ApiKey) ToDto() dto.ApiKeyInfo {
+	return dto.ApiKeyInfo{
+		Id:                   a.ID,
+		Prefix:               a.Prefix,
+		Name:                 a.Name,
+		Scopes:               a.Scopes.Names(),
+		CreatedAt:            a.CreatedAt,
+		ExpiresAt:            a.ExpiresAt,
+		LastUsedAt:           a.LastUsedAt,
+		RotatedAt:            a.RotatedAt,
+		PreviousKeyExpiresAt: a.PreviousKeyExpiresAt,
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+)
+
+// ApiKeyScope What a request authenticated with an api key is allowed to do
+type ApiKeyScope struct {
+	Name string
+}
+
+const logsWriteScope = "logs:write"
+const logsBatchScope = "logs:batch"
+
+type ApiKeyScopeMap struct {
+	// LogsWrite Upload logs one by one
+	LogsWrite ApiKeyScope
+	// LogsBatch Upload logs in batches
+	LogsBatch ApiKeyScope
+}
+
+var ApiKeyScopes = ApiKeyScopeMap{
+	LogsWrite: ApiKeyScope{logsWriteScope},
+	LogsBatch: ApiKeyScope{logsBatchScope},
+}
+
+func (m *ApiKeyScopeMap) GetByName(name string) *ApiKeyScope {
+	switch name {
+	case logsWriteScope:
+		return &ApiKeyScopes.LogsWrite
+	case logsBatchScope:
+		return &ApiKeyScopes.LogsBatch
+	default:
+		return nil
+	}
+}
+
+// ApiKeyScopeList is stored as the names of the scopes separated by spaces
+type ApiKeyScopeList []ApiKeyScope
+
+const apiKeyScopeSeparator = " "
+
+func (l ApiKeyScopeList) Has(scope ApiKeyScope) bool {
+	for _, listScope := range l {
+		if listScope == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l ApiKeyScopeList) Names() []string {
+	names := make([]string, 0, len(l))
+	for _, scope := range l {
+		names = append(names, scope.Name)
+	}
+
+	return names
+}
+
+func (l *ApiKeyScopeList) Scan(src any) error {
+	names, ok := src.(string)
+	if !ok {
+		return errors.New("Api key scopes must be string.")
+	}
+
+	scopes := ApiKeyScopeList{}
+	for _, name := range strings.Fields(names) {
+		scope := ApiKeyScopes.GetByName(name)
+		if scope == nil {
+			return errors.New("Unknown api key scope.")
+		}
+
+		scopes = append(scopes, *scope)
+	}
+
+	*l = scopes
+	return nil
+}
+
+func (l ApiKeyScopeList) Value() (driver.Value, error) {
+	return strings.Join(l.Names(), apiKeyScopeSeparator), nil
+}
+
+func (l ApiKeyScopeList) GormDataType() string {
+	return "text"
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+AttemptCounter The failed attempts of an account, IP or invite
+
+	Key: What the attempts are counted for, e.g. "account:<email>"
+	LockedUntil: No attempt is accepted before this time
+*/
+type AttemptCounter struct {
+	gorm.Model
+	Key           string `gorm:"uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
@@ -1,5 +1,45 @@
 package dto
 
+import "time"
+
+// ApiKey The key is only sent back when it is created or rotated, the server only keeps its hash
 type ApiKey struct {
+	Id  uint   `json:"id"`
 	Key string `json:"key"`
 }
+
+type CreateApiKey struct {
+	// Scopes Nil for the scopes uploading logs
+	Scopes []string `json:"scopes"`
+}
+
+type ApiKeyInfo struct {
+	Id uint `json:"id"`
+	// Prefix The public part of the key, to tell which key an app uses
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RotatedAt  *time.Time `json:"rotatedAt"`
+	// PreviousKeyExpiresAt Until when the key replaced by the last rotation works, nil if it was never rotated
+	PreviousKeyExpiresAt *time.Time `json:"previousKeyExpiresAt"`
+}
+
+/*
+UpdateApiKey leaves the fields that are nil unchanged
+
+	NoExpiry: Removes the expiry of the key, ExpiresAt is ignored
+*/
+type UpdateApiKey struct {
+	Name      *string    `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	NoExpiry  bool       `json:"noExpiry"`
+}
+
+type RotateApiKey struct {
+	// GracePeriodMinutes How long the previous key keeps working. Nil for the configured default
+	GracePeriodMinutes *int `json:"gracePeriodMinutes"`
+}
@@ -8,6 +8,18 @@ type FirstUserSignup struct {
 type Login struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	/*
+		ZeroKnowledge The token won't carry the user symmetric key, so the server can't decrypt data for this session.
+		The password isn't sent, the key verifier derived from it on the client is sent instead
+	*/
+	ZeroKnowledge bool   `json:"zeroKnowledge"`
+	KeyVerifier   string `json:"keyVerifier"`
+}
+
+// SignInParams How the client derives the user symmetric key from the password, for zero-knowledge sign-ins
+type SignInParams struct {
+	Salt string `json:"salt"`
+	Kdf  Kdf    `json:"kdf"`
 }
 
 type Signup struct {
@@ -18,5 +30,32 @@ type Signup struct {
 }
 
 type SignInResponse struct {
-	Token string `json:"token"`
+	// Empty when a second factor is required, the challenge token has to be completed instead
+	Token string `json:"token,omitempty"`
+	// Exchanged for a new token and a new refresh token at /auth/refresh. Each refresh token can only be used once
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// Only set when the codes are generated, they are never shown again
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// Sent with a TOTP or backup code to /auth/signin/2fa to get the token
+	ChallengeToken string `json:"challengeToken,omitempty"`
+	// A policy requires two-factor authentication but the user isn't enrolled yet.
+	// They enroll with the challenge token at /auth/signin/2fa/enroll and /auth/signin/2fa/confirm
+	EnrollmentRequired bool `json:"enrollmentRequired,omitempty"`
+	// Only set when two-factor authentication is enabled during the sign-in
+	BackupCodes []string `json:"backupCodes,omitempty"`
+}
+
+type ChangePassword struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+type Recover struct {
+	Email        string `json:"email"`
+	RecoveryCode string `json:"recoveryCode"`
+	NewPassword  string `json:"newPassword"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
 }
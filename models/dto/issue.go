@@ -0,0 +1,44 @@
+package dto
+
+import "time"
+
+type Issue struct {
+	Id         uint      `json:"id"`
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Count      uint      `json:"count"`
+	Status     string    `json:"status"`
+	AssigneeId *uint     `json:"assigneeId"`
+}
+
+type IssueStatusUpdate struct {
+	Status string `json:"status"`
+}
+
+type IssueAssigneeUpdate struct {
+	// AssigneeId Nil to unassign the issue
+	AssigneeId *uint `json:"assigneeId"`
+}
+
+type CreateIssueNote struct {
+	Content string `json:"content"`
+	// Level "owner" or "client". Defaults to the grant of the author
+	Level string `json:"level"`
+}
+
+type IssueNote struct {
+	Id        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	AuthorId  uint      `json:"authorId"`
+	Level     string    `json:"level"`
+	Content   string    `json:"content"`
+}
+
+type IssueEvent struct {
+	Id        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	ActorId   uint      `json:"actorId"`
+	Type      string    `json:"type"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+}
@@ -0,0 +1,19 @@
+package dto
+
+import "time"
+
+type KeyRotation struct {
+	Id         uint      `json:"id"`
+	Grant      string    `json:"grant"`
+	Generation uint      `json:"generation"`
+	Status     string    `json:"status"`
+	Total      int64     `json:"total"`
+	Processed  int64     `json:"processed"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type StartKeyRotation struct {
+	Grant string `json:"grant"`
+}
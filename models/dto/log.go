@@ -1,5 +1,69 @@
 package dto
 
+import "time"
+
+/*
+Log is the payload sent by the apps and returned to the users.
+
+	Severity, Timestamp, AppVersion and Platform are stored in plaintext so logs can be indexed and filtered.
+	Every other field is double encrypted before being stored.
+*/
 type Log struct {
-	StackTrace string `json:"stackTrace"`
+	StackTrace  string            `json:"stackTrace"`
+	Message     string            `json:"message"`
+	Severity    string            `json:"severity"`
+	Timestamp   *time.Time        `json:"timestamp"`
+	AppVersion  string            `json:"appVersion"`
+	Platform    string            `json:"platform"`
+	DeviceModel string            `json:"deviceModel"`
+	Tags        map[string]string `json:"tags"`
+	// Frames The parsed stack trace. Only returned, it is ignored on upload
+	Frames []Frame `json:"frames,omitempty"`
+	// IdempotencyKey Replaying a log with the same key returns the original log instead of creating a new one
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type Frame struct {
+	Module   string `json:"module"`
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	InApp    bool   `json:"inApp"`
+}
+
+type CreatedLog struct {
+	Id uint `json:"id"`
+}
+
+type LogSummary struct {
+	Id         uint      `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Severity   string    `json:"severity"`
+	Timestamp  time.Time `json:"timestamp"`
+	AppVersion string    `json:"appVersion"`
+	Platform   string    `json:"platform"`
+	IssueId    *uint     `json:"issueId"`
+}
+
+type LogListQuery struct {
+	PageQuery
+	CreatedFrom   *time.Time `form:"createdFrom" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedTo     *time.Time `form:"createdTo" time_format:"2006-01-02T15:04:05Z07:00"`
+	TimestampFrom *time.Time `form:"timestampFrom" time_format:"2006-01-02T15:04:05Z07:00"`
+	TimestampTo   *time.Time `form:"timestampTo" time_format:"2006-01-02T15:04:05Z07:00"`
+	Severity      string     `form:"severity"`
+	AppVersion    string     `form:"appVersion"`
+	Platform      string     `form:"platform"`
+}
+
+/*
+BatchLogResult is the outcome of saving one log of a batch
+
+	Index: The position of the log in the batch
+	Id: The id of the created log. Nil if the log was rejected
+*/
+type BatchLogResult struct {
+	Index int    `json:"index"`
+	Id    *uint  `json:"id"`
+	Error *Error `json:"error"`
 }
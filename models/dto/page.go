@@ -0,0 +1,11 @@
+package dto
+
+type Page[T any] struct {
+	Items      []T   `json:"items"`
+	NextCursor *uint `json:"nextCursor"`
+}
+
+type PageQuery struct {
+	Cursor *uint `form:"cursor"`
+	Limit  int   `form:"limit"`
+}
@@ -0,0 +1,19 @@
+package dto
+
+type RetentionPolicy struct {
+	Id       uint    `json:"id"`
+	ApiKeyId *uint   `json:"apiKeyId"`
+	Severity *string `json:"severity"`
+	Days     uint    `json:"days"`
+}
+
+type CreateRetentionPolicy struct {
+	ApiKeyId *uint   `json:"apiKeyId"`
+	Severity *string `json:"severity"`
+	Days     uint    `json:"days"`
+}
+
+type RetentionReport struct {
+	ExpiredLogCount int    `json:"expiredLogCount"`
+	ExpiredLogIds   []uint `json:"expiredLogIds"`
+}
@@ -0,0 +1,56 @@
+package dto
+
+import "time"
+
+// Kdf How a symmetric key is derived from a password and a salt. Empty for the legacy PBKDF2 derivation
+type Kdf struct {
+	Algorithm   string `json:"algorithm"`
+	Iterations  uint32 `json:"iterations"`
+	MemoryKiB   uint32 `json:"memoryKiB"`
+	Parallelism uint8  `json:"parallelism"`
+}
+
+/*
+WrappedKey is a key of the user, with the private key still wrapped with the user symmetric key.
+
+	PublicKey: Hex of the compressed public key
+	WrappedPrivateKey: The envelope sealing the hex of the private key
+	WrappedToPersonalKey: The private key is encrypted with the personal key of the user instead,
+	for the rotated generations the user didn't acquire yet
+*/
+type WrappedKey struct {
+	Id                   uint   `json:"id"`
+	Grant                string `json:"grant"`
+	Generation           uint   `json:"generation"`
+	LogId                *uint  `json:"logId,omitempty"`
+	PublicKey            string `json:"publicKey"`
+	WrappedPrivateKey    string `json:"wrappedPrivateKey"`
+	WrappedToPersonalKey bool   `json:"wrappedToPersonalKey,omitempty"`
+}
+
+// UserKeys The keys of the user and how to derive the user symmetric key unwrapping them from the password
+type UserKeys struct {
+	Salt string       `json:"salt"`
+	Kdf  Kdf          `json:"kdf"`
+	Keys []WrappedKey `json:"keys"`
+}
+
+/*
+SealedLog is a log as stored, for clients decrypting logs themselves. The encrypted values are base64 encoded.
+
+	Id: The id the log was requested with. For clients it is the id of the original log, not of their copy
+	WrappedDataKey: The data key, wrapped with the client key and then with the owner or shared key of the log.
+	Empty for logs whose fields are wrapped that way directly
+*/
+type SealedLog struct {
+	Id             uint      `json:"id"`
+	Severity       string    `json:"severity"`
+	Timestamp      time.Time `json:"timestamp"`
+	AppVersion     string    `json:"appVersion"`
+	Platform       string    `json:"platform"`
+	WrappedDataKey string    `json:"wrappedDataKey,omitempty"`
+	StackTrace     string    `json:"stackTrace"`
+	Message        string    `json:"message"`
+	DeviceModel    string    `json:"deviceModel"`
+	Tags           string    `json:"tags"`
+}
@@ -0,0 +1,19 @@
+package dto
+
+import "time"
+
+type Session struct {
+	Id            uint      `json:"id"`
+	Device        string    `json:"device"`
+	Ip            string    `json:"ip"`
+	ZeroKnowledge bool      `json:"zeroKnowledge"`
+	StartedAt     time.Time `json:"startedAt"`
+	LastUsedAt    time.Time `json:"lastUsedAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	// Current The session of the token used for the request
+	Current bool `json:"current"`
+}
+
+type RefreshSession struct {
+	RefreshToken string `json:"refreshToken"`
+}
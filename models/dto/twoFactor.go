@@ -0,0 +1,36 @@
+package dto
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	// The otpauth URI to show as a QR code
+	Uri string `json:"uri"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code"`
+	// Used in place of the code when the authenticator is lost
+	BackupCode string `json:"backupCode"`
+}
+
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	BackupCode     string `json:"backupCode"`
+}
+
+type TwoFactorBackupCodes struct {
+	BackupCodes []string `json:"backupCodes"`
+}
+
+type TwoFactorStatus struct {
+	Enabled bool `json:"enabled"`
+	// Whether a policy requires it for the grant of the user
+	Required bool `json:"required"`
+	// How many backup codes are left
+	BackupCodes int `json:"backupCodes"`
+}
+
+type TwoFactorPolicy struct {
+	Grant    string `json:"grant"`
+	Required bool   `json:"required"`
+}
@@ -3,6 +3,7 @@ package encryption
 import (
 	eciesgo "github.com/ecies/go/v2"
 	"gorm.io/gorm"
+	"shareLog/models/dto"
 	"shareLog/models/userGrant"
 )
 
@@ -11,19 +12,37 @@ type Key struct {
 	UserOwnerId   *uint
 	InviteOwnerId *uint
 	LogId         *uint
+	// Set when the private key is wrapped to the personal key of that user instead of a symmetric key
+	RecipientId *uint
 	// The salt used to symmetrically encrypt the underlying ecdsa key if it doesn't belong to a user
 	// If it belongs to the user, the key will be encrypted with the user's specific symmetric key which has a constant salt
-	Salt       string
+	Salt string
+	// How the symmetric key was derived from the salt. Keys belonging to a user use the parameters of the user
+	SaltKdf    KdfParams `gorm:"embedded;embeddedPrefix:kdf_"`
 	PublicKey  *PublicKey
 	PrivateKey *PrivateKey `gorm:"embedded;embeddedPrefix:pk_"`
 	UserGrant  userGrant.Type
+	// Owner and client keys are rotated, each rotation creating a new generation. The newest one encrypts new data
+	Generation uint
 }
 
-func NewEncryptionKey(pubKey *eciesgo.PublicKey, pkEncryptedHex string, pkIv string, t userGrant.Type, salt string) Key {
+func NewEncryptionKey(pubKey *eciesgo.PublicKey, privateKey *PrivateKey, t userGrant.Type, salt string) Key {
 	return Key{
 		PublicKey:  &PublicKey{pubKey},
-		PrivateKey: &PrivateKey{EncryptedHex: pkEncryptedHex, Iv: pkIv},
+		PrivateKey: privateKey,
 		UserGrant:  t,
 		Salt:       salt,
 	}
 }
+
+func (k Key) ToWrappedDto() dto.WrappedKey {
+	return dto.WrappedKey{
+		Id:                   k.ID,
+		Grant:                k.UserGrant.Name,
+		Generation:           k.Generation,
+		LogId:                k.LogId,
+		PublicKey:            k.PublicKey.Key.Hex(true),
+		WrappedPrivateKey:    k.PrivateKey.EncryptedHex,
+		WrappedToPersonalKey: k.RecipientId != nil,
+	}
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"shareLog/models/dto"
+)
+
+type KdfAlgorithm string
+
+const (
+	KdfPbkdf2Sha256 KdfAlgorithm = "pbkdf2-sha256"
+	KdfArgon2id     KdfAlgorithm = "argon2id"
+)
+
+const derivedKeyLen = 32 // bytes
+const legacyPbkdf2Iterations = 32
+
+/*
+KdfParams are the algorithm and cost used to derive a symmetric key from a password and a salt.
+Rows created before the parameters were stored have none and use the legacy PBKDF2 cost
+
+	Iterations: PBKDF2 iterations, or Argon2id passes
+	MemoryKiB: Argon2id only
+	Parallelism: Argon2id only
+*/
+type KdfParams struct {
+	Algorithm   KdfAlgorithm
+	Iterations  uint32
+	MemoryKiB   uint32
+	Parallelism uint8
+}
+
+func NewArgon2idParams(iterations uint32, memoryKiB uint32, parallelism uint8) KdfParams {
+	return KdfParams{
+		Algorithm:   KdfArgon2id,
+		Iterations:  iterations,
+		MemoryKiB:   memoryKiB,
+		Parallelism: parallelism,
+	}
+}
+
+func (p KdfParams) normalized() KdfParams {
+	if p.Algorithm == "" {
+		return KdfParams{Algorithm: KdfPbkdf2Sha256, Iterations: legacyPbkdf2Iterations}
+	}
+
+	return p
+}
+
+func (p KdfParams) Equals(other KdfParams) bool {
+	return p.normalized() == other.normalized()
+}
+
+func (p KdfParams) DeriveKey(password string, salt string) []byte {
+	params := p.normalized()
+	if params.Algorithm == KdfArgon2id {
+		return argon2.IDKey([]byte(password), []byte(salt), params.Iterations, params.MemoryKiB, params.Parallelism, derivedKeyLen)
+	}
+
+	return pbkdf2.Key([]byte(password), []byte(salt), int(params.Iterations), derivedKeyLen, sha256.New)
+}
+
+func NewKdfParamsFromDto(kdf dto.Kdf) KdfParams {
+	return KdfParams{
+		Algorithm:   KdfAlgorithm(kdf.Algorithm),
+		Iterations:  kdf.Iterations,
+		MemoryKiB:   kdf.MemoryKiB,
+		Parallelism: kdf.Parallelism,
+	}
+}
+
+func (p KdfParams) ToDto() dto.Kdf {
+	return dto.Kdf{
+		Algorithm:   string(p.Algorithm),
+		Iterations:  p.Iterations,
+		MemoryKiB:   p.MemoryKiB,
+		Parallelism: p.Parallelism,
+	}
+}
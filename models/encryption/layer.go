@@ -0,0 +1,20 @@
+package encryption
+
+import (
+	eciesgo "github.com/ecies/go/v2"
+	"shareLog/lib"
+)
+
+// DecryptLayer Removes one layer of ecies encryption with the first of the keys that works
+func DecryptLayer(data string, keys ...*eciesgo.PrivateKey) (string, error) {
+	err := error(lib.Error{Msg: "No key to decrypt data"})
+	for _, key := range keys {
+		decryptedBytes, decryptErr := eciesgo.Decrypt(key, []byte(data))
+		if decryptErr == nil {
+			return string(decryptedBytes), nil
+		}
+		err = decryptErr
+	}
+
+	return "", err
+}
@@ -1,31 +1,84 @@
 package encryption
 
 import (
+	"encoding/base64"
 	eciesgo "github.com/ecies/go/v2"
 	"shareLog/lib"
 )
 
 const keySize = 64 // bytes
 
+/*
+PrivateKey is the hex of an ecies private key, encrypted with a symmetric key.
+
+	EncryptedHex: The encoded envelope sealing the hex, the encoded ecies cipher text for keys wrapped to a recipient,
+	or the AES-CBC cipher text for legacy keys
+	Iv: Only set for legacy keys
+*/
 type PrivateKey struct {
 	EncryptedHex string
 	Iv           string
 }
 
-func (k *PrivateKey) Key(userSymmetricKey []byte) (*eciesgo.PrivateKey, error) {
-	decryptedHex, err := lib.PerformSymmetricDecryption(
-		k.EncryptedHex,
-		keySize,
-		k.Iv,
-		userSymmetricKey,
-	)
+// NewPrivateKey Seals the private key in an envelope with the symmetric key
+func NewPrivateKey(key *eciesgo.PrivateKey, symmetricKey []byte) (*PrivateKey, error) {
+	envelope, err := lib.SealEnvelope([]byte(key.Hex()), symmetricKey, lib.DefaultKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{EncryptedHex: envelope}, nil
+}
+
+// NewPrivateKeyForRecipient Encrypts the private key with the public key of the recipient, so only they can unwrap it
+func NewPrivateKeyForRecipient(key *eciesgo.PrivateKey, recipient *eciesgo.PublicKey) (*PrivateKey, error) {
+	encryptedHex, err := eciesgo.Encrypt(recipient, []byte(key.Hex()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{EncryptedHex: base64.StdEncoding.EncodeToString(encryptedHex)}, nil
+}
+
+// IsLegacy Legacy keys are encrypted with AES-CBC and should be re-wrapped once unlocked
+func (k *PrivateKey) IsLegacy() bool {
+	return k.Iv != ""
+}
+
+func (k *PrivateKey) Key(symmetricKey []byte) (*eciesgo.PrivateKey, error) {
+	if k.IsLegacy() {
+		decryptedHex, err := lib.PerformSymmetricDecryption(
+			k.EncryptedHex,
+			keySize,
+			k.Iv,
+			symmetricKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return eciesgo.NewPrivateKeyFromHex(decryptedHex)
+	}
 
+	decryptedHex, err := lib.OpenEnvelope(k.EncryptedHex, symmetricKey)
 	if err != nil {
 		return nil, err
 	}
-	return eciesgo.NewPrivateKeyFromHex(decryptedHex)
+
+	return eciesgo.NewPrivateKeyFromHex(string(decryptedHex))
 }
 
-func NewPrivateKeyFromHex(encryptedHex string, iv string) (*PrivateKey, error) {
-	return &PrivateKey{EncryptedHex: encryptedHex, Iv: iv}, nil
+// KeyForRecipient Decrypts a private key wrapped to a recipient with the private key of the recipient
+func (k *PrivateKey) KeyForRecipient(recipientKey *eciesgo.PrivateKey) (*eciesgo.PrivateKey, error) {
+	encryptedHex, err := base64.StdEncoding.DecodeString(k.EncryptedHex)
+	if err != nil {
+		return nil, err
+	}
+
+	decryptedHex, err := eciesgo.Decrypt(recipientKey, encryptedHex)
+	if err != nil {
+		return nil, err
+	}
+
+	return eciesgo.NewPrivateKeyFromHex(string(decryptedHex))
 }
@@ -0,0 +1,39 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const keyVerifierLabel = "shareLog key verifier"
+
+/*
+DeriveKeyVerifier Derives the value zero-knowledge sign-ins are authenticated with from the user symmetric key.
+The clients derive it themselves, and the user symmetric key can't be recovered from it
+*/
+func DeriveKeyVerifier(userSymmetricKey []byte) string {
+	mac := hmac.New(sha256.New, userSymmetricKey)
+	mac.Write([]byte(keyVerifierLabel))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashKeyVerifier The verifier is derived from a random key, so a fast hash is enough to store it
+func HashKeyVerifier(keyVerifier string) string {
+	hash := sha256.Sum256([]byte(keyVerifier))
+	return hex.EncodeToString(hash[:])
+}
+
+// CompareKeyVerifier Compares the verifier with the stored hash in constant time
+func CompareKeyVerifier(keyVerifierHash string, keyVerifier string) bool {
+	if keyVerifierHash == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(keyVerifierHash), []byte(HashKeyVerifier(keyVerifier)))
+}
+
+// KeyVerifierHashFor Returns the hash to store for the verifier of the user symmetric key
+func KeyVerifierHashFor(userSymmetricKey []byte) string {
+	return HashKeyVerifier(DeriveKeyVerifier(userSymmetricKey))
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+	"time"
+)
+
+/*
+Issue groups the logs that share the same stack trace fingerprint
+
+	Fingerprint: A keyed hash of the normalized stack trace, so the plaintext stack trace can't be recovered from it
+	Count: The number of logs grouped in this issue
+	AssigneeId: The user in charge of triaging this issue, if any
+*/
+type Issue struct {
+	gorm.Model
+	Fingerprint string `gorm:"uniqueIndex"`
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Count       uint
+	Status      IssueStatus `gorm:"default:unresolved"`
+	AssigneeId  *uint
+	Assignee    *User
+	Logs        []Log
+}
+
+func NewIssue(fingerprint string) Issue {
+	now := time.Now()
+	return Issue{
+		Fingerprint: fingerprint,
+		FirstSeen:   now,
+		LastSeen:    now,
+		Status:      IssueStatuses.Unresolved,
+	}
+}
+
+func (i Issue) ToDto() dto.Issue {
+	return dto.Issue{
+		Id:         i.ID,
+		FirstSeen:  i.FirstSeen,
+		LastSeen:   i.LastSeen,
+		Count:      i.Count,
+		Status:     i.Status.Status,
+		AssigneeId: i.AssigneeId,
+	}
+}
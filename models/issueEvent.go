@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+)
+
+type IssueEventType struct {
+	Name string
+}
+
+const statusChanged = "statusChanged"
+const assigneeChanged = "assigneeChanged"
+const noteAdded = "noteAdded"
+
+type IssueEventTypeMap struct {
+	StatusChanged   IssueEventType
+	AssigneeChanged IssueEventType
+	NoteAdded       IssueEventType
+}
+
+var IssueEventTypes = IssueEventTypeMap{
+	StatusChanged:   IssueEventType{statusChanged},
+	AssigneeChanged: IssueEventType{assigneeChanged},
+	NoteAdded:       IssueEventType{noteAdded},
+}
+
+func (t *IssueEventType) Scan(src any) error {
+	name, ok := src.(string)
+	if !ok {
+		return errors.New("Event type must be string.")
+	}
+
+	eventType := IssueEventTypes.GetByName(name)
+	if eventType == nil {
+		return errors.New("Unknown event type.")
+	}
+
+	*t = *eventType
+	return nil
+}
+
+func (t IssueEventType) Value() (driver.Value, error) {
+	return t.Name, nil
+}
+
+func (t IssueEventType) GormDataType() string {
+	return "text"
+}
+
+func (t *IssueEventTypeMap) GetByName(name string) *IssueEventType {
+	switch name {
+	case statusChanged:
+		return &IssueEventTypes.StatusChanged
+	case assigneeChanged:
+		return &IssueEventTypes.AssigneeChanged
+	case noteAdded:
+		return &IssueEventTypes.NoteAdded
+	default:
+		return nil
+	}
+}
+
+/*
+IssueEvent is an entry of the history of an issue
+
+	ActorId: The user who made the change
+	From, To: The previous and new value of the changed field. Empty for added notes
+*/
+type IssueEvent struct {
+	gorm.Model
+	IssueId uint `gorm:"index"`
+	ActorId uint
+	Type    IssueEventType
+	From    string
+	To      string
+}
+
+func (e IssueEvent) ToDto() dto.IssueEvent {
+	return dto.IssueEvent{
+		Id:        e.ID,
+		CreatedAt: e.CreatedAt,
+		ActorId:   e.ActorId,
+		Type:      e.Type.Name,
+		From:      e.From,
+		To:        e.To,
+	}
+}
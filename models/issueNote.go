@@ -0,0 +1,37 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+)
+
+/*
+IssueNote is a note attached to an issue
+
+	Level: The grant of the key the note is encrypted with. Owner level notes can only be read by owners,
+	client level notes can be read by owners and clients
+	EncryptedContent: The content, encrypted with the public key of the level
+*/
+type IssueNote struct {
+	gorm.Model
+	IssueId          uint `gorm:"index"`
+	AuthorId         uint
+	Level            userGrant.Type
+	EncryptedContent string
+}
+
+type DecryptedIssueNote struct {
+	IssueNote
+	Content string
+}
+
+func (n DecryptedIssueNote) ToDto() dto.IssueNote {
+	return dto.IssueNote{
+		Id:        n.ID,
+		CreatedAt: n.CreatedAt,
+		AuthorId:  n.AuthorId,
+		Level:     n.Level.Name,
+		Content:   n.Content,
+	}
+}
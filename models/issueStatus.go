@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+type IssueStatus struct {
+	Status string
+}
+
+const unresolved = "unresolved"
+const resolved = "resolved"
+const ignored = "ignored"
+
+type IssueStatusMap struct {
+	Unresolved IssueStatus
+	Resolved   IssueStatus
+	Ignored    IssueStatus
+}
+
+var IssueStatuses = IssueStatusMap{
+	Unresolved: IssueStatus{unresolved},
+	Resolved:   IssueStatus{resolved},
+	Ignored:    IssueStatus{ignored},
+}
+
+func (i *IssueStatus) Scan(src any) error {
+	status, ok := src.(string)
+	if !ok {
+		return errors.New("Status must be string.")
+	}
+
+	issueStatus := IssueStatuses.GetByName(status)
+	if issueStatus == nil {
+		return errors.New("Unknown issue status.")
+	}
+
+	*i = *issueStatus
+	return nil
+}
+
+func (i IssueStatus) Value() (driver.Value, error) {
+	return i.Status, nil
+}
+
+func (i IssueStatus) GormDataType() string {
+	return "text"
+}
+
+func (i *IssueStatusMap) GetByName(name string) *IssueStatus {
+	switch name {
+	case unresolved:
+		return &IssueStatuses.Unresolved
+	case resolved:
+		return &IssueStatuses.Resolved
+	case ignored:
+		return &IssueStatuses.Ignored
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+)
+
+/*
+KeyRotation replaces the owner or client key pair with a new generation and re-encrypts the existing logs with it.
+The logs are processed newest first, from the last log created before the rotation started
+
+	Cursor: The next logs to re-encrypt have an id lower than this. Nil once all logs are processed
+	Error: Why the rotation failed, if it did
+
+Copies of logs made for clients are re-encrypted too, under a new data key wrapped with the new client generation.
+The other holders of the grant and the pending invites get the new generation wrapped to them. Users acquire it
+when they sign in or refresh their session, zero-knowledge clients unwrap it themselves from GET /auth/keys
+*/
+type KeyRotation struct {
+	gorm.Model
+	Grant       userGrant.Type
+	Generation  uint
+	Status      KeyRotationStatus
+	StartedById uint
+	Cursor      *uint
+	Total       int64
+	Processed   int64
+	Error       string
+}
+
+func (k KeyRotation) ToDto() dto.KeyRotation {
+	return dto.KeyRotation{
+		Id:         k.ID,
+		Grant:      k.Grant.Name,
+		Generation: k.Generation,
+		Status:     k.Status.Status,
+		Total:      k.Total,
+		Processed:  k.Processed,
+		Error:      k.Error,
+		StartedAt:  k.CreatedAt,
+		UpdatedAt:  k.UpdatedAt,
+	}
+}
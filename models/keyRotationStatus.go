@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+type KeyRotationStatus struct {
+	Status string
+}
+
+const rotationRunning = "running"
+const rotationPaused = "paused"
+const rotationCompleted = "completed"
+const rotationFailed = "failed"
+
+type KeyRotationStatusMap struct {
+	Running   KeyRotationStatus
+	Paused    KeyRotationStatus
+	Completed KeyRotationStatus
+	Failed    KeyRotationStatus
+}
+
+var KeyRotationStatuses = KeyRotationStatusMap{
+	Running:   KeyRotationStatus{rotationRunning},
+	Paused:    KeyRotationStatus{rotationPaused},
+	Completed: KeyRotationStatus{rotationCompleted},
+	Failed:    KeyRotationStatus{rotationFailed},
+}
+
+func (k *KeyRotationStatus) Scan(src any) error {
+	status, ok := src.(string)
+	if !ok {
+		return errors.New("Status must be string.")
+	}
+
+	rotationStatus := KeyRotationStatuses.GetByName(status)
+	if rotationStatus == nil {
+		return errors.New("Unknown key rotation status.")
+	}
+
+	*k = *rotationStatus
+	return nil
+}
+
+func (k KeyRotationStatus) Value() (driver.Value, error) {
+	return k.Status, nil
+}
+
+func (k KeyRotationStatus) GormDataType() string {
+	return "text"
+}
+
+func (k *KeyRotationStatusMap) GetByName(name string) *KeyRotationStatus {
+	switch name {
+	case rotationRunning:
+		return &KeyRotationStatuses.Running
+	case rotationPaused:
+		return &KeyRotationStatuses.Paused
+	case rotationCompleted:
+		return &KeyRotationStatuses.Completed
+	case rotationFailed:
+		return &KeyRotationStatuses.Failed
+	default:
+		return nil
+	}
+}
@@ -1,34 +1,235 @@
 package models
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"gorm.io/gorm"
+	"shareLog/lib"
+	"shareLog/lib/stackTrace"
+	"shareLog/models/dto"
+	"time"
 )
 
+/*
+LogMetadata holds the fields of a log that are stored in plaintext so they can be indexed and filtered.
+*/
+type LogMetadata struct {
+	Severity   LogSeverity `gorm:"index;default:error"`
+	Timestamp  time.Time   `gorm:"index"`
+	AppVersion string      `gorm:"index"`
+	Platform   string      `gorm:"index"`
+}
+
 /*
 Log is a struct that represents a log in the database.
 
-	DoubleEncryptedStackTrace: The stack trace that is once encrypted with the public key of the client
+	DoubleEncrypted*: The sensitive fields of the log, encrypted with the data key of the log.
+	Logs without a data key predate it: their fields are once encrypted with the public key of the client
 	and then encrypted with the public key of the data owner
+	DoubleEncryptedTags: The user defined tags, serialized as JSON before being encrypted
+	ApiKeyId: The api key the log was uploaded with, if any
+	IdempotencyKey: The client supplied key used to detect replayed uploads. Unique per api key
+	IssueId: The issue grouping all the logs with the same stack trace. Nil for logs without a stack trace
+	IssueFingerprint: The fingerprint of the stack trace, used to find the issue of a new log when it is saved. Not stored
 */
 type Log struct {
 	gorm.Model
-	DoubleEncryptedStackTrace string
-	RefLogId                  *uint
-	RefLog                    *Log `gorm:"foreignKey:RefLogId;constraint:OnDelete:CASCADE"`
+	LogMetadata
+	DoubleEncryptedStackTrace  string
+	DoubleEncryptedMessage     string
+	DoubleEncryptedDeviceModel string
+	DoubleEncryptedTags        string
+	ApiKeyId                   *uint       `gorm:"uniqueIndex:idx_log_idempotency"`
+	IdempotencyKey             *string     `gorm:"uniqueIndex:idx_log_idempotency"`
+	IssueId                    *uint       `gorm:"index"`
+	IssueFingerprint           string      `gorm:"-"`
+	DataKey                    *LogDataKey `gorm:"foreignKey:LogId"`
+	RefLogId                   *uint
+	RefLog                     *Log `gorm:"foreignKey:RefLogId;constraint:OnDelete:CASCADE"`
+}
+
+func (l Log) ToSummaryDto() dto.LogSummary {
+	return dto.LogSummary{
+		Id:         l.ID,
+		CreatedAt:  l.CreatedAt,
+		Severity:   l.Severity.Name,
+		Timestamp:  l.Timestamp,
+		AppVersion: l.AppVersion,
+		Platform:   l.Platform,
+		IssueId:    l.IssueId,
+	}
+}
+
+/*
+Decrypt Decrypts the sensitive fields of the log. The given cipher unwraps the data key.
+Logs without a data key have their fields encrypted directly with that cipher
+*/
+func (l Log) Decrypt(dataKey *LogDataKey, unwrapKey func(data string) (string, error)) (*DecryptedLog, error) {
+	cipher := unwrapKey
+	if dataKey != nil {
+		unwrappedKey, err := unwrapKey(dataKey.DoubleEncryptedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		cipher = func(data string) (string, error) {
+			decryptedData, err := lib.OpenEnvelope(data, []byte(unwrappedKey))
+			return string(decryptedData), err
+		}
+	}
+
+	fields := []string{l.DoubleEncryptedStackTrace, l.DoubleEncryptedMessage, l.DoubleEncryptedDeviceModel, l.DoubleEncryptedTags}
+	decryptedFields := make([]string, len(fields))
+	for i, field := range fields {
+		// Empty fields aren't encrypted
+		if field == "" {
+			continue
+		}
+
+		decryptedField, err := cipher(field)
+		if err != nil {
+			return nil, err
+		}
+		decryptedFields[i] = decryptedField
+	}
+
+	var tags map[string]string
+	if decryptedFields[3] != "" {
+		err := json.Unmarshal([]byte(decryptedFields[3]), &tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &DecryptedLog{
+		LogMetadata: l.LogMetadata,
+		StackTrace:  decryptedFields[0],
+		Message:     decryptedFields[1],
+		DeviceModel: decryptedFields[2],
+		Tags:        tags,
+	}, nil
+}
+
+// ToSealedDto The id is the one the log was requested with
+func (l Log) ToSealedDto(id uint, dataKey *LogDataKey) dto.SealedLog {
+	encode := func(data string) string {
+		return base64.StdEncoding.EncodeToString([]byte(data))
+	}
+
+	sealedLog := dto.SealedLog{
+		Id:          id,
+		Severity:    l.Severity.Name,
+		Timestamp:   l.Timestamp,
+		AppVersion:  l.AppVersion,
+		Platform:    l.Platform,
+		StackTrace:  encode(l.DoubleEncryptedStackTrace),
+		Message:     encode(l.DoubleEncryptedMessage),
+		DeviceModel: encode(l.DoubleEncryptedDeviceModel),
+		Tags:        encode(l.DoubleEncryptedTags),
+	}
+	if dataKey != nil {
+		sealedLog.WrappedDataKey = encode(dataKey.DoubleEncryptedKey)
+	}
+
+	return sealedLog
 }
 
-func NewLog(doubleEncryptedStackTrace string) Log {
-	return Log{
-		DoubleEncryptedStackTrace: doubleEncryptedStackTrace,
+// NewLogFromSealedDto The inverse of ToSealedDto. The data key is nil if the log has none
+func NewLogFromSealedDto(sealedLog dto.SealedLog) (*Log, *LogDataKey, error) {
+	severity := LogSeverities.GetByName(sealedLog.Severity)
+	if severity == nil {
+		return nil, nil, lib.Error{Msg: "Unknown severity", Reason: sealedLog.Severity}
 	}
+
+	encryptedValues := []string{sealedLog.StackTrace, sealedLog.Message, sealedLog.DeviceModel, sealedLog.Tags, sealedLog.WrappedDataKey}
+	decodedValues := make([]string, len(encryptedValues))
+	for i, value := range encryptedValues {
+		decodedValue, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		decodedValues[i] = string(decodedValue)
+	}
+
+	log := Log{
+		LogMetadata: LogMetadata{
+			Severity:   *severity,
+			Timestamp:  sealedLog.Timestamp,
+			AppVersion: sealedLog.AppVersion,
+			Platform:   sealedLog.Platform,
+		},
+		DoubleEncryptedStackTrace:  decodedValues[0],
+		DoubleEncryptedMessage:     decodedValues[1],
+		DoubleEncryptedDeviceModel: decodedValues[2],
+		DoubleEncryptedTags:        decodedValues[3],
+	}
+	log.ID = sealedLog.Id
+
+	var dataKey *LogDataKey
+	if decodedValues[4] != "" {
+		dataKey = &LogDataKey{LogId: sealedLog.Id, DoubleEncryptedKey: decodedValues[4]}
+	}
+
+	return &log, dataKey, nil
 }
 
 type DecryptedLog struct {
-	StackTrace string
+	LogMetadata
+	StackTrace  string
+	Message     string
+	DeviceModel string
+	Tags        map[string]string
+	Frames      []stackTrace.Frame
 }
 
-func NewDecryptedLog(stackTrace string) *DecryptedLog {
+func NewDecryptedLog(logDto dto.Log) (*DecryptedLog, error) {
+	severity := &LogSeverities.Error
+	if logDto.Severity != "" {
+		severity = LogSeverities.GetByName(logDto.Severity)
+	}
+
+	if severity == nil {
+		return nil, lib.Error{Msg: "Unknown severity", Reason: logDto.Severity}
+	}
+
+	timestamp := time.Now()
+	if logDto.Timestamp != nil {
+		timestamp = *logDto.Timestamp
+	}
+
 	return &DecryptedLog{
-		StackTrace: stackTrace,
+		LogMetadata: LogMetadata{
+			Severity:   *severity,
+			Timestamp:  timestamp,
+			AppVersion: logDto.AppVersion,
+			Platform:   logDto.Platform,
+		},
+		StackTrace:  logDto.StackTrace,
+		Message:     logDto.Message,
+		DeviceModel: logDto.DeviceModel,
+		Tags:        logDto.Tags,
+	}, nil
+}
+
+func (l DecryptedLog) ToDto() dto.Log {
+	timestamp := l.Timestamp
+	return dto.Log{
+		StackTrace:  l.StackTrace,
+		Message:     l.Message,
+		Severity:    l.Severity.Name,
+		Timestamp:   &timestamp,
+		AppVersion:  l.AppVersion,
+		Platform:    l.Platform,
+		DeviceModel: l.DeviceModel,
+		Tags:        l.Tags,
+		Frames: lib.Map(l.Frames, func(frame stackTrace.Frame) dto.Frame {
+			return dto.Frame{
+				Module:   frame.Module,
+				Function: frame.Function,
+				File:     frame.File,
+				Line:     frame.Line,
+				InApp:    frame.InApp,
+			}
+		}),
 	}
 }
@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+/*
+LogDataKey is the symmetric key the sensitive fields of a single log are encrypted with.
+Destroying it makes the log unreadable from the live database. The data keys are stored in the same database
+as the logs, so a backup taken before the deletion still holds both and the log can be read from it
+until the backup itself is destroyed.
+
+	DoubleEncryptedKey: The data key, wrapped the same way the log fields used to be encrypted:
+	once with the public key of the client, then with the public key of the data owner
+	(or with the shared key of the log for the copies made for clients)
+*/
+type LogDataKey struct {
+	gorm.Model
+	LogId              uint `gorm:"uniqueIndex"`
+	DoubleEncryptedKey string
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+/*
+LogFilter narrows down a list of logs. Only the plaintext metadata of a log can be filtered on.
+Nil or empty fields are ignored.
+*/
+type LogFilter struct {
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	TimestampFrom *time.Time
+	TimestampTo   *time.Time
+	Severity      *LogSeverity
+	AppVersion    string
+	Platform      string
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+type LogSeverity struct {
+	Name string
+}
+
+const debug = "debug"
+const info = "info"
+const warning = "warning"
+const errorSeverity = "error"
+const fatal = "fatal"
+
+type LogSeverityMap struct {
+	Debug   LogSeverity
+	Info    LogSeverity
+	Warning LogSeverity
+	Error   LogSeverity
+	Fatal   LogSeverity
+}
+
+var LogSeverities = LogSeverityMap{
+	Debug:   LogSeverity{debug},
+	Info:    LogSeverity{info},
+	Warning: LogSeverity{warning},
+	Error:   LogSeverity{errorSeverity},
+	Fatal:   LogSeverity{fatal},
+}
+
+func (s *LogSeverity) Scan(src any) error {
+	name, ok := src.(string)
+	if !ok {
+		return errors.New("Severity must be string.")
+	}
+
+	severity := LogSeverities.GetByName(name)
+	if severity == nil {
+		return errors.New("Unknown severity.")
+	}
+
+	*s = *severity
+	return nil
+}
+
+func (s LogSeverity) Value() (driver.Value, error) {
+	return s.Name, nil
+}
+
+func (s LogSeverity) GormDataType() string {
+	return "text"
+}
+
+func (s *LogSeverityMap) GetByName(name string) *LogSeverity {
+	switch name {
+	case debug:
+		return &LogSeverities.Debug
+	case info:
+		return &LogSeverities.Info
+	case warning:
+		return &LogSeverities.Warning
+	case errorSeverity:
+		return &LogSeverities.Error
+	case fatal:
+		return &LogSeverities.Fatal
+	default:
+		return nil
+	}
+}
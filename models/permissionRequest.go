@@ -7,4 +7,6 @@ type PermissionRequest struct {
 	LogID  uint `gorm:"unique"`
 	Log    Log
 	Status PermissionRequestStatus
+	// The user the log key is shared with once the request is approved
+	RequestedById *uint
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/encryption"
+	"time"
+)
+
+/*
+RecoveryCode lets a user who forgot their password recover their keys.
+The code itself is only shown to the user once, a key is derived from it with CodeKdf and CodeSalt
+
+	LookupId: The first group of the code, stored in plaintext so the code is found without deriving a key for every code of the user
+	WrappedSymmetricKey: The user symmetric key, sealed with the key derived from the code
+	WrappedCodeKey: The key derived from the code, sealed with the user symmetric key,
+	so the code can be re-wrapped when the user symmetric key changes
+	UsedAt: Codes can only be used once
+*/
+type RecoveryCode struct {
+	gorm.Model
+	UserId              uint   `gorm:"index"`
+	LookupId            string `gorm:"index"`
+	CodeSalt            string
+	CodeKdf             encryption.KdfParams `gorm:"embedded;embeddedPrefix:kdf_"`
+	WrappedSymmetricKey string
+	WrappedCodeKey      string
+	UsedAt              *time.Time
+}
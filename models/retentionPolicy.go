@@ -0,0 +1,62 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+)
+
+/*
+RetentionPolicy sets how long logs are kept before being purged
+
+	ApiKeyId: Only applies to the logs uploaded with this api key. Nil for every api key
+	Severity: Only applies to the logs of this severity. Nil for every severity
+	Days: The logs are purged this many days after being created. 0 keeps them forever
+
+When several policies match a log, the most specific one wins: api key and severity first,
+then api key only, then severity only, then the policy matching every log
+*/
+type RetentionPolicy struct {
+	gorm.Model
+	ApiKeyId *uint
+	Severity *LogSeverity
+	Days     uint
+}
+
+// Specificity Higher means the policy matches fewer logs
+func (r RetentionPolicy) Specificity() int {
+	specificity := 0
+	if r.ApiKeyId != nil {
+		specificity += 2
+	}
+	if r.Severity != nil {
+		specificity += 1
+	}
+
+	return specificity
+}
+
+func (r RetentionPolicy) Matches(log Log) bool {
+	if r.ApiKeyId != nil && (log.ApiKeyId == nil || *log.ApiKeyId != *r.ApiKeyId) {
+		return false
+	}
+
+	if r.Severity != nil && *r.Severity != log.Severity {
+		return false
+	}
+
+	return true
+}
+
+func (r RetentionPolicy) ToDto() dto.RetentionPolicy {
+	var severity *string
+	if r.Severity != nil {
+		severity = &r.Severity.Name
+	}
+
+	return dto.RetentionPolicy{
+		Id:       r.ID,
+		ApiKeyId: r.ApiKeyId,
+		Severity: severity,
+		Days:     r.Days,
+	}
+}
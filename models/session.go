@@ -0,0 +1,47 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+	"time"
+)
+
+/*
+Session holds one refresh token of a signed-in device. Each refresh replaces the session with a new one of the same family,
+so a refresh token can only be used once. Using a replaced token again means it leaked: the whole family is revoked
+
+	FamilyId: Shared by all the sessions created by refreshing the first one. The access tokens carry it
+	TokenHash: Hash of the refresh token, the token itself is only given to the client
+	SealedSymmetricKey: The user symmetric key, sealed with a key derived from the refresh token. Empty for zero-knowledge sessions
+	TokenVersion: The token version of the user when the family started, refreshing fails once it changes
+	StartedAt: When the family started, the user signed in
+*/
+type Session struct {
+	gorm.Model
+	UserId             uint   `gorm:"index"`
+	FamilyId           string `gorm:"index"`
+	TokenHash          string `gorm:"uniqueIndex"`
+	SealedSymmetricKey string
+	ZeroKnowledge      bool
+	TokenVersion       uint
+	Device             string
+	Ip                 string
+	StartedAt          time.Time
+	LastUsedAt         time.Time
+	ExpiresAt          time.Time
+	ReplacedAt         *time.Time
+	RevokedAt          *time.Time
+}
+
+func (s Session) ToDto(currentFamilyId string) dto.Session {
+	return dto.Session{
+		Id:            s.ID,
+		Device:        s.Device,
+		Ip:            s.Ip,
+		ZeroKnowledge: s.ZeroKnowledge,
+		StartedAt:     s.StartedAt,
+		LastUsedAt:    s.LastUsedAt,
+		ExpiresAt:     s.ExpiresAt,
+		Current:       s.FamilyId == currentFamilyId,
+	}
+}
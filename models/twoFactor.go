@@ -0,0 +1,45 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"time"
+)
+
+/*
+TwoFactor The TOTP enrollment of a user. It isn't enforced until the user confirms it with a first code
+
+	WrappedSecret: The TOTP secret, wrapped with the key encryption backend
+	LastUsedStep: The time step of the last accepted code, older and equal steps are rejected so codes can't be replayed
+*/
+type TwoFactor struct {
+	gorm.Model
+	UserId        uint `gorm:"uniqueIndex"`
+	WrappedSecret string
+	ConfirmedAt   *time.Time
+	LastUsedStep  int64
+}
+
+// TwoFactorBackupCode Signs in once in place of a TOTP code, when the authenticator is lost
+type TwoFactorBackupCode struct {
+	gorm.Model
+	UserId   uint `gorm:"index"`
+	CodeHash string
+	HashSalt string
+	UsedAt   *time.Time
+}
+
+// TwoFactorPolicy When required, the users of the grant have to enroll before they can sign in
+type TwoFactorPolicy struct {
+	gorm.Model
+	Grant    userGrant.Type `gorm:"uniqueIndex"`
+	Required bool
+}
+
+func (t TwoFactorPolicy) ToDto() dto.TwoFactorPolicy {
+	return dto.TwoFactorPolicy{
+		Grant:    t.Grant.Name,
+		Required: t.Required,
+	}
+}
@@ -2,8 +2,11 @@ package models
 
 import (
 	"gorm.io/gorm"
+	"shareLog/lib"
+	"shareLog/models/dto"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
+	"slices"
 )
 
 type User struct {
@@ -15,6 +18,23 @@ type User struct {
 	Used to derive a the user symmetric key to encrypt/decrypt keys
 	*/
 	EncryptionKeySalt string
-	EncryptionKeys    []encryption.Key `gorm:"foreignKey:UserOwnerId"`
-	Grant             userGrant.Type
+	// How the user symmetric key is derived from the password and EncryptionKeySalt
+	EncryptionKeyKdf encryption.KdfParams `gorm:"embedded;embeddedPrefix:kdf_"`
+	// Hash of the verifier derived from the user symmetric key, for zero-knowledge sign-ins.
+	// Empty until the user signs in with their password once
+	KeyVerifierHash string
+	EncryptionKeys  []encryption.Key `gorm:"foreignKey:UserOwnerId"`
+	Grant           userGrant.Type
+	RecoveryCodes   []RecoveryCode `gorm:"foreignKey:UserId"`
+	// Tokens issued with an older version are rejected. Bumped to sign the user out everywhere
+	TokenVersion uint
+}
+
+// ToKeysDto The encryption keys must be loaded. The rotated keys are wrapped to the user, but not acquired yet
+func (u User) ToKeysDto(rotatedKeys []encryption.Key) dto.UserKeys {
+	return dto.UserKeys{
+		Salt: u.EncryptionKeySalt,
+		Kdf:  u.EncryptionKeyKdf.ToDto(),
+		Keys: lib.Map(append(slices.Clone(u.EncryptionKeys), rotatedKeys...), encryption.Key.ToWrappedDto),
+	}
 }
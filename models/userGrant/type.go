@@ -16,6 +16,7 @@ const clientType = "client"
 const sharedType = "shared"
 const partialOwnerType = "partialOwner"
 const appClientType = "app"
+const personalType = "personal"
 
 type TypeMap struct {
 	GrantClient       Type
@@ -23,6 +24,8 @@ type TypeMap struct {
 	GrantPartialOwner Type
 	GrantOwner        Type
 	GrantApp          Type
+	// The identity key of a single user. Keys shared with the user are wrapped to it, it is never given to other users
+	GrantPersonal Type
 }
 
 var Types = TypeMap{
@@ -37,6 +40,7 @@ var Types = TypeMap{
 		appClientType,
 		50,
 	},
+	GrantPersonal: Type{personalType, 0},
 }
 
 func (t *Type) Scan(src any) error {
@@ -69,6 +73,8 @@ func (t TypeMap) GetByName(name string) *Type {
 		return &Types.GrantClient
 	case appClientType:
 		return &Types.GrantApp
+	case personalType:
+		return &Types.GrantPersonal
 	}
 
 	return nil
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"shareLog/config"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"strings"
+	"time"
+)
+
+// The last use of an api key is only written once per interval, not on every request
+const apiKeyLastUsedResolution = time.Minute
+
+// The keys given to the apps are the prefix and the secret, joined by the separator
+const apiKeyPrefixSize = 6  // bytes
+const apiKeySecretSize = 32 // bytes
+const apiKeySeparator = "."
+
+type apiKeys struct {
+	apiKeyRepository repository.ApiKeyRepository
+	serverKeys       ServerKeys
+}
+
+type ApiKeys interface {
+	/*
+		CreateApiKey Returns the new api key of the user and its key. The key can't be retrieved afterwards.
+		Without scopes, the key can upload logs
+	*/
+	CreateApiKey(user *models.User, scopeNames []string) (*models.ApiKey, string, error)
+	// Authenticate Returns the api key of this key, if it is valid
+	Authenticate(key string) (*models.ApiKey, error)
+	// GetApiKeys Returns the api keys the user created. Owners get all the api keys
+	GetApiKeys(user *models.User) ([]models.ApiKey, error)
+	UpdateApiKey(user *models.User, id uint, update dto.UpdateApiKey) (*models.ApiKey, error)
+	DeleteApiKey(user *models.User, id uint) error
+	/*
+		RotateApiKey Replaces the key of the api key with a new one, returned along the api key. The replaced key keeps
+		working for the grace period, the configured one if nil. A key replaced by an earlier rotation stops working right away
+	*/
+	RotateApiKey(user *models.User, id uint, gracePeriod *time.Duration) (*models.ApiKey, string, error)
+	RecordUse(apiKey *models.ApiKey) error
+	// HashPlaintextKeys Replaces the keys stored in plaintext by their hash. The apps keep using the same keys
+	HashPlaintextKeys() error
+}
+
+type ApiKeysProvider struct {
+}
+
+func (a ApiKeysProvider) Provide() any {
+	var instance ApiKeys = &apiKeys{
+		apiKeyRepository: di.Get[repository.ApiKeyRepository](),
+		serverKeys:       di.Get[ServerKeys](),
+	}
+	return instance
+}
+
+func generateApiKeyPrefix() (string, error) {
+	prefixBytes := make([]byte, apiKeyPrefixSize)
+	_, err := rand.Read(prefixBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(prefixBytes), nil
+}
+
+// Returns the prefix of a new key and the key itself
+func generateApiKey() (string, string, error) {
+	prefix, err := generateApiKeyPrefix()
+	if err != nil {
+		return "", "", err
+	}
+
+	secretBytes := make([]byte, apiKeySecretSize)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return prefix, prefix + apiKeySeparator + base64.RawURLEncoding.EncodeToString(secretBytes), nil
+}
+
+func (a *apiKeys) hashApiKey(key string) (string, error) {
+	apiKeySecret, err := a.serverKeys.GetApiKeySecret()
+	if err != nil {
+		return "", err
+	}
+
+	if apiKeySecret == "" {
+		return "", lib.Error{Msg: "No api key secret configured"}
+	}
+
+	mac := hmac.New(sha256.New, []byte(apiKeySecret))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func isApiKeyHash(hash string, expectedHash string) bool {
+	return expectedHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(expectedHash)) == 1
+}
+
+// The scopes of the keys created without any
+var defaultApiKeyScopes = models.ApiKeyScopeList{models.ApiKeyScopes.LogsWrite, models.ApiKeyScopes.LogsBatch}
+
+func parseApiKeyScopes(scopeNames []string) (models.ApiKeyScopeList, error) {
+	if len(scopeNames) == 0 {
+		return nil, lib.Error{Msg: "An api key needs at least one scope"}
+	}
+
+	scopes := models.ApiKeyScopeList{}
+	for _, scopeName := range scopeNames {
+		scope := models.ApiKeyScopes.GetByName(scopeName)
+		if scope == nil {
+			return nil, lib.Error{Msg: "Unknown api key scope", Reason: scopeName}
+		}
+
+		if !scopes.Has(*scope) {
+			scopes = append(scopes, *scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func canManageApiKey(user *models.User, apiKey *models.ApiKey) bool {
+	return apiKey.UserId == user.ID || user.Grant == userGrant.Types.GrantOwner
+}
+
+// Returns the api key with this id if the user can manage it
+func (a *apiKeys) getManagedApiKey(user *models.User, id uint) (*models.ApiKey, error) {
+	apiKey := a.apiKeyRepository.GetById(id)
+	if apiKey == nil || !canManageApiKey(user, apiKey) {
+		return nil, lib.Error{Msg: "No api key with given id"}
+	}
+
+	return apiKey, nil
+}
+
+func (a *apiKeys) CreateApiKey(user *models.User, scopeNames []string) (*models.ApiKey, string, error) {
+	scopes := defaultApiKeyScopes
+	if scopeNames != nil {
+		var err error
+		scopes, err = parseApiKeyScopes(scopeNames)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	prefix, key, err := generateApiKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyHash, err := a.hashApiKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encryptionKey := lib.Find(user.EncryptionKeys, func(key encryption.Key) bool {
+		return key.UserGrant == userGrant.Types.GrantClient
+	})
+
+	apiKey := models.ApiKey{
+		Prefix:          prefix,
+		KeyHash:         keyHash,
+		UserId:          user.ID,
+		Scopes:          scopes,
+		EncryptionKey:   encryptionKey,
+		EncryptionKeyId: encryptionKey.ID,
+	}
+	err = a.apiKeyRepository.Save(&apiKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &apiKey, key, nil
+}
+
+// Returns the api keys the key can belong to
+func (a *apiKeys) getCandidates(key string, keyHash string) ([]models.ApiKey, error) {
+	prefix, _, found := strings.Cut(key, apiKeySeparator)
+	if found {
+		return a.apiKeyRepository.GetByPrefix(prefix)
+	}
+
+	// The keys created before the prefixes are still sent without one, they are found by their hash
+	return a.apiKeyRepository.GetByKeyHash(keyHash)
+}
+
+func (a *apiKeys) Authenticate(key string) (*models.ApiKey, error) {
+	if key == "" {
+		return nil, lib.Error{Msg: "Invalid api key"}
+	}
+
+	keyHash, err := a.hashApiKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := a.getCandidates(key, keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, candidate := range candidates {
+		if isApiKeyHash(keyHash, candidate.KeyHash) {
+			return &candidate, nil
+		}
+
+		isInGracePeriod := candidate.PreviousKeyExpiresAt != nil && candidate.PreviousKeyExpiresAt.After(now)
+		if isInGracePeriod && isApiKeyHash(keyHash, candidate.PreviousKeyHash) {
+			return &candidate, nil
+		}
+	}
+
+	return nil, lib.Error{Msg: "Invalid api key"}
+}
+
+func (a *apiKeys) GetApiKeys(user *models.User) ([]models.ApiKey, error) {
+	if user.Grant == userGrant.Types.GrantOwner {
+		return a.apiKeyRepository.GetByUserId(nil)
+	}
+
+	return a.apiKeyRepository.GetByUserId(&user.ID)
+}
+
+func (a *apiKeys) UpdateApiKey(user *models.User, id uint, update dto.UpdateApiKey) (*models.ApiKey, error) {
+	apiKey, err := a.getManagedApiKey(user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		apiKey.Name = *update.Name
+	}
+
+	if update.Scopes != nil {
+		apiKey.Scopes, err = parseApiKeyScopes(update.Scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if update.NoExpiry {
+		apiKey.ExpiresAt = nil
+	} else if update.ExpiresAt != nil {
+		if !update.ExpiresAt.After(time.Now()) {
+			return nil, lib.Error{Msg: "The expiry must be in the future"}
+		}
+
+		apiKey.ExpiresAt = update.ExpiresAt
+	}
+
+	err = a.apiKeyRepository.Save(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+func (a *apiKeys) DeleteApiKey(user *models.User, id uint) error {
+	apiKey, err := a.getManagedApiKey(user, id)
+	if err != nil {
+		return err
+	}
+
+	return a.apiKeyRepository.Delete(apiKey)
+}
+
+func (a *apiKeys) RotateApiKey(user *models.User, id uint, gracePeriod *time.Duration) (*models.ApiKey, string, error) {
+	if gracePeriod == nil {
+		configuredGracePeriod := config.GetApiKeyConfig().RotationGracePeriod
+		gracePeriod = &configuredGracePeriod
+	}
+
+	if *gracePeriod < 0 {
+		return nil, "", lib.Error{Msg: "The grace period can't be negative"}
+	}
+
+	apiKey, err := a.getManagedApiKey(user, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now) {
+		return nil, "", lib.Error{Msg: "The api key has expired"}
+	}
+
+	prefix, key, err := generateApiKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyHash, err := a.hashApiKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	previousKeyExpiresAt := now.Add(*gracePeriod)
+	apiKey.PreviousPrefix = apiKey.Prefix
+	apiKey.PreviousKeyHash = apiKey.KeyHash
+	apiKey.PreviousKeyExpiresAt = &previousKeyExpiresAt
+	apiKey.Prefix = prefix
+	apiKey.KeyHash = keyHash
+	apiKey.RotatedAt = &now
+
+	err = a.apiKeyRepository.Save(apiKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+func (a *apiKeys) RecordUse(apiKey *models.ApiKey) error {
+	now := time.Now()
+	return a.apiKeyRepository.UpdateLastUsedAt(apiKey.ID, now, now.Add(-apiKeyLastUsedResolution))
+}
+
+func (a *apiKeys) HashPlaintextKeys() error {
+	plaintextApiKeys, err := a.apiKeyRepository.GetWithPlaintextKeys()
+	if err != nil {
+		return err
+	}
+
+	// The prefixes of the plaintext keys are generated, a part of the key shown as its prefix would leak it
+	for _, apiKey := range plaintextApiKeys {
+		if apiKey.Key != "" {
+			apiKey.Prefix, err = generateApiKeyPrefix()
+			if err != nil {
+				return err
+			}
+
+			apiKey.KeyHash, err = a.hashApiKey(apiKey.Key)
+			if err != nil {
+				return err
+			}
+
+			apiKey.Key = ""
+		}
+
+		if apiKey.PreviousKey != "" {
+			apiKey.PreviousPrefix, err = generateApiKeyPrefix()
+			if err != nil {
+				return err
+			}
+
+			apiKey.PreviousKeyHash, err = a.hashApiKey(apiKey.PreviousKey)
+			if err != nil {
+				return err
+			}
+
+			apiKey.PreviousKey = ""
+		}
+
+		err = a.apiKeyRepository.Save(&apiKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"reflect"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateApiKey(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, _ := env.signUpOwner(t, "owner@test.com")
+
+	apiKeysService := di.Get[ApiKeys]()
+	apiKey, key, err := apiKeysService.CreateApiKey(owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedApiKey := di.Get[repository.ApiKeyRepository]().GetById(apiKey.ID)
+	if storedApiKey.Key != "" || storedApiKey.KeyHash == "" || storedApiKey.KeyHash == key {
+		t.Error("the key is stored in plaintext")
+	}
+	if !strings.HasPrefix(key, storedApiKey.Prefix+apiKeySeparator) {
+		t.Errorf("the key %q doesn't start with its prefix %q", key, storedApiKey.Prefix)
+	}
+
+	authenticatedApiKey, err := apiKeysService.Authenticate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticatedApiKey.ID != apiKey.ID {
+		t.Errorf("authenticated the api key %d instead of %d", authenticatedApiKey.ID, apiKey.ID)
+	}
+
+	prefix, secret, _ := strings.Cut(key, apiKeySeparator)
+	for _, wrongKey := range []string{"", prefix, prefix + apiKeySeparator, prefix + apiKeySeparator + secret[1:], "000000000000" + apiKeySeparator + secret, secret} {
+		_, err = apiKeysService.Authenticate(wrongKey)
+		if err == nil {
+			t.Errorf("the key %q was accepted", wrongKey)
+		}
+	}
+}
+
+// The keys stored in plaintext before the hashes keep working without a prefix once hashed
+func TestHashPlaintextApiKeys(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, _ := env.signUpOwner(t, "owner@test.com")
+
+	legacyApiKey := models.ApiKey{Key: "legacy-key", UserId: owner.ID, Scopes: defaultApiKeyScopes}
+	apiKeyRepository := di.Get[repository.ApiKeyRepository]()
+	err := apiKeyRepository.Save(&legacyApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKeysService := di.Get[ApiKeys]()
+	err = apiKeysService.HashPlaintextKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedApiKey := apiKeyRepository.GetById(legacyApiKey.ID)
+	if storedApiKey.Key != "" || storedApiKey.KeyHash == "" {
+		t.Fatal("the plaintext key wasn't replaced by its hash")
+	}
+	if storedApiKey.Prefix == "" || strings.Contains("legacy-key", storedApiKey.Prefix) {
+		t.Errorf("the prefix %q wasn't generated", storedApiKey.Prefix)
+	}
+
+	authenticatedApiKey, err := apiKeysService.Authenticate("legacy-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticatedApiKey.ID != legacyApiKey.ID {
+		t.Errorf("authenticated the api key %d instead of %d", authenticatedApiKey.ID, legacyApiKey.ID)
+	}
+
+	_, err = apiKeysService.Authenticate(storedApiKey.Prefix + apiKeySeparator + "legacy-key")
+	if err == nil {
+		t.Error("the legacy key was accepted behind the generated prefix")
+	}
+}
+
+func TestRotateApiKey(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, _ := env.signUpOwner(t, "owner@test.com")
+
+	apiKeysService := di.Get[ApiKeys]()
+	apiKey, firstKey, err := apiKeysService.CreateApiKey(owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gracePeriod := time.Hour
+	_, secondKey, err := apiKeysService.RotateApiKey(owner, apiKey.ID, &gracePeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{firstKey, secondKey} {
+		_, err = apiKeysService.Authenticate(key)
+		if err != nil {
+			t.Errorf("the key %q was rejected during the grace period: %v", key, err)
+		}
+	}
+
+	// A key replaced by an earlier rotation stops working right away
+	_, thirdKey, err := apiKeysService.RotateApiKey(owner, apiKey.ID, &gracePeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = apiKeysService.Authenticate(firstKey)
+	if err == nil {
+		t.Error("the key replaced two rotations ago was accepted")
+	}
+	for _, key := range []string{secondKey, thirdKey} {
+		_, err = apiKeysService.Authenticate(key)
+		if err != nil {
+			t.Errorf("the key %q was rejected: %v", key, err)
+		}
+	}
+}
+
+func TestParseApiKeyScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		scopeNames []string
+		want       models.ApiKeyScopeList
+		wantErr    bool
+	}{
+		{name: "no scope", scopeNames: []string{}, wantErr: true},
+		{name: "unknown scope", scopeNames: []string{"logs:write", "logs:delete"}, wantErr: true},
+		{name: "one scope", scopeNames: []string{"logs:batch"}, want: models.ApiKeyScopeList{models.ApiKeyScopes.LogsBatch}},
+		{
+			name:       "repeated scopes",
+			scopeNames: []string{"logs:write", "logs:batch", "logs:write"},
+			want:       models.ApiKeyScopeList{models.ApiKeyScopes.LogsWrite, models.ApiKeyScopes.LogsBatch},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseApiKeyScopes(test.scopeNames)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, wantErr = %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("scopes = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
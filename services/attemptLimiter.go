@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"shareLog/config"
+	"shareLog/di"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AttemptKey What failed attempts are counted for, and how many are allowed before the lockouts start
+type AttemptKey struct {
+	Key          string
+	FreeAttempts int
+}
+
+func AccountAttempts(email string) AttemptKey {
+	return AttemptKey{
+		Key:          "account:" + strings.ToLower(strings.TrimSpace(email)),
+		FreeAttempts: config.GetLockoutConfig().AccountFreeAttempts,
+	}
+}
+
+func IpAttempts(ip string) AttemptKey {
+	return AttemptKey{
+		Key:          "ip:" + ip,
+		FreeAttempts: config.GetLockoutConfig().IpFreeAttempts,
+	}
+}
+
+// TwoFactorAttempts Counted apart from the account, so a correct password doesn't clear the failed codes
+func TwoFactorAttempts(userId uint) AttemptKey {
+	return AttemptKey{
+		Key:          fmt.Sprintf("2fa:%d", userId),
+		FreeAttempts: config.GetLockoutConfig().AccountFreeAttempts,
+	}
+}
+
+func InviteAttempts(inviteId uint) AttemptKey {
+	return AttemptKey{
+		Key:          fmt.Sprintf("invite:%d", inviteId),
+		FreeAttempts: config.GetLockoutConfig().InviteFreeAttempts,
+	}
+}
+
+// LockedOutError No attempt is accepted for the key until RetryAfter has passed
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (l LockedOutError) Error() string {
+	return fmt.Sprintf("Too many failed attempts, retry in %d seconds", int(math.Ceil(l.RetryAfter.Seconds())))
+}
+
+// How long a key stays locked while an attempt past its free ones is verified, in case the attempt is never settled
+const attemptVerificationTimeout = time.Minute
+
+// AttemptReservation The attempt counted for each key before it is verified, until it is settled
+type AttemptReservation struct {
+	keys []AttemptKey
+	// The failures of each key, counting this attempt
+	failures []int
+}
+
+// Whether the attempt is past the free ones of the key at index, the key stays locked until the attempt is settled
+func (r *AttemptReservation) holdsLock(index int) bool {
+	return r.failures[index] > r.keys[index].FreeAttempts
+}
+
+type attemptLimiter struct {
+	store AttemptStore
+}
+
+type AttemptLimiter interface {
+	/*
+		Reserve Counts the attempt as failed for each key before it is verified, so parallel attempts can't all get
+		through before their failures are recorded. The attempts past the free ones are verified one at a time.
+		Returns a LockedOutError if any of the keys is locked out, nothing is counted then.
+		The reservation has to be settled with RecordFailure, RecordSuccess or Release
+	*/
+	Reserve(keys ...AttemptKey) (*AttemptReservation, error)
+	// RecordFailure Locks out the keys of the reservation that used their free attempts
+	RecordFailure(reservation *AttemptReservation) error
+	// RecordSuccess Clears the failed attempts of the given keys and releases the other keys of the reservation
+	RecordSuccess(reservation *AttemptReservation, clearedKeys ...AttemptKey) error
+	// Release Takes back the attempt, for when it couldn't be verified
+	Release(reservation *AttemptReservation) error
+}
+
+type AttemptLimiterProvider struct {
+}
+
+func (a AttemptLimiterProvider) Provide() any {
+	var instance AttemptLimiter = &attemptLimiter{
+		store: di.Get[AttemptStore](),
+	}
+	return instance
+}
+
+// Returns how long the key is locked out after the given number of failures: nothing for the free attempts, then doubling
+func getLockout(failures int, freeAttempts int, lockoutConfig config.LockoutConfig) time.Duration {
+	lockedFailures := failures - freeAttempts
+	if lockedFailures <= 0 {
+		return 0
+	}
+
+	// Past this the lockout is the max anyway, and the shift would overflow
+	if lockedFailures > 32 {
+		return lockoutConfig.MaxLockout
+	}
+
+	lockout := lockoutConfig.BaseLockout << (lockedFailures - 1)
+	return min(lockout, lockoutConfig.MaxLockout)
+}
+
+func (a *attemptLimiter) getLockedOutError(key AttemptKey) error {
+	counter, err := a.store.GetByKey(key.Key)
+	if err != nil {
+		return err
+	}
+
+	var retryAfter time.Duration
+	if counter != nil {
+		retryAfter = max(time.Until(counter.LockedUntil), 0)
+	}
+
+	return LockedOutError{RetryAfter: retryAfter}
+}
+
+func (a *attemptLimiter) Reserve(keys ...AttemptKey) (*AttemptReservation, error) {
+	lockoutConfig := config.GetLockoutConfig()
+	now := time.Now()
+	reservation := &AttemptReservation{}
+	for _, key := range keys {
+		counter, err := a.store.Reserve(key.Key, key.FreeAttempts, now.Add(-lockoutConfig.ResetAfter), now.Add(attemptVerificationTimeout))
+		if err == nil && counter == nil {
+			err = a.getLockedOutError(key)
+		}
+		if err != nil {
+			// The keys already counted are released, the attempt isn't made
+			return nil, errors.Join(err, a.Release(reservation))
+		}
+
+		reservation.keys = append(reservation.keys, key)
+		reservation.failures = append(reservation.failures, counter.Failures)
+	}
+
+	return reservation, nil
+}
+
+func (a *attemptLimiter) RecordFailure(reservation *AttemptReservation) error {
+	lockoutConfig := config.GetLockoutConfig()
+	now := time.Now()
+	for i, key := range reservation.keys {
+		if !reservation.holdsLock(i) {
+			continue
+		}
+
+		err := a.store.SetLockedUntil(key.Key, now.Add(getLockout(reservation.failures[i], key.FreeAttempts, lockoutConfig)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *attemptLimiter) RecordSuccess(reservation *AttemptReservation, clearedKeys ...AttemptKey) error {
+	remaining := &AttemptReservation{}
+	for i, key := range reservation.keys {
+		isCleared := slices.ContainsFunc(clearedKeys, func(clearedKey AttemptKey) bool {
+			return clearedKey.Key == key.Key
+		})
+		if isCleared {
+			err := a.store.DeleteByKey(key.Key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		remaining.keys = append(remaining.keys, key)
+		remaining.failures = append(remaining.failures, reservation.failures[i])
+	}
+
+	return a.Release(remaining)
+}
+
+func (a *attemptLimiter) Release(reservation *AttemptReservation) error {
+	for i, key := range reservation.keys {
+		err := a.store.Uncount(key.Key)
+		if err != nil {
+			return err
+		}
+
+		// The key wasn't locked before the attempt, or it couldn't have been reserved
+		if reservation.holdsLock(i) {
+			err = a.store.SetLockedUntil(key.Key, time.Time{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
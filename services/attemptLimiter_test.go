@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"shareLog/config"
+	"shareLog/di"
+	"testing"
+	"time"
+)
+
+func TestGetLockout(t *testing.T) {
+	lockoutConfig := config.LockoutConfig{BaseLockout: time.Second, MaxLockout: time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 8, want: 16 * time.Second},
+		{failures: 10, want: time.Minute},
+		{failures: 100, want: time.Minute},
+	}
+
+	for _, test := range tests {
+		got := getLockout(test.failures, 3, lockoutConfig)
+		if got != test.want {
+			t.Errorf("getLockout(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	for _, store := range []string{config.DatabaseAttemptStore, config.MemoryAttemptStore} {
+		t.Run(store, func(t *testing.T) {
+			setupTestEnv(t)
+			t.Setenv("attemptStore", store)
+			t.Setenv("baseLockoutSeconds", "60")
+			// The memory store lives as long as the limiter
+			limiter := di.Get[AttemptLimiter]()
+			key := AttemptKey{Key: "account:test", FreeAttempts: 2}
+
+			for i := 0; i < key.FreeAttempts; i++ {
+				reservation, err := limiter.Reserve(key)
+				if err != nil {
+					t.Fatalf("free attempt %d: %v", i+1, err)
+				}
+				err = limiter.RecordFailure(reservation)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Past the free attempts, the key is locked while the attempt is verified
+			reservation, err := limiter.Reserve(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lockedOutError LockedOutError
+			_, err = limiter.Reserve(key)
+			if !errors.As(err, &lockedOutError) {
+				t.Fatalf("a parallel attempt returned %v while the first was verified", err)
+			}
+
+			err = limiter.RecordFailure(reservation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = limiter.Reserve(key)
+			if !errors.As(err, &lockedOutError) || lockedOutError.RetryAfter < 59*time.Second {
+				t.Fatalf("the key wasn't locked out after the failure: %v", err)
+			}
+
+			// A locked key takes back the attempt counted for the other keys
+			otherKey := AttemptKey{Key: "ip:test", FreeAttempts: 1}
+			_, err = limiter.Reserve(otherKey, key)
+			if !errors.As(err, &lockedOutError) {
+				t.Fatalf("reserving with a locked key returned %v", err)
+			}
+			reservation, err = limiter.Reserve(otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reservation.failures[0] != 1 {
+				t.Errorf("failures = %d, the attempt wasn't taken back from the other key", reservation.failures[0])
+			}
+
+			err = limiter.Release(reservation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reservation, err = limiter.Reserve(otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reservation.failures[0] != 1 {
+				t.Errorf("failures = %d, the released attempt still counts", reservation.failures[0])
+			}
+
+			err = limiter.RecordFailure(reservation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reservation, err = limiter.Reserve(otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = limiter.RecordSuccess(reservation, otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reservation, err = limiter.Reserve(otherKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reservation.failures[0] != 1 {
+				t.Errorf("failures = %d, the success didn't clear them", reservation.failures[0])
+			}
+			err = limiter.Release(reservation)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"shareLog/config"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"sync"
+	"time"
+)
+
+// AttemptStore Keeps the counters of failed attempts. Instances sharing a store share the lockouts
+type AttemptStore interface {
+	// GetByKey Returns nil when there is no counter for the key
+	GetByKey(key string) (*models.AttemptCounter, error)
+	/*
+		Reserve Counts a failure for the key atomically, starting over if the last one was before resetBefore.
+		Once the failures are past freeAttempts the key is locked until lockedUntil. Returns nil and counts nothing
+		if the key is locked
+	*/
+	Reserve(key string, freeAttempts int, resetBefore time.Time, lockedUntil time.Time) (*models.AttemptCounter, error)
+	// Uncount Takes back a failure counted by Reserve
+	Uncount(key string) error
+	SetLockedUntil(key string, lockedUntil time.Time) error
+	DeleteByKey(key string) error
+}
+
+type AttemptStoreProvider struct {
+}
+
+func (a AttemptStoreProvider) Provide() any {
+	storeName := config.GetLockoutConfig().AttemptStore
+
+	var instance AttemptStore
+	switch storeName {
+	case config.DatabaseAttemptStore:
+		instance = di.Get[repository.AttemptCounterRepository]()
+	case config.MemoryAttemptStore:
+		instance = &memoryAttemptStore{counters: make(map[string]models.AttemptCounter)}
+	default:
+		panic("Unknown attempt store: " + storeName)
+	}
+
+	return instance
+}
+
+// memoryAttemptStore Keeps the counters of a single instance, until it restarts
+type memoryAttemptStore struct {
+	lock     sync.Mutex
+	counters map[string]models.AttemptCounter
+}
+
+func (m *memoryAttemptStore) GetByKey(key string) (*models.AttemptCounter, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counter, ok := m.counters[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &counter, nil
+}
+
+func (m *memoryAttemptStore) Reserve(key string, freeAttempts int, resetBefore time.Time, lockedUntil time.Time) (*models.AttemptCounter, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	counter, ok := m.counters[key]
+	if !ok {
+		counter = models.AttemptCounter{Key: key}
+	}
+	if counter.LockedUntil.After(now) {
+		return nil, nil
+	}
+
+	if counter.LastFailureAt.Before(resetBefore) {
+		counter.Failures = 0
+	}
+
+	counter.Failures++
+	counter.LastFailureAt = now
+	if counter.Failures > freeAttempts {
+		counter.LockedUntil = lockedUntil
+	}
+
+	m.counters[key] = counter
+	return &counter, nil
+}
+
+func (m *memoryAttemptStore) Uncount(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counter, ok := m.counters[key]
+	if ok && counter.Failures > 0 {
+		counter.Failures--
+		m.counters[key] = counter
+	}
+
+	return nil
+}
+
+func (m *memoryAttemptStore) SetLockedUntil(key string, lockedUntil time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counter, ok := m.counters[key]
+	if ok {
+		counter.LockedUntil = lockedUntil
+		m.counters[key] = counter
+	}
+
+	return nil
+}
+
+func (m *memoryAttemptStore) DeleteByKey(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.counters, key)
+	return nil
+}
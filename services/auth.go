@@ -1,12 +1,18 @@
 package services
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"github.com/go-jose/go-jose/v4"
 	jwtLib "github.com/golang-jwt/jwt/v5"
+	"shareLog/config"
+	"shareLog/constants"
 	"shareLog/data/repository"
 	"shareLog/di"
 	"shareLog/lib"
 	"shareLog/models"
+	"shareLog/models/dto"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
 	"slices"
@@ -15,13 +21,15 @@ import (
 )
 
 type auth struct {
-	userRepository   repository.UserRepository
-	keyRepository    repository.KeyRepository
-	inviteRepository repository.InviteRepository
-	apiKeyRepository repository.ApiKeyRepository
-	mailer           Mailer
-	cryptoService    Crypto
-	keyManager       KeyManager
+	userRepository    repository.UserRepository
+	keyRepository     repository.KeyRepository
+	inviteRepository  repository.InviteRepository
+	mailer            Mailer
+	cryptoService     Crypto
+	keyManager        KeyManager
+	recoveryService   Recovery
+	serverKeys        ServerKeys
+	sessionRepository repository.SessionRepository
 }
 
 /*
@@ -33,24 +41,85 @@ type jwtClaims struct {
 	Grant               string `json:"grant"`
 	EncodedSymmetricKey string `json:"userSymmetricKey"`
 	EncodedPubKey       string `json:"encodedPubKey"`
+	TokenVersion        uint   `json:"tokenVersion"`
+	// Zero-knowledge tokens don't carry the user symmetric key
+	ZeroKnowledge bool `json:"zeroKnowledge,omitempty"`
+	// The family of the session the token was issued for. Revoking the session revokes the token
+	SessionId string `json:"sid,omitempty"`
+	// Challenge tokens are only exchanged for a token once the second factor is checked
+	Challenge bool `json:"challenge,omitempty"`
 }
 
 func (j jwtClaims) Validate() error {
-	// No custom validation yet
+	if j.Challenge {
+		return lib.Error{Msg: "Challenge tokens can't be used to authenticate"}
+	}
+
+	return nil
+}
+
+type challengeClaims struct {
+	jwtClaims
+}
+
+func (c challengeClaims) Validate() error {
+	if !c.Challenge {
+		return lib.Error{Msg: "Not a challenge token"}
+	}
+
 	return nil
 }
 
+const challengeTokenLifetime = 5 * time.Minute
+
+// ErrWrongPassword The password given to confirm a change doesn't match the password of the user
+var ErrWrongPassword = lib.Error{Msg: "Wrong password"}
+
 type Auth interface {
 	ParseAndValidateJWT(signedJwt string) (*jwtLib.Token, error)
 	GetAuthUser(jwt jwtLib.Token) *models.User
-	GenerateUserAuthToken(user *models.User, password string) (*jose.JSONWebEncryption, error)
-	GenerateAppAuthToken(apiKey string) (*jwtLib.Token, error)
-	SignUpWithEmail(email string, password string, code string, inviteId uint) (*models.User, error)
+	/*
+		GenerateAccessToken Returns a short-lived token for the session family.
+		Without a user symmetric key the token is zero-knowledge, the server can't decrypt data with it
+	*/
+	GenerateAccessToken(user *models.User, userSymmetricKey string, sessionId string) (*jose.JSONWebEncryption, error)
+	// GenerateAppAuthToken Returns the token of a request authenticated with the api key
+	GenerateAppAuthToken(apiKey *models.ApiKey) *jwtLib.Token
+	// GenerateChallengeToken Returns a short-lived token proving the password was checked, to complete with a second factor
+	GenerateChallengeToken(user *models.User, userSymmetricKey string) (*jose.JSONWebEncryption, error)
+	// ParseChallengeToken Returns the user of the challenge and their symmetric key, empty for zero-knowledge sign-ins
+	ParseChallengeToken(serializedJwe string) (*models.User, string, error)
+	DeriveUserSymmetricKey(user *models.User, password string) string
+	// SignUpWithEmail Returns the new user and their recovery codes, saved together with the user
+	SignUpWithEmail(email string, password string, code string, inviteId uint) (*models.User, []string, error)
 	SignInWithEmail(email string, password string) (*models.User, error)
+	/*
+		SignInWithKeyVerifier Signs in a zero-knowledge session with the verifier the client derived from the user symmetric key.
+		The server never gets the password nor the key, so the keys of the user are left untouched
+	*/
+	SignInWithKeyVerifier(email string, keyVerifier string) (*models.User, error)
+	/*
+		GetSignInParams Returns how to derive the user symmetric key for a zero-knowledge sign-in.
+		Unknown emails get stable made up params, so they can't be told apart from the existing ones
+	*/
+	GetSignInParams(email string) (*dto.SignInParams, error)
 	CreateUserInvite(grantType userGrant.Type, refUser *models.User, refUserSymmetricKey string) (*models.Invite, error)
-	SignUpFirstUser(email string, password string) (*models.User, error)
-	GenerateApiKey(user *models.User) (models.ApiKey, error)
+	// SignUpFirstUser Returns the new user and their recovery codes, saved together with the user
+	SignUpFirstUser(email string, password string) (*models.User, []string, error)
 	GetAuthGrant(jwt jwtLib.Token) userGrant.Type
+	/*
+		GetUserIfNotRevoked Returns the user of the token, or nil if the token is revoked:
+		issued before the user was signed out everywhere, or for a revoked session
+	*/
+	GetUserIfNotRevoked(jwt jwtLib.Token) *models.User
+	IsZeroKnowledge(jwt jwtLib.Token) bool
+	// GetSessionId Returns the session family of the token, empty for tokens issued before sessions existed
+	GetSessionId(jwt jwtLib.Token) string
+	/*
+		ChangePassword Re-wraps all the keys and recovery codes of the user with a symmetric key derived from the new password and a new salt.
+		Existing tokens are revoked
+	*/
+	ChangePassword(user *models.User, oldPassword string, newPassword string) (*models.User, error)
 }
 
 type AuthProvider struct {
@@ -58,13 +127,15 @@ type AuthProvider struct {
 
 func (p AuthProvider) Provide() any {
 	return &auth{
-		userRepository:   di.Get[repository.UserRepository](),
-		keyRepository:    di.Get[repository.KeyRepository](),
-		inviteRepository: di.Get[repository.InviteRepository](),
-		apiKeyRepository: di.Get[repository.ApiKeyRepository](),
-		cryptoService:    di.Get[Crypto](),
-		mailer:           di.Get[Mailer](),
-		keyManager:       di.Get[KeyManager](),
+		userRepository:    di.Get[repository.UserRepository](),
+		keyRepository:     di.Get[repository.KeyRepository](),
+		inviteRepository:  di.Get[repository.InviteRepository](),
+		cryptoService:     di.Get[Crypto](),
+		mailer:            di.Get[Mailer](),
+		keyManager:        di.Get[KeyManager](),
+		recoveryService:   di.Get[Recovery](),
+		serverKeys:        di.Get[ServerKeys](),
+		sessionRepository: di.Get[repository.SessionRepository](),
 	}
 }
 
@@ -79,10 +150,29 @@ func (a *auth) GetAuthUser(jwt jwtLib.Token) *models.User {
 	return user
 }
 
-func (a *auth) ParseAndValidateJWT(signedJwt string) (*jwtLib.Token, error) {
-	token, err := jwtLib.ParseWithClaims(signedJwt, &jwtClaims{}, func(token *jwtLib.Token) (interface{}, error) {
-		return a.keyRepository.GetJWTPubKey()
-	})
+// Returns the public key of the signing key identified by the kid of the token
+func (a *auth) getVerificationKey(token *jwtLib.Token) (interface{}, error) {
+	verificationKeys, err := a.serverKeys.GetJwtVerificationKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header[jwtKeyIdHeader].(string)
+	if kid == "" && a.serverKeys.AcceptsLegacyTokens() && len(verificationKeys.Keys) > 0 {
+		// Tokens issued before key ids were signed with the current key
+		return verificationKeys.Keys[0].Key, nil
+	}
+
+	keys := verificationKeys.Key(kid)
+	if len(keys) == 0 {
+		return nil, lib.Error{Msg: "Unknown signing key", Reason: kid}
+	}
+
+	return keys[0].Key, nil
+}
+
+func (a *auth) parseJWT(signedJwt string, claims jwtLib.Claims) (*jwtLib.Token, error) {
+	token, err := jwtLib.ParseWithClaims(signedJwt, claims, a.getVerificationKey, jwtLib.WithValidMethods([]string{jwtLib.SigningMethodES512.Alg()}))
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +186,10 @@ func (a *auth) ParseAndValidateJWT(signedJwt string) (*jwtLib.Token, error) {
 	return token, nil
 }
 
+func (a *auth) ParseAndValidateJWT(signedJwt string) (*jwtLib.Token, error) {
+	return a.parseJWT(signedJwt, &jwtClaims{})
+}
+
 func (a *auth) extractInvite(inviteId uint, code string) (*models.Invite, error) {
 	invite, err := a.inviteRepository.GetByIdWithKeys(inviteId)
 	if err != nil {
@@ -127,31 +221,44 @@ func (a *auth) clearInviteData(invite *models.Invite) error {
 	return nil
 }
 
-func (a *auth) SignUpWithEmail(email string, password string, code string, inviteId uint) (*models.User, error) {
+func (a *auth) SignUpWithEmail(email string, password string, code string, inviteId uint) (*models.User, []string, error) {
 	invite, err := a.extractInvite(inviteId, code)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	keySalt := a.cryptoService.GenerateSalt()
 
 	keys, err := a.keyManager.CreateKeysForNewUser(invite, code, password, keySalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	clearErr := a.clearInviteData(invite)
-	if clearErr != nil {
-		return nil, err
+	err = a.clearInviteData(invite)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return a.signUpUserWithKeys(email, password, keys, keySalt, invite.Grant)
 }
 
-func (a *auth) signUpUserWithKeys(email string, password string, keys []encryption.Key, keySalt string, grant userGrant.Type) (*models.User, error) {
+// The user is saved together with their keys and recovery codes, so a failed sign up leaves no user behind
+func (a *auth) signUpUserWithKeys(email string, password string, keys []encryption.Key, keySalt string, grant userGrant.Type) (*models.User, []string, error) {
 	passwordSalt := a.cryptoService.GenerateSalt()
 	hashedPassword, err := lib.HashPassword(password, passwordSalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	kdfParams := a.cryptoService.CurrentKdfParams()
+	userSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(password, keySalt, kdfParams)
+	personalKey, err := a.keyManager.CreatePersonalKey(userSymmetricKey, keySalt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recoveryCodes, recoveryCodeModels, err := a.recoveryService.CreateCodes(userSymmetricKey)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	user := models.User{
@@ -159,24 +266,27 @@ func (a *auth) signUpUserWithKeys(email string, password string, keys []encrypti
 		PasswordHash:      hashedPassword,
 		PasswordSalt:      passwordSalt,
 		EncryptionKeySalt: keySalt,
-		EncryptionKeys:    keys,
+		EncryptionKeyKdf:  kdfParams,
+		KeyVerifierHash:   encryption.KeyVerifierHashFor([]byte(userSymmetricKey)),
+		EncryptionKeys:    append(keys, *personalKey),
 		Grant:             grant,
+		RecoveryCodes:     recoveryCodeModels,
 	}
 	err = a.userRepository.Save(&user)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if grant == userGrant.Types.GrantClient {
 		acquiredSharedKeys, err := a.keyManager.AcquireSharedKeys(&user, password, keySalt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		user.EncryptionKeys = slices.Concat(user.EncryptionKeys, acquiredSharedKeys)
 	}
 
-	return &user, nil
+	return &user, recoveryCodes, nil
 }
 
 func (a *auth) SignInWithEmail(email string, password string) (*models.User, error) {
@@ -189,6 +299,34 @@ func (a *auth) SignInWithEmail(email string, password string) (*models.User, err
 		return nil, lib.Error{Msg: "Wrong email or password"}
 	}
 
+	if !user.EncryptionKeyKdf.Equals(a.cryptoService.CurrentKdfParams()) {
+		err = a.upgradeKeyDerivation(user, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	userSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(password, user.EncryptionKeySalt, user.EncryptionKeyKdf)
+	err = a.ensureKeyVerifier(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.keyManager.EnsurePersonalKey(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.keyManager.MigrateLegacyKeys(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = a.keyManager.AcquireRotatedKeys(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
 	if user.Grant == userGrant.Types.GrantClient {
 		acquiredSharedKeys, err := a.keyManager.AcquireSharedKeys(user, password, user.EncryptionKeySalt)
 		if err != nil {
@@ -201,6 +339,108 @@ func (a *auth) SignInWithEmail(email string, password string) (*models.User, err
 	return user, err
 }
 
+// Stores the verifier of the users who signed up before zero-knowledge sign-ins existed
+func (a *auth) ensureKeyVerifier(user *models.User, userSymmetricKey string) error {
+	if user.KeyVerifierHash != "" {
+		return nil
+	}
+
+	user.KeyVerifierHash = encryption.KeyVerifierHashFor([]byte(userSymmetricKey))
+	return a.userRepository.UpdateKeyVerifierHash(user)
+}
+
+func (a *auth) SignInWithKeyVerifier(email string, keyVerifier string) (*models.User, error) {
+	user, err := a.userRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !encryption.CompareKeyVerifier(user.KeyVerifierHash, keyVerifier) {
+		return nil, lib.Error{Msg: "Wrong email or key verifier"}
+	}
+
+	return user, nil
+}
+
+func (a *auth) GetSignInParams(email string) (*dto.SignInParams, error) {
+	user, err := a.userRepository.GetByEmail(email)
+	if err == nil {
+		return &dto.SignInParams{Salt: user.EncryptionKeySalt, Kdf: user.EncryptionKeyKdf.ToDto()}, nil
+	}
+
+	secret, err := a.serverKeys.GetSignInParamsSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// Made up from the email the same way for every request, with the alphabet of the real salts
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("sign-in salt:" + email))
+	saltBytes := mac.Sum(nil)[:constants.SaltSize]
+	for i, b := range saltBytes {
+		saltBytes[i] = constants.LetterBytes[int(b)%len(constants.LetterBytes)]
+	}
+
+	kdf, err := a.getFakeKdfParams(secret, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.SignInParams{Salt: string(saltBytes), Kdf: kdf.ToDto()}, nil
+}
+
+/*
+Picks the KDF parameters of an unknown email the same way for every request.
+The emails get the legacy parameters in the proportion of the users who didn't sign in since the KDF upgrade,
+so the parameters don't tell the unknown emails apart from the legacy accounts
+*/
+func (a *auth) getFakeKdfParams(secret string, email string) (encryption.KdfParams, error) {
+	legacyUsers, users, err := a.userRepository.CountLegacyKdf()
+	if err != nil {
+		return encryption.KdfParams{}, err
+	}
+
+	if users == 0 {
+		return a.cryptoService.CurrentKdfParams(), nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("sign-in kdf:" + email))
+	if binary.BigEndian.Uint64(mac.Sum(nil))%uint64(users) < uint64(legacyUsers) {
+		return encryption.KdfParams{}, nil
+	}
+
+	return a.cryptoService.CurrentKdfParams(), nil
+}
+
+/*
+Re-derives the user symmetric key with the current KDF parameters and re-wraps the keys of the user with it.
+The existing tokens carry the old symmetric key, which can't unlock the keys anymore, so they are revoked
+*/
+func (a *auth) upgradeKeyDerivation(user *models.User, password string) error {
+	userWithKeys := a.userRepository.GetByIdWithPrivateKeys(user.ID)
+	currentParams := a.cryptoService.CurrentKdfParams()
+	oldSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(password, user.EncryptionKeySalt, user.EncryptionKeyKdf)
+	newSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(password, user.EncryptionKeySalt, currentParams)
+
+	keys, err := a.keyManager.RewrapKeys(userWithKeys.EncryptionKeys, oldSymmetricKey, newSymmetricKey)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := a.recoveryService.RewrapCodes(user.ID, oldSymmetricKey, newSymmetricKey)
+	if err != nil {
+		return err
+	}
+
+	user.EncryptionKeyKdf = currentParams
+	user.KeyVerifierHash = encryption.KeyVerifierHashFor([]byte(newSymmetricKey))
+	user.EncryptionKeys = keys
+	user.RecoveryCodes = recoveryCodes
+	user.TokenVersion++
+	return a.userRepository.SaveWithEncryptionKeys(user)
+}
+
 func (a *auth) CreateUserInvite(grantType userGrant.Type, refUser *models.User, refUserSymmetricKey string) (*models.Invite, error) {
 	code := a.cryptoService.GenerateSalt()
 	hashSalt := a.cryptoService.GenerateSalt()
@@ -226,24 +466,65 @@ func (a *auth) CreateUserInvite(grantType userGrant.Type, refUser *models.User,
 	return invite, nil
 }
 
-func (a *auth) GenerateUserAuthToken(user *models.User, password string) (*jose.JSONWebEncryption, error) {
-	userSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(password, user.EncryptionKeySalt)
-	jwt := a.createUserJWT(user, userSymmetricKey)
+func (a *auth) GenerateAccessToken(user *models.User, userSymmetricKey string, sessionId string) (*jose.JSONWebEncryption, error) {
+	jwt := a.createUserJWT(user, userSymmetricKey, sessionId)
+	return a.cryptoService.CreateJwe(jwt)
+}
+
+func (a *auth) GenerateChallengeToken(user *models.User, userSymmetricKey string) (*jose.JSONWebEncryption, error) {
+	jwt := a.createUserJWT(user, userSymmetricKey, "")
+	claims := jwt.Claims.(jwtClaims)
+	claims.Challenge = true
+	claims.ExpiresAt = &jwtLib.NumericDate{Time: time.Now().Add(challengeTokenLifetime)}
+	jwt.Claims = claims
+
 	return a.cryptoService.CreateJwe(jwt)
 }
 
-func (a *auth) GenerateAppAuthToken(apiKey string) (*jwtLib.Token, error) {
-	// Check if api key is valid
-	apiKeyModel := a.apiKeyRepository.GetByKey(apiKey)
-	if apiKeyModel == nil {
-		return nil, lib.Error{Msg: "Invalid api key"}
+func (a *auth) ParseChallengeToken(serializedJwe string) (*models.User, string, error) {
+	signedJwt, err := a.cryptoService.DecodeJwe(serializedJwe)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := a.parseJWT(signedJwt, &challengeClaims{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims := token.Claims.(*challengeClaims)
+	userId, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user := a.userRepository.GetByIdWithPrivateKeys(uint(userId))
+	if user == nil || user.TokenVersion != claims.TokenVersion {
+		return nil, "", lib.Error{Msg: "The challenge was revoked"}
+	}
+
+	if claims.ZeroKnowledge {
+		return user, "", nil
 	}
 
-	return a.createAppJWT(*apiKeyModel), nil
+	userSymmetricKey, err := a.keyManager.DecodeEncryptionKeyForJWT(claims.EncodedSymmetricKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, userSymmetricKey, nil
 }
 
-func (a *auth) createUserJWT(user *models.User, userSymmetricKey string) *jwtLib.Token {
-	exp := time.Now().Add(time.Hour * 24)
+func (a *auth) DeriveUserSymmetricKey(user *models.User, password string) string {
+	return a.cryptoService.DeriveUserSymmetricKey(password, user.EncryptionKeySalt, user.EncryptionKeyKdf)
+}
+
+func (a *auth) GenerateAppAuthToken(apiKey *models.ApiKey) *jwtLib.Token {
+	return a.createAppJWT(*apiKey)
+}
+
+func (a *auth) createUserJWT(user *models.User, userSymmetricKey string, sessionId string) *jwtLib.Token {
+	exp := time.Now().Add(config.GetSessionConfig().AccessTokenLifetime)
 
 	claims := jwtClaims{
 		RegisteredClaims: jwtLib.RegisteredClaims{
@@ -252,8 +533,14 @@ func (a *auth) createUserJWT(user *models.User, userSymmetricKey string) *jwtLib
 				Time: exp,
 			},
 		},
-		Grant:               user.Grant.Name,
-		EncodedSymmetricKey: a.keyManager.EncodeEncryptionKeyForJWT([]byte(userSymmetricKey)),
+		Grant:        user.Grant.Name,
+		TokenVersion: user.TokenVersion,
+		SessionId:    sessionId,
+	}
+	if userSymmetricKey == "" {
+		claims.ZeroKnowledge = true
+	} else {
+		claims.EncodedSymmetricKey = a.keyManager.EncodeEncryptionKeyForJWT([]byte(userSymmetricKey))
 	}
 
 	signingMethod := jwtLib.SigningMethodES512
@@ -275,19 +562,19 @@ func (a *auth) createAppJWT(key models.ApiKey) *jwtLib.Token {
 
 	signingMethod := jwtLib.SigningMethodES512
 
-	return jwtLib.NewWithClaims(signingMethod, claims)
+	return jwtLib.NewWithClaims(signingMethod, &claims)
 }
 
-func (a *auth) SignUpFirstUser(email string, password string) (*models.User, error) {
+func (a *auth) SignUpFirstUser(email string, password string) (*models.User, []string, error) {
 	keySalt := a.cryptoService.GenerateSalt()
 	ownerKey, err := a.cryptoService.CreateNewEncryptionKey(userGrant.Types.GrantOwner, password, keySalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	clientKey, err := a.cryptoService.CreateNewEncryptionKey(userGrant.Types.GrantClient, password, keySalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	keys := []encryption.Key{*ownerKey, *clientKey}
@@ -295,26 +582,85 @@ func (a *auth) SignUpFirstUser(email string, password string) (*models.User, err
 	return a.signUpUserWithKeys(email, password, keys, keySalt, userGrant.Types.GrantOwner)
 }
 
-func (a *auth) GenerateApiKey(user *models.User) (models.ApiKey, error) {
-	apiKey := a.cryptoService.GenerateSalt()
-	encryptionKey := lib.Find(user.EncryptionKeys, func(key encryption.Key) bool {
-		return key.UserGrant == userGrant.Types.GrantClient
-	})
+func (a *auth) GetAuthGrant(jwt jwtLib.Token) userGrant.Type {
+	claims := jwt.Claims.(*jwtClaims)
+	return *userGrant.Types.GetByName(claims.Grant)
+}
+
+func (a *auth) GetUserIfNotRevoked(jwt jwtLib.Token) *models.User {
+	claims := jwt.Claims.(*jwtClaims)
+	user := a.GetAuthUser(jwt)
+	if user == nil || user.ID == 0 {
+		return nil
+	}
 
-	apiKeyModel := models.ApiKey{
-		Key:             apiKey,
-		EncryptionKey:   encryptionKey,
-		EncryptionKeyId: encryptionKey.ID,
+	if claims.TokenVersion != user.TokenVersion {
+		return nil
 	}
-	err := a.apiKeyRepository.Save(&apiKeyModel)
-	if err != nil {
-		return apiKeyModel, err
+
+	if claims.SessionId == "" {
+		return user
 	}
 
-	return apiKeyModel, nil
+	isSessionRevoked, err := a.sessionRepository.IsFamilyRevoked(claims.SessionId)
+	if isSessionRevoked || err != nil {
+		return nil
+	}
+
+	return user
 }
 
-func (a *auth) GetAuthGrant(jwt jwtLib.Token) userGrant.Type {
+func (a *auth) IsZeroKnowledge(jwt jwtLib.Token) bool {
 	claims := jwt.Claims.(*jwtClaims)
-	return *userGrant.Types.GetByName(claims.Grant)
+	return claims.ZeroKnowledge
+}
+
+func (a *auth) GetSessionId(jwt jwtLib.Token) string {
+	claims := jwt.Claims.(*jwtClaims)
+	return claims.SessionId
+}
+
+func (a *auth) ChangePassword(user *models.User, oldPassword string, newPassword string) (*models.User, error) {
+	if hashMatch := lib.CompareHashAndPassword(user.PasswordHash, oldPassword, user.PasswordSalt); !hashMatch {
+		return nil, ErrWrongPassword
+	}
+
+	userWithKeys := a.userRepository.GetByIdWithPrivateKeys(user.ID)
+	oldSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(oldPassword, user.EncryptionKeySalt, user.EncryptionKeyKdf)
+
+	keySalt := a.cryptoService.GenerateSalt()
+	kdfParams := a.cryptoService.CurrentKdfParams()
+	newSymmetricKey := a.cryptoService.DeriveUserSymmetricKey(newPassword, keySalt, kdfParams)
+
+	keys, err := a.keyManager.RewrapKeys(userWithKeys.EncryptionKeys, oldSymmetricKey, newSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := a.recoveryService.RewrapCodes(user.ID, oldSymmetricKey, newSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordSalt := a.cryptoService.GenerateSalt()
+	hashedPassword, err := lib.HashPassword(newPassword, passwordSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	userWithKeys.PasswordHash = hashedPassword
+	userWithKeys.PasswordSalt = passwordSalt
+	userWithKeys.EncryptionKeySalt = keySalt
+	userWithKeys.EncryptionKeyKdf = kdfParams
+	userWithKeys.KeyVerifierHash = encryption.KeyVerifierHashFor([]byte(newSymmetricKey))
+	userWithKeys.EncryptionKeys = keys
+	userWithKeys.RecoveryCodes = recoveryCodes
+	userWithKeys.TokenVersion++
+
+	err = a.userRepository.SaveWithEncryptionKeys(userWithKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return userWithKeys, nil
 }
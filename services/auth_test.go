@@ -0,0 +1,112 @@
+package services
+
+import (
+	"fmt"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"testing"
+)
+
+// Re-wraps the keys and recovery codes of the user with the legacy KDF, as they were before the KDF parameters were stored
+func makeKdfLegacy(t *testing.T, user *models.User, userSymmetricKey string) string {
+	legacySymmetricKey := di.Get[Crypto]().DeriveUserSymmetricKey(testPassword, user.EncryptionKeySalt, encryption.KdfParams{})
+	keys, err := di.Get[KeyManager]().RewrapKeys(user.EncryptionKeys, userSymmetricKey, legacySymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := di.Get[Recovery]().RewrapCodes(user.ID, userSymmetricKey, legacySymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.EncryptionKeyKdf = encryption.KdfParams{}
+	user.KeyVerifierHash = encryption.KeyVerifierHashFor([]byte(legacySymmetricKey))
+	user.EncryptionKeys = keys
+	user.RecoveryCodes = recoveryCodes
+	err = di.Get[repository.UserRepository]().SaveWithEncryptionKeys(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return legacySymmetricKey
+}
+
+func TestSignInUpgradesLegacyKdf(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	log := env.saveLog(t, "before upgrade")
+	legacySymmetricKey := makeKdfLegacy(t, owner, ownerSymmetricKey)
+	tokenVersion := env.reloadUser(t, owner.ID).TokenVersion
+
+	owner, ownerSymmetricKey = env.signIn(t, "owner@test.com")
+	if !owner.EncryptionKeyKdf.Equals(di.Get[Crypto]().CurrentKdfParams()) {
+		t.Fatalf("the KDF wasn't upgraded, kdf = %+v", owner.EncryptionKeyKdf)
+	}
+	if ownerSymmetricKey == legacySymmetricKey {
+		t.Fatal("the symmetric key wasn't derived again")
+	}
+	if owner.TokenVersion != tokenVersion+1 {
+		t.Errorf("token version = %d, the tokens carrying the legacy symmetric key weren't revoked", owner.TokenVersion)
+	}
+	if !encryption.CompareKeyVerifier(owner.KeyVerifierHash, encryption.DeriveKeyVerifier([]byte(ownerSymmetricKey))) {
+		t.Error("the key verifier wasn't updated")
+	}
+
+	ownerKey := di.Get[KeyManager]().GetKeyForLevel(owner, userGrant.Types.GrantOwner)
+	_, err := di.Get[Crypto]().UnlockPrivateKey(ownerKey, []byte(legacySymmetricKey))
+	if err == nil {
+		t.Error("the keys still unlock with the legacy symmetric key")
+	}
+
+	decryptedLog, err := di.Get[Logger]().GetDecryptedLog(log.ID, owner, ownerSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptedLog.Message != "before upgrade" {
+		t.Errorf("message = %q", decryptedLog.Message)
+	}
+}
+
+func TestSignInParamsOfUnknownEmails(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+	env.signUpInvited(t, invite, code, "client@test.com")
+	makeKdfLegacy(t, owner, ownerSymmetricKey)
+
+	authService := di.Get[Auth]()
+	knownParams, err := authService.GetSignInParams("owner@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if knownParams.Salt != owner.EncryptionKeySalt || knownParams.Kdf.Algorithm != "" {
+		t.Errorf("the params of a known email aren't theirs: %+v", knownParams)
+	}
+
+	// Half of the users didn't sign in since the upgrade, about half of the unknown emails look like them
+	legacyParams := 0
+	for i := 0; i < 200; i++ {
+		email := fmt.Sprintf("unknown%d@test.com", i)
+		params, err := authService.GetSignInParams(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := authService.GetSignInParams(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *params != *again {
+			t.Fatalf("the params of %s changed between requests", email)
+		}
+
+		if params.Kdf.Algorithm == "" {
+			legacyParams++
+		}
+	}
+	if legacyParams < 60 || legacyParams > 140 {
+		t.Errorf("%d of 200 unknown emails got the legacy params, expected about half", legacyParams)
+	}
+}
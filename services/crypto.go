@@ -1,13 +1,14 @@
 package services
 
 import (
+	"cmp"
 	"crypto/rand"
-	"crypto/sha256"
+	"fmt"
 	eciesgo "github.com/ecies/go/v2"
 	"github.com/go-jose/go-jose/v4"
 	jwtLib "github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/pbkdf2"
 	"math/big"
+	"shareLog/config"
 	"shareLog/constants"
 	"shareLog/data/repository"
 	"shareLog/di"
@@ -15,13 +16,19 @@ import (
 	"shareLog/models"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
+	"slices"
 )
 
-const derivePasswordHashIter = 32
-const derivePasswordKeyLen = 32
+// The key algorithms of the JWE keys
+var jweKeyAlgorithms = []jose.KeyAlgorithm{jose.RSA_OAEP_256, jose.ECDH_ES}
+
+// The algorithms of the tokens issued before the current ones, only accepted during the legacy token window
+var legacyJweKeyAlgorithms = []jose.KeyAlgorithm{jose.RSA1_5}
+var legacyJweContentEncryptions = []jose.ContentEncryption{jose.A128CBC_HS256}
 
 type crypto struct {
 	keyRepository repository.KeyRepository
+	serverKeys    ServerKeys
 }
 
 /*
@@ -52,16 +59,25 @@ type Crypto interface {
 	*/
 	DecryptMessage(opt *DecryptOptions) (string, error)
 	GenerateSalt() string
-	DeriveSecurePassphrase(password string, salt string) []byte
+	DeriveSecurePassphrase(password string, salt string, params encryption.KdfParams) []byte
+	/*
+		The KDF parameters new keys are derived with
+	*/
+	CurrentKdfParams() encryption.KdfParams
 	CreateNewEncryptionKey(t userGrant.Type, password string, salt string) (*encryption.Key, error)
 	CreateEncryptionKeyWithPassword(key *eciesgo.PrivateKey, t userGrant.Type, passphrase string, salt string) (*encryption.Key, error)
 	CreateEncryptionKey(key *eciesgo.PrivateKey, t userGrant.Type, symmetricKey string, salt string) (*encryption.Key, error)
+	/*
+		Decrypt the private key of the encryption key.
+		Legacy keys are re-wrapped in an envelope and saved, so they are migrated the first time they are used
+	*/
+	UnlockPrivateKey(key *encryption.Key, symmetricKey []byte) (*eciesgo.PrivateKey, error)
 	CreateJwe(token *jwtLib.Token) (*jose.JSONWebEncryption, error)
 	/*
 		Return the signed string representing the underlying JWT
 	*/
 	DecodeJwe(serializedJwe string) (string, error)
-	DeriveUserSymmetricKey(password string, salt string) string
+	DeriveUserSymmetricKey(password string, salt string, params encryption.KdfParams) string
 	DecryptMessageForLevel(opt *DecryptOptions, level userGrant.Type) (string, error)
 }
 
@@ -69,8 +85,10 @@ type CryptoProvider struct {
 }
 
 func (c CryptoProvider) Provide() any {
-	keyRepository := di.Get[repository.KeyRepository]()
-	var instance Crypto = &crypto{keyRepository}
+	var instance Crypto = &crypto{
+		keyRepository: di.Get[repository.KeyRepository](),
+		serverKeys:    di.Get[ServerKeys](),
+	}
 	return instance
 }
 
@@ -136,17 +154,53 @@ func (c *crypto) DecryptMessageForLevel(opt *DecryptOptions, level userGrant.Typ
 		return "", lib.Error{Msg: "No valid key to decrypt message"}
 	}
 
-	privateKey, err := key.PrivateKey.Key([]byte(opt.UsrSymmetricKey))
+	decryptedMsg, err := c.decryptWithKey(opt.Data, key, opt.UsrSymmetricKey)
+	if err == nil || opt.Usr == nil {
+		return decryptedMsg, err
+	}
+
+	// The message might have been encrypted before the key was rotated
+	for _, otherGeneration := range getOtherGenerations(opt.Usr.EncryptionKeys, key) {
+		decryptedMsg, otherErr := c.decryptWithKey(opt.Data, &otherGeneration, opt.UsrSymmetricKey)
+		if otherErr == nil {
+			return decryptedMsg, nil
+		}
+	}
+
+	return "", err
+}
+
+// Returns the other generations of the key held by the user, newest first
+func getOtherGenerations(userKeys []encryption.Key, key *encryption.Key) []encryption.Key {
+	otherGenerations := lib.Filter(userKeys, func(userKey encryption.Key) bool {
+		return userKey.UserGrant == key.UserGrant &&
+			userKey.Generation != key.Generation &&
+			(userKey.LogId == nil) == (key.LogId == nil) &&
+			(userKey.LogId == nil || *userKey.LogId == *key.LogId)
+	})
+
+	slices.SortFunc(otherGenerations, func(a, b encryption.Key) int {
+		return cmp.Compare(b.Generation, a.Generation)
+	})
+	return otherGenerations
+}
+
+func (c *crypto) decryptWithKey(data string, key *encryption.Key, userSymmetricKey string) (string, error) {
+	privateKey, err := c.UnlockPrivateKey(key, []byte(userSymmetricKey))
 	if err != nil {
 		return "", err
 	}
 
-	decryptedBytes, err := eciesgo.Decrypt(privateKey, []byte(opt.Data))
-	return string(decryptedBytes), err
+	return encryption.DecryptLayer(data, privateKey)
+}
+
+func (c *crypto) DeriveSecurePassphrase(password string, salt string, params encryption.KdfParams) []byte {
+	return params.DeriveKey(password, salt)
 }
 
-func (c *crypto) DeriveSecurePassphrase(password string, salt string) []byte {
-	return pbkdf2.Key([]byte(password), []byte(salt), derivePasswordHashIter, derivePasswordKeyLen, sha256.New)
+func (c *crypto) CurrentKdfParams() encryption.KdfParams {
+	kdfConfig := config.GetKdfConfig()
+	return encryption.NewArgon2idParams(uint32(kdfConfig.Iterations), uint32(kdfConfig.MemoryKiB), uint8(kdfConfig.Parallelism))
 }
 
 func (c *crypto) GenerateSalt() string {
@@ -161,22 +215,56 @@ func (c *crypto) GenerateSalt() string {
 }
 
 func (c *crypto) CreateEncryptionKeyWithPassword(key *eciesgo.PrivateKey, t userGrant.Type, passphrase string, salt string) (*encryption.Key, error) {
-	userSymmetricKey := c.DeriveUserSymmetricKey(passphrase, salt)
-	return c.CreateEncryptionKey(key, t, userSymmetricKey, salt)
+	params := c.CurrentKdfParams()
+	userSymmetricKey := c.DeriveUserSymmetricKey(passphrase, salt, params)
+	encryptionKey, err := c.CreateEncryptionKey(key, t, userSymmetricKey, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey.SaltKdf = params
+	return encryptionKey, nil
 }
 
 func (c *crypto) CreateEncryptionKey(key *eciesgo.PrivateKey, t userGrant.Type, symmetricKey string, salt string) (*encryption.Key, error) {
-	hex := key.Hex()
-	encryptedHex, iv, err := lib.PerformSymmetricEncryption(hex, []byte(symmetricKey))
+	wrappedKey, err := encryption.NewPrivateKey(key, []byte(symmetricKey))
 	if err != nil {
 		return nil, err
 	}
 
-	privateKey := encryption.NewEncryptionKey(key.PublicKey, encryptedHex, iv, t, salt)
+	privateKey := encryption.NewEncryptionKey(key.PublicKey, wrappedKey, t, salt)
 
 	return &privateKey, nil
 }
 
+func (c *crypto) UnlockPrivateKey(key *encryption.Key, symmetricKey []byte) (*eciesgo.PrivateKey, error) {
+	privateKey, err := key.PrivateKey.Key(symmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.PrivateKey.IsLegacy() {
+		return privateKey, nil
+	}
+
+	// The key is unlocked, failing to migrate it shouldn't fail the caller. It is migrated on a later unlock
+	wrappedKey, err := encryption.NewPrivateKey(privateKey, symmetricKey)
+	if err != nil {
+		fmt.Println("Failed to re-wrap legacy private key: " + err.Error())
+		return privateKey, nil
+	}
+
+	key.PrivateKey = wrappedKey
+	if key.ID != 0 {
+		err = c.keyRepository.Save(key)
+		if err != nil {
+			fmt.Println("Failed to re-wrap legacy private key: " + err.Error())
+		}
+	}
+
+	return privateKey, nil
+}
+
 func (c *crypto) CreateNewEncryptionKey(t userGrant.Type, password string, salt string) (*encryption.Key, error) {
 	key, err := eciesgo.GenerateKey()
 	if err != nil {
@@ -186,22 +274,22 @@ func (c *crypto) CreateNewEncryptionKey(t userGrant.Type, password string, salt
 }
 
 func (c *crypto) CreateJwe(token *jwtLib.Token) (*jose.JSONWebEncryption, error) {
-	pubKey, err := c.keyRepository.GetJwePublicKey()
+	encryptionKey, err := c.serverKeys.GetJweEncryptionKey()
 	if err != nil {
 		return nil, err
 	}
 
-	encrypter, err := jose.NewEncrypter(jose.A128CBC_HS256, jose.Recipient{Algorithm: jose.RSA1_5, Key: pubKey}, nil)
-	if err != nil {
-		return nil, err
+	recipient := jose.Recipient{
+		Algorithm: jose.KeyAlgorithm(encryptionKey.Algorithm),
+		Key:       encryptionKey.Key,
+		KeyID:     encryptionKey.KeyID,
 	}
-
-	signKey, err := c.keyRepository.GetJWTPrivateKey()
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, recipient, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	signedToken, err := token.SignedString(signKey)
+	signedToken, err := c.serverKeys.SignJwt(token)
 	if err != nil {
 		return nil, err
 	}
@@ -215,17 +303,35 @@ func (c *crypto) CreateJwe(token *jwtLib.Token) (*jose.JSONWebEncryption, error)
 }
 
 func (c *crypto) DecodeJwe(serializedJwe string) (string, error) {
-	jwe, err := jose.ParseEncryptedCompact(serializedJwe, []jose.KeyAlgorithm{jose.RSA1_5}, []jose.ContentEncryption{jose.A128CBC_HS256})
+	keyAlgorithms := jweKeyAlgorithms
+	contentEncryptions := []jose.ContentEncryption{jose.A256GCM}
+	acceptsLegacyTokens := c.serverKeys.AcceptsLegacyTokens()
+	if acceptsLegacyTokens {
+		keyAlgorithms = slices.Concat(keyAlgorithms, legacyJweKeyAlgorithms)
+		contentEncryptions = slices.Concat(contentEncryptions, legacyJweContentEncryptions)
+	}
+
+	jwe, err := jose.ParseEncryptedCompact(serializedJwe, keyAlgorithms, contentEncryptions)
 	if err != nil {
 		return "", err
 	}
 
-	pk, err := c.keyRepository.GetJWEPrivateKey()
+	// The key is picked by the kid of the token, so tokens encrypted with a retired key can still be decrypted
+	decryptionKeys, err := c.serverKeys.GetJweDecryptionKeys()
 	if err != nil {
 		return "", err
 	}
 
-	signedJwtBytes, err := jwe.Decrypt(pk)
+	var decryptionKey any = decryptionKeys
+	if jwe.Header.KeyID == "" {
+		// Tokens issued before key ids were encrypted with the current key
+		if !acceptsLegacyTokens || len(decryptionKeys.Keys) == 0 {
+			return "", lib.Error{Msg: "Token without key id"}
+		}
+		decryptionKey = decryptionKeys.Keys[0].Key
+	}
+
+	signedJwtBytes, err := jwe.Decrypt(decryptionKey)
 	if err != nil {
 		return "", err
 	}
@@ -233,6 +339,6 @@ func (c *crypto) DecodeJwe(serializedJwe string) (string, error) {
 	return string(signedJwtBytes), err
 }
 
-func (c *crypto) DeriveUserSymmetricKey(password string, salt string) string {
-	return string(c.DeriveSecurePassphrase(password, salt))
+func (c *crypto) DeriveUserSymmetricKey(password string, salt string, params encryption.KdfParams) string {
+	return string(c.DeriveSecurePassphrase(password, salt, params))
 }
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"os"
+	"shareLog/config"
+	"shareLog/lib"
+	"strings"
+	"sync"
+)
+
+const kekSize = 32 // bytes
+
+// fileKeyBackend Keeps the key encryption key in a local file, meant to be readable by the server only
+type fileKeyBackend struct {
+	kekPath string
+	lock    sync.Mutex
+	kek     []byte
+	signer  *keyFileSigner
+}
+
+func newFileKeyBackend(backendConfig config.KeyBackendConfig) *fileKeyBackend {
+	backend := &fileKeyBackend{kekPath: backendConfig.KekPath}
+	backend.signer = &keyFileSigner{unwrap: backend.Unwrap}
+	return backend
+}
+
+func (f *fileKeyBackend) getKek() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.kek != nil {
+		return f.kek, nil
+	}
+
+	if f.kekPath == "" {
+		return nil, lib.Error{Msg: "No key encryption key file configured"}
+	}
+
+	encodedKek, err := os.ReadFile(f.kekPath)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedKek)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kek) != kekSize {
+		return nil, lib.Error{Msg: "The key encryption key must be 32 bytes"}
+	}
+
+	f.kek = kek
+	return kek, nil
+}
+
+func (f *fileKeyBackend) Wrap(plaintext []byte) (string, error) {
+	kek, err := f.getKek()
+	if err != nil {
+		return "", err
+	}
+
+	return lib.SealEnvelope(plaintext, kek, lib.DefaultKeyVersion)
+}
+
+func (f *fileKeyBackend) Unwrap(wrapped string) ([]byte, error) {
+	kek, err := f.getKek()
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.OpenEnvelope(wrapped, kek)
+}
+
+func (f *fileKeyBackend) Sign(data []byte) ([]byte, error) {
+	return f.signer.Sign(data)
+}
+
+func (f *fileKeyBackend) GetSigningPublicKeys() ([]*ecdsa.PublicKey, error) {
+	return nil, nil
+}
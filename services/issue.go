@@ -0,0 +1,118 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/lib/stackTrace"
+	"shareLog/models"
+	"strings"
+)
+
+// Only the top of the stack trace is used, deep frames are usually framework noise
+const maxFingerprintFrames = 30
+
+var hexAddressRegex = regexp.MustCompile(`0[xX][0-9a-fA-F]+`)
+
+// Only whole numbers are masked, the digits inside identifiers like utf8 or sha256 are kept
+var numberRegex = regexp.MustCompile(`\b[0-9]+\b`)
+
+type issue struct {
+	issueRepository repository.IssueRepository
+	logRepository   repository.LogRepository
+	serverKeys      ServerKeys
+}
+
+type Issue interface {
+	// Fingerprint Returns a keyed hash of the normalized stack trace, or "" for an empty stack trace
+	Fingerprint(stackTrace string) (string, error)
+	GetIssues(cursor *uint, limit int) (*repository.Page[models.Issue], error)
+	GetIssueLogs(issueId uint, cursor *uint, limit int) (*repository.Page[models.Log], error)
+}
+
+type IssueProvider struct {
+}
+
+func (i IssueProvider) Provide() any {
+	var instance Issue = &issue{
+		issueRepository: di.Get[repository.IssueRepository](),
+		logRepository:   di.Get[repository.LogRepository](),
+		serverKeys:      di.Get[ServerKeys](),
+	}
+	return instance
+}
+
+/*
+Normalizes a stack trace so that the same bug produces the same frames across crashes.
+Parsed frames are reduced to their module, function and file, so line numbers and the exception message
+don't split an issue. A stack trace in an unknown format falls back to its lines, with line numbers,
+memory addresses and other volatile numbers masked and blank lines dropped
+*/
+func normalizeStackTrace(trace string) []string {
+	parsedFrames := stackTrace.Parse(trace)
+	frames := make([]string, 0)
+	if len(parsedFrames) > 0 {
+		for _, parsedFrame := range parsedFrames {
+			frames = append(frames, maskVolatileNumbers(parsedFrame.Module+"|"+parsedFrame.Function+"|"+parsedFrame.File))
+			if len(frames) == maxFingerprintFrames {
+				break
+			}
+		}
+
+		return frames
+	}
+
+	for _, line := range strings.Split(trace, "\n") {
+		frame := strings.TrimSpace(line)
+		if frame == "" {
+			continue
+		}
+
+		frames = append(frames, maskVolatileNumbers(frame))
+		if len(frames) == maxFingerprintFrames {
+			break
+		}
+	}
+
+	return frames
+}
+
+func maskVolatileNumbers(frame string) string {
+	frame = hexAddressRegex.ReplaceAllString(frame, "0x?")
+	return numberRegex.ReplaceAllString(frame, "?")
+}
+
+func (i *issue) Fingerprint(stackTrace string) (string, error) {
+	if strings.TrimSpace(stackTrace) == "" {
+		return "", nil
+	}
+
+	fingerprintSecret, err := i.serverKeys.GetFingerprintSecret()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, []byte(fingerprintSecret))
+	for _, frame := range normalizeStackTrace(stackTrace) {
+		mac.Write([]byte(frame))
+		mac.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (i *issue) GetIssues(cursor *uint, limit int) (*repository.Page[models.Issue], error) {
+	return i.issueRepository.GetPage(cursor, limit)
+}
+
+func (i *issue) GetIssueLogs(issueId uint, cursor *uint, limit int) (*repository.Page[models.Log], error) {
+	if i.issueRepository.GetById(issueId) == nil {
+		return nil, lib.Error{Msg: "No issue with given id"}
+	}
+
+	return i.logRepository.GetPageOfIssue(issueId, cursor, limit)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/userGrant"
+	"strconv"
+)
+
+type issueWorkflow struct {
+	issueRepository      repository.IssueRepository
+	issueNoteRepository  repository.IssueNoteRepository
+	issueEventRepository repository.IssueEventRepository
+	userRepository       repository.UserRepository
+	cryptoService        Crypto
+	keyManager           KeyManager
+}
+
+type IssueWorkflow interface {
+	// HaveAccessToIssue Owners can triage every issue, clients only the issues of the logs they acquired
+	HaveAccessToIssue(issueId uint, user *models.User) (bool, error)
+	SetStatus(issueId uint, user *models.User, status models.IssueStatus) error
+	// SetAssignee Assign the issue to a user, or unassign it if assigneeId is nil
+	SetAssignee(issueId uint, user *models.User, assigneeId *uint) error
+	AddNote(issueId uint, user *models.User, content string, level userGrant.Type) (*models.IssueNote, error)
+	// GetNotes Returns the notes the user has a key for, decrypted
+	GetNotes(issueId uint, user *models.User, userSymmetricKey string) ([]models.DecryptedIssueNote, error)
+	GetHistory(issueId uint) ([]models.IssueEvent, error)
+}
+
+type IssueWorkflowProvider struct {
+}
+
+func (i IssueWorkflowProvider) Provide() any {
+	var instance IssueWorkflow = &issueWorkflow{
+		issueRepository:      di.Get[repository.IssueRepository](),
+		issueNoteRepository:  di.Get[repository.IssueNoteRepository](),
+		issueEventRepository: di.Get[repository.IssueEventRepository](),
+		userRepository:       di.Get[repository.UserRepository](),
+		cryptoService:        di.Get[Crypto](),
+		keyManager:           di.Get[KeyManager](),
+	}
+	return instance
+}
+
+// The note levels a user can read and write
+func getNoteLevels(user *models.User) []userGrant.Type {
+	if user.Grant == userGrant.Types.GrantOwner {
+		return []userGrant.Type{userGrant.Types.GrantOwner, userGrant.Types.GrantClient}
+	}
+
+	return []userGrant.Type{userGrant.Types.GrantClient}
+}
+
+func formatUserId(userId *uint) string {
+	if userId == nil {
+		return ""
+	}
+
+	return strconv.Itoa(int(*userId))
+}
+
+func (i *issueWorkflow) getIssue(issueId uint) (*models.Issue, error) {
+	issue := i.issueRepository.GetById(issueId)
+	if issue == nil {
+		return nil, lib.Error{Msg: "No issue with given id"}
+	}
+
+	return issue, nil
+}
+
+func (i *issueWorkflow) HaveAccessToIssue(issueId uint, user *models.User) (bool, error) {
+	_, err := i.getIssue(issueId)
+	if err != nil {
+		return false, err
+	}
+
+	if user.Grant == userGrant.Types.GrantOwner {
+		return true, nil
+	} else if user.Grant == userGrant.Types.GrantClient {
+		return i.issueRepository.IsAcquiredByUser(issueId, user.ID)
+	}
+
+	return false, nil
+}
+
+func (i *issueWorkflow) SetStatus(issueId uint, user *models.User, status models.IssueStatus) error {
+	issue, err := i.getIssue(issueId)
+	if err != nil {
+		return err
+	}
+
+	if issue.Status == status {
+		return nil
+	}
+
+	event := models.IssueEvent{
+		IssueId: issueId,
+		ActorId: user.ID,
+		Type:    models.IssueEventTypes.StatusChanged,
+		From:    issue.Status.Status,
+		To:      status.Status,
+	}
+
+	return i.issueRepository.UpdateWithEvent(issueId, map[string]any{"status": status}, &event)
+}
+
+func (i *issueWorkflow) SetAssignee(issueId uint, user *models.User, assigneeId *uint) error {
+	issue, err := i.getIssue(issueId)
+	if err != nil {
+		return err
+	}
+
+	if assigneeId != nil {
+		assignee := i.userRepository.GetById(*assigneeId)
+		if assignee == nil {
+			return lib.Error{Msg: "No user with given id"}
+		}
+	}
+
+	if formatUserId(issue.AssigneeId) == formatUserId(assigneeId) {
+		return nil
+	}
+
+	event := models.IssueEvent{
+		IssueId: issueId,
+		ActorId: user.ID,
+		Type:    models.IssueEventTypes.AssigneeChanged,
+		From:    formatUserId(issue.AssigneeId),
+		To:      formatUserId(assigneeId),
+	}
+
+	return i.issueRepository.UpdateWithEvent(issueId, map[string]any{"assignee_id": assigneeId}, &event)
+}
+
+func (i *issueWorkflow) AddNote(issueId uint, user *models.User, content string, level userGrant.Type) (*models.IssueNote, error) {
+	_, err := i.getIssue(issueId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !lib.Contains(getNoteLevels(user), level) {
+		return nil, lib.Error{Msg: "Cannot write notes at this level", Reason: level.Name}
+	}
+
+	var encryptedContent string
+	if level == userGrant.Types.GrantOwner {
+		encryptedContent, err = i.cryptoService.EncryptOwnerLevel(content)
+	} else {
+		encryptedContent, err = i.cryptoService.EncryptClientLevel(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	note := models.IssueNote{
+		IssueId:          issueId,
+		AuthorId:         user.ID,
+		Level:            level,
+		EncryptedContent: encryptedContent,
+	}
+	event := models.IssueEvent{
+		IssueId: issueId,
+		ActorId: user.ID,
+		Type:    models.IssueEventTypes.NoteAdded,
+	}
+	err = i.issueNoteRepository.SaveWithEvent(&note, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+func (i *issueWorkflow) GetNotes(issueId uint, user *models.User, userSymmetricKey string) ([]models.DecryptedIssueNote, error) {
+	notes, err := i.issueNoteRepository.GetByIssueId(issueId, getNoteLevels(user))
+	if err != nil {
+		return nil, err
+	}
+
+	decryptedNotes := make([]models.DecryptedIssueNote, 0, len(notes))
+	for _, note := range notes {
+		content, err := i.cryptoService.DecryptMessageForLevel(&DecryptOptions{
+			Data:            note.EncryptedContent,
+			Usr:             user,
+			UsrSymmetricKey: userSymmetricKey,
+			OwnerLevelKey:   i.keyManager.GetKeyForLevel(user, userGrant.Types.GrantOwner),
+			ClientLevelKey:  i.keyManager.GetKeyForLevel(user, userGrant.Types.GrantClient),
+		}, note.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		decryptedNotes = append(decryptedNotes, models.DecryptedIssueNote{
+			IssueNote: note,
+			Content:   content,
+		})
+	}
+
+	return decryptedNotes, nil
+}
+
+func (i *issueWorkflow) GetHistory(issueId uint) ([]models.IssueEvent, error) {
+	return i.issueEventRepository.GetByIssueId(issueId)
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeStackTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+		other string
+		same  bool
+	}{
+		{
+			name: "python traces differing in the message",
+			first: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in <module>
+    handle()
+KeyError: 'a'`,
+			other: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in <module>
+    handle()
+KeyError: 'b'`,
+			same: true,
+		},
+		{
+			name: "java traces differing in line numbers",
+			first: `java.lang.IllegalStateException: Boom
+	at com.app.Foo.bar(Foo.java:12)`,
+			other: `java.lang.IllegalStateException: Boom
+	at com.app.Foo.bar(Foo.java:15)`,
+			same: true,
+		},
+		{
+			name: "python traces differing in the function",
+			first: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in handle
+KeyError: 'a'`,
+			other: `Traceback (most recent call last):
+  File "/app/main.py", line 10, in serve
+KeyError: 'a'`,
+			same: false,
+		},
+		{
+			name:  "unknown format differing in an address",
+			first: "crashed at 0x7ffee4b2c8a0",
+			other: "crashed at 0x7ffee4b2d100",
+			same:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := normalizeStackTrace(test.first)
+			other := normalizeStackTrace(test.other)
+			if reflect.DeepEqual(first, other) != test.same {
+				t.Errorf("normalizeStackTrace() = %v and %v, expected same = %v", first, other, test.same)
+			}
+		})
+	}
+}
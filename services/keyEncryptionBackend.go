@@ -0,0 +1,87 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	jwtLib "github.com/golang-jwt/jwt/v5"
+	"shareLog/config"
+	"shareLog/lib"
+	"sync"
+)
+
+/*
+KeyEncryptionBackend holds the key encryption key protecting the secrets of the server, like the JWT and JWE keys
+and the log sharing secret. The secrets are stored wrapped and only unwrapped in memory
+*/
+type KeyEncryptionBackend interface {
+	Wrap(plaintext []byte) (string, error)
+	Unwrap(wrapped string) ([]byte, error)
+	// Sign Returns the ES512 signature of the data, in the format used by JWTs
+	Sign(data []byte) ([]byte, error)
+	/*
+		GetSigningPublicKeys Returns the public keys of every version of the signing key held by the backend, newest first.
+		Nil when the backend signs with the local JWT private key, whose public key is read from its file
+	*/
+	GetSigningPublicKeys() ([]*ecdsa.PublicKey, error)
+}
+
+type KeyEncryptionBackendProvider struct {
+}
+
+func (k KeyEncryptionBackendProvider) Provide() any {
+	backendConfig := config.GetKeyBackendConfig()
+
+	var instance KeyEncryptionBackend
+	switch backendConfig.Backend {
+	case config.TransitKeyBackend:
+		instance = newTransitKeyBackend(backendConfig)
+	case config.FileKeyBackend:
+		instance = newFileKeyBackend(backendConfig)
+	default:
+		panic("Unknown key backend: " + backendConfig.Backend)
+	}
+
+	return instance
+}
+
+// keyFileSigner Signs with the local JWT private key, read and unwrapped once
+type keyFileSigner struct {
+	unwrap func(wrapped string) ([]byte, error)
+	lock   sync.Mutex
+	key    *ecdsa.PrivateKey
+}
+
+func (s *keyFileSigner) getKey() (*ecdsa.PrivateKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.key != nil {
+		return s.key, nil
+	}
+
+	keyBytes, err := readSecretFile(config.GetKeyPaths().JwtPkPath, s.unwrap)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePemPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, lib.Error{Msg: "The JWT private key isn't an ecdsa key"}
+	}
+
+	s.key = ecKey
+	return ecKey, nil
+}
+
+func (s *keyFileSigner) Sign(data []byte) ([]byte, error) {
+	key, err := s.getKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return jwtLib.SigningMethodES512.Sign(string(data), key)
+}
@@ -1,21 +1,25 @@
 package services
 
 import (
+	"cmp"
 	"fmt"
+	eciesgo "github.com/ecies/go/v2"
 	jwtLib "github.com/golang-jwt/jwt/v5"
-	"shareLog/config"
 	"shareLog/data/repository"
 	"shareLog/di"
 	"shareLog/lib"
 	"shareLog/models"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
+	"slices"
 	"strconv"
 )
 
 type keyManager struct {
-	keyRepository repository.KeyRepository
-	cryptoService Crypto
+	keyRepository           repository.KeyRepository
+	logPermissionRepository repository.LogPermissionRepository
+	cryptoService           Crypto
+	serverKeys              ServerKeys
 }
 
 type KeyManager interface {
@@ -41,8 +45,36 @@ type KeyManager interface {
 		symmetricKeySalt string,
 	) ([]encryption.Key, error)
 	GetUserSymmetricKey(jwt jwtLib.Token) (string, error)
+	// GetKeyForLevel Returns the key of the user with the given grant, or nil if the user doesn't hold one
+	GetKeyForLevel(user *models.User, level userGrant.Type) *encryption.Key
 	// GetDecryptionKeysForLog Returns [ownerKey, clientKey] for a client grant user. T
 	GetDecryptionKeysForLog(user *models.User, logId uint) lib.Pair[*encryption.Key, *encryption.Key]
+	/*
+		CreateKeyGeneration Creates a new generation of the owner or client key pair for the given user,
+		and copies wrapped to the personal key of every other user holding the grant, acquired on sign in or refresh.
+		Pending invites holding the grant get a copy wrapped to their personal key, unwrapped on sign up.
+		Returns the new generation
+	*/
+	CreateKeyGeneration(user *models.User, userSymmetricKey string, grant userGrant.Type) (uint, error)
+	// AcquireRotatedKeys Saves the generations of the keys the user is missing for the grants they hold
+	AcquireRotatedKeys(user *models.User, userSymmetricKey string) ([]encryption.Key, error)
+	// GetRotatedKeys Returns the generations the user is missing, still wrapped to their personal key
+	GetRotatedKeys(user *models.User) ([]encryption.Key, error)
+	// CreatePersonalKey Creates the personal key of a new user, wrapped with their symmetric key. It isn't saved
+	CreatePersonalKey(userSymmetricKey string, salt string) (*encryption.Key, error)
+	// EnsurePersonalKey Creates and saves the personal key of a user who signed up before personal keys existed
+	EnsurePersonalKey(user *models.User, userSymmetricKey string) error
+	// MigrateLegacyKeys Re-wraps the legacy keys of the user in envelopes, the only format clients can unwrap
+	MigrateLegacyKeys(user *models.User, userSymmetricKey string) error
+	/*
+		MigrateLegacySharedKeys Wraps the shared keys still wrapped with the log sharing secret to their recipient.
+		Afterwards the secret isn't needed to share logs anymore
+	*/
+	MigrateLegacySharedKeys() error
+	// ShareKey Wraps the private key to the personal key of the recipient, so only they can acquire it. It isn't saved
+	ShareKey(key *eciesgo.PrivateKey, recipientId uint) (*encryption.Key, error)
+	// RewrapKeys Returns copies of the keys, wrapped with the new symmetric key instead of the old one
+	RewrapKeys(keys []encryption.Key, oldSymmetricKey string, newSymmetricKey string) ([]encryption.Key, error)
 }
 
 type KeyManagerProvider struct {
@@ -50,8 +82,10 @@ type KeyManagerProvider struct {
 
 func (m KeyManagerProvider) Provide() any {
 	var instance KeyManager = &keyManager{
-		keyRepository: di.Get[repository.KeyRepository](),
-		cryptoService: di.Get[Crypto](),
+		keyRepository:           di.Get[repository.KeyRepository](),
+		logPermissionRepository: di.Get[repository.LogPermissionRepository](),
+		cryptoService:           di.Get[Crypto](),
+		serverKeys:              di.Get[ServerKeys](),
 	}
 	return instance
 }
@@ -62,11 +96,11 @@ func (k *keyManager) AcquireSharedKey(
 	userSymmetricKey string,
 	saveToDb bool,
 ) (*encryption.Key, error) {
-	sharedKeySymmetricKey := k.cryptoService.DeriveSecurePassphrase(config.GetSecrets().LogSharingSecret, keyToAcquire.Salt)
-	pk, err := keyToAcquire.PrivateKey.Key(sharedKeySymmetricKey)
+	pk, err := k.unlockKeyForRecipient(user, keyToAcquire, userSymmetricKey)
 	if err != nil {
 		return nil, err
 	}
+
 	acquiredKey, err := k.cryptoService.CreateEncryptionKey(pk, userGrant.Types.GrantPartialOwner, userSymmetricKey, user.EncryptionKeySalt)
 	if err != nil {
 		return nil, err
@@ -85,9 +119,101 @@ func (k *keyManager) AcquireSharedKey(
 	return acquiredKey, nil
 }
 
+// Unwraps a key wrapped to the personal key of the user
+func (k *keyManager) unlockKeyForRecipient(
+	user *models.User,
+	keyToUnlock *encryption.Key,
+	userSymmetricKey string,
+) (*eciesgo.PrivateKey, error) {
+	if keyToUnlock.RecipientId == nil || *keyToUnlock.RecipientId != user.ID {
+		return nil, lib.Error{Msg: "The key is shared with another user"}
+	}
+
+	personalKey, err := k.keyRepository.GetPersonalKey(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if personalKey == nil {
+		return nil, lib.Error{Msg: "No personal key to unwrap the shared key"}
+	}
+
+	personalPk, err := k.cryptoService.UnlockPrivateKey(personalKey, []byte(userSymmetricKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return keyToUnlock.PrivateKey.KeyForRecipient(personalPk)
+}
+
+func (k *keyManager) MigrateLegacySharedKeys() error {
+	legacyKeys, err := k.keyRepository.GetLegacySharedKeys()
+	if err != nil || len(legacyKeys) == 0 {
+		return err
+	}
+
+	logSharingSecret, err := k.serverKeys.GetLogSharingSecret()
+	if err != nil {
+		return err
+	}
+
+	for _, legacyKey := range legacyKeys {
+		err = k.migrateLegacySharedKey(&legacyKey, logSharingSecret)
+		if err != nil {
+			return lib.Error{Msg: fmt.Sprintf("Failed to migrate the shared key %d", legacyKey.ID), Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+/*
+Wraps the legacy shared key to the personal key of the user who requested the log.
+Keys already acquired aren't needed anymore and are deleted. When the requesting user is unknown or has no personal key
+yet, the key is revoked and the request is pending again, so an owner can share the log with a new key
+*/
+func (k *keyManager) migrateLegacySharedKey(legacyKey *encryption.Key, logSharingSecret string) error {
+	isAcquired, err := k.keyRepository.IsLogKeyAcquired(*legacyKey.LogId)
+	if err != nil {
+		return err
+	}
+	if isAcquired {
+		return k.keyRepository.DeletePermanently(legacyKey)
+	}
+
+	request, err := k.logPermissionRepository.GetByLogId(*legacyKey.LogId)
+	if err != nil || request.RequestedById == nil {
+		return k.keyRepository.RevokeLegacySharedKey(legacyKey)
+	}
+
+	personalKey, err := k.keyRepository.GetPersonalKey(*request.RequestedById)
+	if err != nil {
+		return err
+	}
+	if personalKey == nil {
+		return k.keyRepository.RevokeLegacySharedKey(legacyKey)
+	}
+
+	sharedKeySymmetricKey := k.cryptoService.DeriveSecurePassphrase(logSharingSecret, legacyKey.Salt, legacyKey.SaltKdf)
+	pk, err := k.cryptoService.UnlockPrivateKey(legacyKey, sharedKeySymmetricKey)
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := encryption.NewPrivateKeyForRecipient(pk, personalKey.PublicKey.Key)
+	if err != nil {
+		return err
+	}
+
+	legacyKey.PrivateKey = wrappedKey
+	legacyKey.RecipientId = request.RequestedById
+	legacyKey.Salt = ""
+	legacyKey.SaltKdf = encryption.KdfParams{}
+	return k.keyRepository.Save(legacyKey)
+}
+
 func (k *keyManager) AcquireSharedKeys(user *models.User, password string, salt string) ([]encryption.Key, error) {
 	acquiredPks := make([]encryption.Key, 0)
-	userSymmetricKey := k.cryptoService.DeriveUserSymmetricKey(password, salt)
+	userSymmetricKey := k.cryptoService.DeriveUserSymmetricKey(password, salt, user.EncryptionKeyKdf)
 
 	keysToAcquire, err := k.keyRepository.GetUnacquiredSharedKeys(user.ID)
 	if err != nil {
@@ -145,12 +271,12 @@ func (k *keyManager) GetKeysForInvite(
 ) ([]encryption.Key, error) {
 	pks := make([]encryption.Key, 0)
 	for _, key := range refUser.EncryptionKeys {
-		if key.UserGrant.AuthorityLevel > grantType.AuthorityLevel {
+		if key.UserGrant.AuthorityLevel > grantType.AuthorityLevel || key.UserGrant == userGrant.Types.GrantPersonal {
 			// The invited user doesn't get this key
 			continue
 		}
 
-		pk, pkError := key.PrivateKey.Key([]byte(refUserSymmetricKey))
+		pk, pkError := k.cryptoService.UnlockPrivateKey(&key, []byte(refUserSymmetricKey))
 		if pkError != nil {
 			return nil, pkError
 		}
@@ -160,11 +286,23 @@ func (k *keyManager) GetKeysForInvite(
 		if err != nil {
 			return nil, err
 		}
+		encryptedKey.Generation = key.Generation
 
 		pks = append(pks, *encryptedKey)
 	}
 
-	return pks, nil
+	// The generations created while the invite is pending are wrapped to this key, the code is unknown by then
+	transportPk, err := eciesgo.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	transportKey, err := k.cryptoService.CreateEncryptionKeyWithPassword(transportPk, userGrant.Types.GrantPersonal, inviteCode, k.cryptoService.GenerateSalt())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(pks, *transportKey), nil
 }
 
 func (k *keyManager) CreateKeysForNewUser(
@@ -179,11 +317,27 @@ func (k *keyManager) CreateKeysForNewUser(
 		return nil, lib.Error{Msg: "Invalid invite"}
 	}
 
+	// Invites made before transport keys existed have none, none of their keys is wrapped to it
+	var transportPk *eciesgo.PrivateKey
+	transportKey := lib.Find(sourceKeys, func(key encryption.Key) bool {
+		return key.UserGrant == userGrant.Types.GrantPersonal
+	})
+	if transportKey != nil {
+		var err error
+		transportPk, err = k.cryptoService.UnlockPrivateKey(transportKey, k.cryptoService.DeriveSecurePassphrase(code, transportKey.Salt, transportKey.SaltKdf))
+		if err != nil {
+			return nil, lib.Error{Msg: "Invalid invite"}
+		}
+	}
+
 	finalKeys := make([]encryption.Key, 0)
 	for _, key := range sourceKeys {
-		tempKeyPassphrase := k.cryptoService.DeriveSecurePassphrase(code, key.Salt)
+		if key.UserGrant == userGrant.Types.GrantPersonal {
+			// The new user gets a personal key of their own
+			continue
+		}
 
-		sourcePk, err := key.PrivateKey.Key(tempKeyPassphrase)
+		sourcePk, err := k.unlockInviteKey(&key, code, transportPk)
 		if err != nil {
 			return nil, lib.Error{Msg: "Invalid invite"}
 		}
@@ -192,6 +346,7 @@ func (k *keyManager) CreateKeysForNewUser(
 		if err != nil {
 			return nil, err
 		}
+		encryptedKey.Generation = key.Generation
 
 		finalKeys = append(finalKeys, *encryptedKey)
 	}
@@ -199,6 +354,19 @@ func (k *keyManager) CreateKeysForNewUser(
 	return finalKeys, nil
 }
 
+// Unlocks a key of an invite, wrapped with the invite code or, for the generations rotated since, to the transport key
+func (k *keyManager) unlockInviteKey(key *encryption.Key, code string, transportPk *eciesgo.PrivateKey) (*eciesgo.PrivateKey, error) {
+	if key.Salt != "" {
+		return k.cryptoService.UnlockPrivateKey(key, k.cryptoService.DeriveSecurePassphrase(code, key.Salt, key.SaltKdf))
+	}
+
+	if transportPk == nil {
+		return nil, lib.Error{Msg: "The invite has no transport key"}
+	}
+
+	return key.PrivateKey.KeyForRecipient(transportPk)
+}
+
 func (k *keyManager) GetUserSymmetricKey(jwt jwtLib.Token) (string, error) {
 	claims := jwt.Claims.(*jwtClaims)
 	return k.DecodeEncryptionKeyForJWT(claims.EncodedSymmetricKey)
@@ -214,15 +382,29 @@ func (k *keyManager) GetDecryptionKeysForLog(user *models.User, logId uint) lib.
 	panic("Unknown user grant for decryption")
 }
 
-// Returns [ownerKey, clientKey] for an owner grant user
-func (k *keyManager) getKeysForOwner(user *models.User) lib.Pair[*encryption.Key, *encryption.Key] {
-	ownerKey := lib.Find(user.EncryptionKeys, func(key encryption.Key) bool {
-		return key.UserGrant == userGrant.Types.GrantOwner
+// Returns the newest generation of the keys matching the predicate, or nil if none does
+func findNewestKey(keys []encryption.Key, predicate func(encryption.Key) bool) *encryption.Key {
+	matchingKeys := lib.Filter(keys, predicate)
+	if len(matchingKeys) == 0 {
+		return nil
+	}
+
+	newestKey := slices.MaxFunc(matchingKeys, func(a, b encryption.Key) int {
+		return cmp.Compare(a.Generation, b.Generation)
 	})
+	return &newestKey
+}
 
-	clientKey := lib.Find(user.EncryptionKeys, func(key encryption.Key) bool {
-		return key.UserGrant == userGrant.Types.GrantClient
+func (k *keyManager) GetKeyForLevel(user *models.User, level userGrant.Type) *encryption.Key {
+	return findNewestKey(user.EncryptionKeys, func(key encryption.Key) bool {
+		return key.UserGrant == level
 	})
+}
+
+// Returns [ownerKey, clientKey] for an owner grant user
+func (k *keyManager) getKeysForOwner(user *models.User) lib.Pair[*encryption.Key, *encryption.Key] {
+	ownerKey := k.GetKeyForLevel(user, userGrant.Types.GrantOwner)
+	clientKey := k.GetKeyForLevel(user, userGrant.Types.GrantClient)
 
 	return lib.Pair[*encryption.Key, *encryption.Key]{
 		First:  ownerKey,
@@ -237,12 +419,224 @@ func (k *keyManager) getKeysForClient(user *models.User, logId uint) lib.Pair[*e
 		return key.UserGrant == userGrant.Types.GrantPartialOwner && *key.LogId == logId
 	})
 
-	clientKey := lib.Find(user.EncryptionKeys, func(key encryption.Key) bool {
-		return key.UserGrant == userGrant.Types.GrantClient
-	})
+	clientKey := k.GetKeyForLevel(user, userGrant.Types.GrantClient)
 
 	return lib.Pair[*encryption.Key, *encryption.Key]{
 		First:  ownerKey,
 		Second: clientKey,
 	}
 }
+
+func (k *keyManager) CreatePersonalKey(userSymmetricKey string, salt string) (*encryption.Key, error) {
+	pk, err := eciesgo.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return k.cryptoService.CreateEncryptionKey(pk, userGrant.Types.GrantPersonal, userSymmetricKey, salt)
+}
+
+func (k *keyManager) EnsurePersonalKey(user *models.User, userSymmetricKey string) error {
+	personalKey, err := k.keyRepository.GetPersonalKey(user.ID)
+	if err != nil || personalKey != nil {
+		return err
+	}
+
+	personalKey, err = k.CreatePersonalKey(userSymmetricKey, user.EncryptionKeySalt)
+	if err != nil {
+		return err
+	}
+
+	personalKey.UserOwnerId = &user.ID
+	return k.keyRepository.Save(personalKey)
+}
+
+func (k *keyManager) MigrateLegacyKeys(user *models.User, userSymmetricKey string) error {
+	userKeys, err := k.keyRepository.GetByUserOwnerId(user.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range userKeys {
+		if !key.PrivateKey.IsLegacy() {
+			continue
+		}
+
+		// Unlocking a legacy key saves it re-wrapped
+		_, err = k.cryptoService.UnlockPrivateKey(&key, []byte(userSymmetricKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *keyManager) ShareKey(key *eciesgo.PrivateKey, recipientId uint) (*encryption.Key, error) {
+	return k.wrapKeyForRecipient(key, userGrant.Types.GrantShared, recipientId)
+}
+
+// Wraps the private key to the personal key of the recipient. The key isn't saved
+func (k *keyManager) wrapKeyForRecipient(key *eciesgo.PrivateKey, grant userGrant.Type, recipientId uint) (*encryption.Key, error) {
+	personalKey, err := k.keyRepository.GetPersonalKey(recipientId)
+	if err != nil {
+		return nil, err
+	}
+	if personalKey == nil {
+		return nil, lib.Error{Msg: "The user has no personal key yet, they need to sign in again", Reason: strconv.FormatUint(uint64(recipientId), 10)}
+	}
+
+	wrappedKey, err := encryption.NewPrivateKeyForRecipient(key, personalKey.PublicKey.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedForRecipient := encryption.NewEncryptionKey(key.PublicKey, wrappedKey, grant, "")
+	wrappedForRecipient.RecipientId = &recipientId
+	return &wrappedForRecipient, nil
+}
+
+func (k *keyManager) RewrapKeys(keys []encryption.Key, oldSymmetricKey string, newSymmetricKey string) ([]encryption.Key, error) {
+	rewrappedKeys := make([]encryption.Key, 0, len(keys))
+	for _, key := range keys {
+		pk, err := key.PrivateKey.Key([]byte(oldSymmetricKey))
+		if err != nil {
+			return nil, err
+		}
+
+		wrappedKey, err := encryption.NewPrivateKey(pk, []byte(newSymmetricKey))
+		if err != nil {
+			return nil, err
+		}
+
+		key.PrivateKey = wrappedKey
+		rewrappedKeys = append(rewrappedKeys, key)
+	}
+
+	return rewrappedKeys, nil
+}
+
+func (k *keyManager) CreateKeyGeneration(user *models.User, userSymmetricKey string, grant userGrant.Type) (uint, error) {
+	currentGeneration, err := k.keyRepository.GetCurrentGeneration(grant)
+	if err != nil {
+		return 0, err
+	}
+	generation := currentGeneration + 1
+
+	pk, err := eciesgo.GenerateKey()
+	if err != nil {
+		return 0, err
+	}
+
+	userKey, err := k.cryptoService.CreateEncryptionKey(pk, grant, userSymmetricKey, user.EncryptionKeySalt)
+	if err != nil {
+		return 0, err
+	}
+	userKey.Generation = generation
+	userKey.UserOwnerId = &user.ID
+
+	holderIds, err := k.keyRepository.GetHolderIds(grant)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every other holder gets a copy wrapped to their personal key, so only they can acquire it
+	keys := []*encryption.Key{userKey}
+	for _, holderId := range holderIds {
+		if holderId == user.ID {
+			continue
+		}
+
+		distributionKey, err := k.wrapKeyForRecipient(pk, grant, holderId)
+		if err != nil {
+			return 0, err
+		}
+		distributionKey.Generation = generation
+
+		keys = append(keys, distributionKey)
+	}
+
+	transportKeys, err := k.keyRepository.GetInviteTransportKeys(grant)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, transportKey := range transportKeys {
+		wrappedKey, err := encryption.NewPrivateKeyForRecipient(pk, transportKey.PublicKey.Key)
+		if err != nil {
+			return 0, err
+		}
+
+		inviteKey := encryption.NewEncryptionKey(pk.PublicKey, wrappedKey, grant, "")
+		inviteKey.InviteOwnerId = transportKey.InviteOwnerId
+		inviteKey.Generation = generation
+
+		keys = append(keys, &inviteKey)
+	}
+
+	err = k.keyRepository.SaveAllAtomically(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	return generation, nil
+}
+
+func (k *keyManager) GetRotatedKeys(user *models.User) ([]encryption.Key, error) {
+	userKeys, err := k.keyRepository.GetByUserOwnerId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rotatedKeys := make([]encryption.Key, 0)
+	for _, grant := range []userGrant.Type{userGrant.Types.GrantOwner, userGrant.Types.GrantClient} {
+		heldKeys := lib.Filter(userKeys, func(key encryption.Key) bool {
+			return key.UserGrant == grant
+		})
+		if len(heldKeys) == 0 {
+			continue
+		}
+
+		distributionKeys, err := k.keyRepository.GetDistributionKeys(user.ID, grant)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, distributionKey := range distributionKeys {
+			isHeld := lib.Exists(heldKeys, func(key encryption.Key) bool {
+				return key.Generation == distributionKey.Generation
+			})
+			if !isHeld {
+				rotatedKeys = append(rotatedKeys, distributionKey)
+			}
+		}
+	}
+
+	return rotatedKeys, nil
+}
+
+func (k *keyManager) AcquireRotatedKeys(user *models.User, userSymmetricKey string) ([]encryption.Key, error) {
+	rotatedKeys, err := k.GetRotatedKeys(user)
+	if err != nil || len(rotatedKeys) == 0 {
+		return nil, err
+	}
+
+	acquiredKeys := make([]encryption.Key, 0, len(rotatedKeys))
+	for _, rotatedKey := range rotatedKeys {
+		pk, err := k.unlockKeyForRecipient(user, &rotatedKey, userSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+
+		acquiredKey, err := k.cryptoService.CreateEncryptionKey(pk, rotatedKey.UserGrant, userSymmetricKey, user.EncryptionKeySalt)
+		if err != nil {
+			return nil, err
+		}
+		acquiredKey.Generation = rotatedKey.Generation
+		acquiredKey.UserOwnerId = &user.ID
+
+		acquiredKeys = append(acquiredKeys, *acquiredKey)
+	}
+
+	return acquiredKeys, k.keyRepository.SaveAll(acquiredKeys)
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/userGrant"
+	"testing"
+)
+
+// Turns the key shared for the log back into a key wrapped with the log sharing secret, as they were before migrating
+func makeSharedKeyLegacy(t *testing.T, logId uint, client *models.User, clientSymmetricKey string) {
+	keyRepository := di.Get[repository.KeyRepository]()
+	cryptoService := di.Get[Crypto]()
+	sharedKey, err := keyRepository.GetSharedKeyForLog(logId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	personalKey, err := keyRepository.GetPersonalKey(client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	personalPk, err := cryptoService.UnlockPrivateKey(personalKey, []byte(clientSymmetricKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := sharedKey.PrivateKey.KeyForRecipient(personalPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logSharingSecret, err := di.Get[ServerKeys]().GetLogSharingSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyKey, err := cryptoService.CreateEncryptionKeyWithPassword(pk, userGrant.Types.GrantShared, logSharingSecret, cryptoService.GenerateSalt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sharedKey.PrivateKey = legacyKey.PrivateKey
+	sharedKey.Salt = legacyKey.Salt
+	sharedKey.SaltKdf = legacyKey.SaltKdf
+	sharedKey.RecipientId = nil
+	err = keyRepository.Save(sharedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupSharedLog(t *testing.T, env *testEnv) (*models.User, string, *models.User, string, *models.Log) {
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+	client, clientSymmetricKey := env.signUpInvited(t, invite, code, "client@test.com")
+	log := env.saveLog(t, "shared")
+	env.shareLog(t, log.ID, owner, ownerSymmetricKey, client)
+
+	return owner, ownerSymmetricKey, client, clientSymmetricKey, log
+}
+
+func TestMigrateLegacySharedKey(t *testing.T) {
+	env := setupTestEnv(t)
+	_, _, client, clientSymmetricKey, log := setupSharedLog(t, env)
+	makeSharedKeyLegacy(t, log.ID, client, clientSymmetricKey)
+
+	err := di.Get[KeyManager]().MigrateLegacySharedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sharedKey, err := di.Get[repository.KeyRepository]().GetSharedKeyForLog(log.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharedKey.RecipientId == nil || *sharedKey.RecipientId != client.ID || sharedKey.Salt != "" {
+		t.Fatal("the shared key isn't wrapped to the requesting client")
+	}
+
+	client, clientSymmetricKey = env.signIn(t, "client@test.com")
+	decryptedLog, err := di.Get[Logger]().GetDecryptedLog(log.ID, client, clientSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptedLog.Message != "shared" {
+		t.Errorf("message = %q", decryptedLog.Message)
+	}
+}
+
+func TestMigrateLegacySharedKeyWithoutRequester(t *testing.T) {
+	env := setupTestEnv(t)
+	_, _, client, clientSymmetricKey, log := setupSharedLog(t, env)
+	makeSharedKeyLegacy(t, log.ID, client, clientSymmetricKey)
+	err := env.db.Model(&models.PermissionRequest{}).Where("log_id = ?", log.ID).Update("requested_by_id", nil).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = di.Get[KeyManager]().MigrateLegacySharedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = di.Get[repository.KeyRepository]().GetSharedKeyForLog(log.ID)
+	if err == nil {
+		t.Error("the legacy key of an unknown requester wasn't revoked")
+	}
+	clientCopy, err := di.Get[repository.LogRepository]().GetClientCopy(log.ID)
+	if err != nil || clientCopy != nil {
+		t.Errorf("the copy of the revoked key is still there: %v", err)
+	}
+	request, err := di.Get[repository.LogPermissionRepository]().GetByLogId(log.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != models.PermissionRequestStatuses.Pending {
+		t.Errorf("request status = %v, expected pending", request.Status)
+	}
+}
+
+// The migration deletes the legacy keys already acquired, rotating the client keys still re-encrypts their copies
+func TestRotationAfterMigratingAcquiredSharedKey(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey, _, _, log := setupSharedLog(t, env)
+	client, clientSymmetricKey := env.signIn(t, "client@test.com")
+	makeSharedKeyLegacy(t, log.ID, client, clientSymmetricKey)
+
+	err := di.Get[KeyManager]().MigrateLegacySharedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = di.Get[repository.KeyRepository]().GetSharedKeyForLog(log.ID)
+	if err == nil {
+		t.Fatal("the acquired legacy key wasn't deleted")
+	}
+
+	rotation, err := di.Get[KeyRotation]().StartRotation(owner, ownerSymmetricKey, userGrant.Types.GrantClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotation = env.waitForRotation(t, rotation.ID)
+	if rotation.Status != models.KeyRotationStatuses.Completed {
+		t.Fatalf("rotation status = %s, error = %s", rotation.Status.Status, rotation.Error)
+	}
+
+	client, clientSymmetricKey = env.signIn(t, "client@test.com")
+	decryptedLog, err := di.Get[Logger]().GetDecryptedLog(log.ID, client, clientSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptedLog.Message != "shared" {
+		t.Errorf("message = %q", decryptedLog.Message)
+	}
+}
+
+func TestUnlockInviteKeys(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+	invite, err := di.Get[repository.InviteRepository]().GetByIdWithKeys(invite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = di.Get[KeyManager]().CreateKeysForNewUser(invite, "wrong code", testPassword, "salt")
+	if err == nil {
+		t.Error("the invite keys were unlocked with a wrong code")
+	}
+
+	keys, err := di.Get[KeyManager]().CreateKeysForNewUser(invite, code, testPassword, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if key.UserGrant != userGrant.Types.GrantClient {
+			t.Errorf("a client invite gave a %s key", key.UserGrant.Name)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"fmt"
+	eciesgo "github.com/ecies/go/v2"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"sync"
+)
+
+const rotationPageSize = 100
+
+type keyRotation struct {
+	keyRotationRepository repository.KeyRotationRepository
+	keyRepository         repository.KeyRepository
+	logRepository         repository.LogRepository
+	loggerService         Logger
+	keyManager            KeyManager
+	cryptoService         Crypto
+	// Guards against starting two jobs at once
+	lock sync.Mutex
+}
+
+type KeyRotation interface {
+	/*
+		StartRotation Creates a new generation of the owner or client keys.
+		New data is encrypted with it right away and the existing logs are re-encrypted with it in the background
+	*/
+	StartRotation(user *models.User, userSymmetricKey string, grant userGrant.Type) (*models.KeyRotation, error)
+	// ResumeRotation Resumes a paused or failed rotation with the keys of the given owner
+	ResumeRotation(id uint, user *models.User, userSymmetricKey string) (*models.KeyRotation, error)
+	GetRotations() ([]models.KeyRotation, error)
+	GetRotation(id uint) (*models.KeyRotation, error)
+	// PauseInterruptedRotations Marks the rotations that were running when the server stopped as paused.
+	// They need the keys of an owner to resume
+	PauseInterruptedRotations() error
+}
+
+type KeyRotationProvider struct {
+}
+
+func (k KeyRotationProvider) Provide() any {
+	var instance KeyRotation = &keyRotation{
+		keyRotationRepository: di.Get[repository.KeyRotationRepository](),
+		keyRepository:         di.Get[repository.KeyRepository](),
+		logRepository:         di.Get[repository.LogRepository](),
+		loggerService:         di.Get[Logger](),
+		keyManager:            di.Get[KeyManager](),
+		cryptoService:         di.Get[Crypto](),
+	}
+	return instance
+}
+
+/*
+Returns a cipher removing the owner and client layers of encryption with any generation of the keys held by the user.
+Data already encrypted with the new generation can be unwrapped too, so an interrupted page can be processed again
+*/
+func (k *keyRotation) getUnwrapCipher(user *models.User, userSymmetricKey string) (func(string) (string, error), error) {
+	userKeys, err := k.keyRepository.GetByUserOwnerId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerKeys := make([]*eciesgo.PrivateKey, 0)
+	clientKeys := make([]*eciesgo.PrivateKey, 0)
+	for _, key := range userKeys {
+		if key.UserGrant != userGrant.Types.GrantOwner && key.UserGrant != userGrant.Types.GrantClient {
+			continue
+		}
+
+		pk, err := k.cryptoService.UnlockPrivateKey(&key, []byte(userSymmetricKey))
+		if err != nil {
+			return nil, err
+		}
+
+		if key.UserGrant == userGrant.Types.GrantOwner {
+			ownerKeys = append(ownerKeys, pk)
+		} else {
+			clientKeys = append(clientKeys, pk)
+		}
+	}
+
+	if len(ownerKeys) == 0 || len(clientKeys) == 0 {
+		return nil, lib.Error{Msg: "Only owners can rotate keys"}
+	}
+
+	return func(data string) (string, error) {
+		clientLevelData, err := encryption.DecryptLayer(data, ownerKeys...)
+		if err != nil {
+			return "", err
+		}
+
+		return encryption.DecryptLayer(clientLevelData, clientKeys...)
+	}, nil
+}
+
+func (k *keyRotation) StartRotation(user *models.User, userSymmetricKey string, grant userGrant.Type) (*models.KeyRotation, error) {
+	if grant != userGrant.Types.GrantOwner && grant != userGrant.Types.GrantClient {
+		return nil, lib.Error{Msg: "Only owner and client keys can be rotated", Reason: grant.Name}
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.keyRotationRepository.GetUnfinished() != nil {
+		return nil, lib.Error{Msg: "Another key rotation is not finished, it needs to be resumed first"}
+	}
+
+	// Check the user can unwrap the logs before creating the new generation
+	_, err := k.getUnwrapCipher(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	generation, err := k.keyManager.CreateKeyGeneration(user, userSymmetricKey, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logs created from now on are encrypted with the new generation, so the rotation stops at the newest log
+	newestLogs, err := k.logRepository.GetPageOfOriginals(models.LogFilter{}, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor *uint
+	if len(newestLogs.Items) != 0 {
+		nextId := newestLogs.Items[0].ID + 1
+		cursor = &nextId
+	}
+
+	rotation := models.KeyRotation{
+		Grant:       grant,
+		Generation:  generation,
+		Status:      models.KeyRotationStatuses.Running,
+		StartedById: user.ID,
+		Cursor:      cursor,
+	}
+	if cursor != nil {
+		rotation.Total, err = k.logRepository.CountOriginals(cursor)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rotation.Status = models.KeyRotationStatuses.Completed
+	}
+
+	err = k.keyRotationRepository.Save(&rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotation.Status == models.KeyRotationStatuses.Running {
+		unwrapKey, err := k.getUnwrapCipher(user, userSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+
+		jobRotation := rotation
+		go k.run(&jobRotation, unwrapKey)
+	}
+
+	return &rotation, nil
+}
+
+func (k *keyRotation) ResumeRotation(id uint, user *models.User, userSymmetricKey string) (*models.KeyRotation, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	rotation, err := k.GetRotation(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotation.Status != models.KeyRotationStatuses.Paused && rotation.Status != models.KeyRotationStatuses.Failed {
+		return nil, lib.Error{Msg: "Only paused or failed rotations can be resumed", Reason: rotation.Status.Status}
+	}
+
+	unwrapKey, err := k.getUnwrapCipher(user, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation.Status = models.KeyRotationStatuses.Running
+	rotation.Error = ""
+	err = k.keyRotationRepository.Save(rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	jobRotation := *rotation
+	go k.run(&jobRotation, unwrapKey)
+
+	return rotation, nil
+}
+
+// Re-encrypts the logs page by page, saving the progress after each page
+func (k *keyRotation) run(rotation *models.KeyRotation, unwrapKey func(string) (string, error)) {
+	for rotation.Cursor != nil {
+		page, err := k.logRepository.GetPageOfOriginals(models.LogFilter{}, rotation.Cursor, rotationPageSize)
+		if err != nil {
+			k.fail(rotation, err)
+			return
+		}
+
+		for _, log := range page.Items {
+			err = k.loggerService.ReencryptLog(&log, unwrapKey)
+			if err != nil {
+				k.fail(rotation, lib.Error{Msg: fmt.Sprintf("Failed to re-encrypt log %d", log.ID), Reason: err.Error()})
+				return
+			}
+		}
+
+		rotation.Processed += int64(len(page.Items))
+		rotation.Cursor = page.NextCursor
+		if rotation.Cursor == nil {
+			rotation.Status = models.KeyRotationStatuses.Completed
+		}
+
+		err = k.keyRotationRepository.Save(rotation)
+		if err != nil {
+			k.fail(rotation, lib.Error{Msg: "Failed to save key rotation progress", Reason: err.Error()})
+			return
+		}
+	}
+}
+
+func (k *keyRotation) fail(rotation *models.KeyRotation, err error) {
+	fmt.Println("Key rotation failed: " + err.Error())
+	rotation.Status = models.KeyRotationStatuses.Failed
+	rotation.Error = err.Error()
+
+	err = k.keyRotationRepository.Save(rotation)
+	if err != nil {
+		fmt.Println("Failed to save key rotation failure: " + err.Error())
+	}
+}
+
+func (k *keyRotation) GetRotations() ([]models.KeyRotation, error) {
+	return k.keyRotationRepository.GetAll()
+}
+
+func (k *keyRotation) GetRotation(id uint) (*models.KeyRotation, error) {
+	rotation := k.keyRotationRepository.GetById(id)
+	if rotation == nil {
+		return nil, lib.Error{Msg: "No key rotation with given id"}
+	}
+
+	return rotation, nil
+}
+
+func (k *keyRotation) PauseInterruptedRotations() error {
+	return k.keyRotationRepository.PauseRunning()
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	clientLib "shareLog/client"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"shareLog/models/userGrant"
+	"testing"
+)
+
+func TestRotationReencryptsClientCopies(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+	client, _ := env.signUpInvited(t, invite, code, "client@test.com")
+	log := env.saveLog(t, "before rotation")
+	env.shareLog(t, log.ID, owner, ownerSymmetricKey, client)
+	client, clientSymmetricKey := env.signIn(t, "client@test.com")
+
+	rotation, err := di.Get[KeyRotation]().StartRotation(owner, ownerSymmetricKey, userGrant.Types.GrantClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotation = env.waitForRotation(t, rotation.ID)
+	if rotation.Status != models.KeyRotationStatuses.Completed {
+		t.Fatalf("rotation status = %s, error = %s", rotation.Status.Status, rotation.Error)
+	}
+
+	// The copy is wrapped with the new client generation, which the client didn't acquire yet
+	loggerService := di.Get[Logger]()
+	_, err = loggerService.GetDecryptedLog(log.ID, client, clientSymmetricKey)
+	if err == nil {
+		t.Fatal("the copy can be read with the old client generation")
+	}
+
+	sealedLog, err := loggerService.GetSealedLog(log.ID, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKeys, err := di.Get[KeyManager]().GetRotatedKeys(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := clientLib.NewKeyring(testPassword, client.ToKeysDto(rotatedKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroKnowledgeLog, err := keyring.DecryptLog(*sealedLog)
+	if err != nil {
+		t.Fatalf("zero-knowledge clients can't read the copy with the rotated keys: %v", err)
+	}
+	if zeroKnowledgeLog.Message != "before rotation" {
+		t.Errorf("zero-knowledge message = %q", zeroKnowledgeLog.Message)
+	}
+
+	client, clientSymmetricKey = env.signIn(t, "client@test.com")
+	decryptedLog, err := loggerService.GetDecryptedLog(log.ID, client, clientSymmetricKey)
+	if err != nil {
+		t.Fatalf("the copy can't be read after acquiring the new generation: %v", err)
+	}
+	if decryptedLog.Message != "before rotation" {
+		t.Errorf("message = %q", decryptedLog.Message)
+	}
+}
+
+func TestResumeRotation(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	log := env.saveLog(t, "before rotation")
+
+	// A rotation interrupted before its first page, as left by a restart
+	generation, err := di.Get[KeyManager]().CreateKeyGeneration(owner, ownerSymmetricKey, userGrant.Types.GrantOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := log.ID + 1
+	pausedRotation := models.KeyRotation{
+		Grant:       userGrant.Types.GrantOwner,
+		Generation:  generation,
+		Status:      models.KeyRotationStatuses.Paused,
+		StartedById: owner.ID,
+		Cursor:      &cursor,
+		Total:       1,
+	}
+	err = di.Get[repository.KeyRotationRepository]().Save(&pausedRotation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotationService := di.Get[KeyRotation]()
+	_, err = rotationService.ResumeRotation(pausedRotation.ID, owner, ownerSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotation := env.waitForRotation(t, pausedRotation.ID)
+	if rotation.Status != models.KeyRotationStatuses.Completed || rotation.Processed != 1 {
+		t.Fatalf("rotation status = %s, processed = %d, error = %s", rotation.Status.Status, rotation.Processed, rotation.Error)
+	}
+
+	_, err = rotationService.ResumeRotation(pausedRotation.ID, owner, ownerSymmetricKey)
+	if err == nil {
+		t.Error("a completed rotation was resumed")
+	}
+
+	// The data key of the log is wrapped with the new owner generation
+	owner = env.reloadUser(t, owner.ID)
+	newestKey := di.Get[KeyManager]().GetKeyForLevel(owner, userGrant.Types.GrantOwner)
+	if newestKey.Generation != generation {
+		t.Fatalf("newest owner generation = %d, expected %d", newestKey.Generation, generation)
+	}
+	newestPk, err := di.Get[Crypto]().UnlockPrivateKey(newestKey, []byte(ownerSymmetricKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey := di.Get[repository.LogDataKeyRepository]().GetByLogId(log.ID)
+	_, err = encryption.DecryptLayer(dataKey.DoubleEncryptedKey, newestPk)
+	if err != nil {
+		t.Errorf("the data key isn't wrapped with the new generation: %v", err)
+	}
+
+	decryptedLog, err := di.Get[Logger]().GetDecryptedLog(log.ID, owner, ownerSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptedLog.Message != "before rotation" {
+		t.Errorf("message = %q", decryptedLog.Message)
+	}
+}
+
+func TestRotationReachesPendingInvites(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+
+	generation, err := di.Get[KeyManager]().CreateKeyGeneration(owner, ownerSymmetricKey, userGrant.Types.GrantClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := env.signUpInvited(t, invite, code, "client@test.com")
+	newestKey := di.Get[KeyManager]().GetKeyForLevel(client, userGrant.Types.GrantClient)
+	if newestKey == nil || newestKey.Generation != generation {
+		t.Fatalf("the invited client doesn't hold the generation %d created while the invite was pending", generation)
+	}
+
+	personalKeys := 0
+	for _, key := range client.EncryptionKeys {
+		if key.UserGrant == userGrant.Types.GrantPersonal {
+			personalKeys++
+		}
+	}
+	if personalKeys != 1 {
+		t.Errorf("the client holds %d personal keys, the transport key of the invite isn't theirs", personalKeys)
+	}
+}
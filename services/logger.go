@@ -1,27 +1,62 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"shareLog/config"
 	"shareLog/data/repository"
 	"shareLog/di"
 	"shareLog/lib"
+	"shareLog/lib/stackTrace"
 	"shareLog/models"
 	"shareLog/models/dto"
 	"shareLog/models/encryption"
 	"shareLog/models/userGrant"
+	"time"
 )
 
 type logger struct {
-	cryptoService Crypto
-	keyManager    KeyManager
-	keyRepository repository.KeyRepository
-	logRepository repository.LogRepository
+	cryptoService        Crypto
+	keyManager           KeyManager
+	keyRepository        repository.KeyRepository
+	logRepository        repository.LogRepository
+	issueService         Issue
+	logDataKeyRepository repository.LogDataKeyRepository
 }
 
+// ErrNoLog There is no log with the given id, or the user can't see it
+var ErrNoLog = lib.Error{Msg: "No log with given id"}
+
 type Logger interface {
-	SaveLog(dto dto.Log) (*models.Log, error)
+	// SaveLog Returns the saved log and whether it is the original of a replayed upload.
+	// The api key is nil if the log isn't uploaded by an app
+	SaveLog(logDto dto.Log, apiKey *models.ApiKey) (*models.Log, bool, error)
+	// SaveLogs Encrypts each log independently and saves the valid ones in a single transaction.
+	// Returns the saved log or the error for each log, in the order they were passed in.
+	// Replayed logs return the original log
+	SaveLogs(logDtos []dto.Log, apiKey *models.ApiKey) []lib.Pair[*models.Log, error]
+	// HaveAccessToLog Returns ErrNoLog if the log doesn't exist
 	HaveAccessToLog(id uint, user *models.User) (bool, error)
 	GetDecryptedLog(id uint, user *models.User, userSymmetricKey string) (*models.DecryptedLog, error)
+	// GetSealedLog Returns the log as stored, along with its wrapped data key, for clients decrypting it themselves
+	GetSealedLog(id uint, user *models.User) (*dto.SealedLog, error)
+	// CreateWithClientAccess Saves a copy of the log encrypted with the shared key, together with the shared key tied to the log
 	CreateWithClientAccess(logId uint, user *models.User, userSymmetricKey string, sharedKey *encryption.Key) error
+	// GetLogs Returns a page of the logs the user can see. Clients only see the logs they acquired a key for
+	GetLogs(user *models.User, filter models.LogFilter, cursor *uint, limit int) (*repository.Page[models.Log], error)
+	/*
+		ReencryptLog Wraps the data key of the log with the current owner and client keys.
+		Logs without a data key are re-encrypted under a new one. The given cipher unwraps the current data key or fields.
+		The copy made for the client given access to the log is re-encrypted under a new data key too
+	*/
+	ReencryptLog(log *models.Log, unwrapKey func(data string) (string, error)) error
+	/*
+		DeleteLog Destroys the data keys of the log and of its copies, then deletes everything tied to the log.
+		Backups of the database keep the data keys next to the logs, see models.LogDataKey.
+		Returns ErrNoLog if the log doesn't exist
+	*/
+	DeleteLog(id uint) error
 }
 
 type LoggerProvider struct {
@@ -31,47 +66,287 @@ func (l LoggerProvider) Provide() any {
 	cryptoService := di.Get[Crypto]()
 	logRepository := di.Get[repository.LogRepository]()
 	var instance Logger = &logger{
-		cryptoService: cryptoService,
-		logRepository: logRepository,
-		keyRepository: di.Get[repository.KeyRepository](),
-		keyManager:    di.Get[KeyManager](),
+		cryptoService:        cryptoService,
+		logRepository:        logRepository,
+		keyRepository:        di.Get[repository.KeyRepository](),
+		keyManager:           di.Get[KeyManager](),
+		issueService:         di.Get[Issue](),
+		logDataKeyRepository: di.Get[repository.LogDataKeyRepository](),
 	}
 	return instance
 }
 
-func (l *logger) SaveLog(dto dto.Log) (*models.Log, error) {
-	encryptedLog, err := l.cryptoService.EncryptClientLevel(dto.StackTrace)
+// fieldCipher transforms a single sensitive field of a log
+type fieldCipher func(data string) (string, error)
+
+// Empty fields are stored as empty strings so optional fields don't pay for an encryption
+func (l *logger) applyCipher(data string, cipher fieldCipher) (string, error) {
+	if data == "" {
+		return "", nil
+	}
+
+	return cipher(data)
+}
+
+/*
+encryptLog Builds the database model of a log. The sensitive fields are encrypted with a new data key
+and the data key is wrapped with the given cipher
+*/
+func (l *logger) encryptLog(decryptedLog *models.DecryptedLog, wrapKey fieldCipher) (*models.Log, error) {
+	serializedTags := ""
+	if len(decryptedLog.Tags) != 0 {
+		tagBytes, err := json.Marshal(decryptedLog.Tags)
+		if err != nil {
+			return nil, err
+		}
+		serializedTags = string(tagBytes)
+	}
+
+	dataKey, err := lib.GenerateRandomKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedDataKey, err := wrapKey(string(dataKey))
+	if err != nil {
+		return nil, err
+	}
+
+	cipher := func(data string) (string, error) {
+		return lib.SealEnvelope([]byte(data), dataKey, lib.DefaultKeyVersion)
+	}
+
+	fields := []string{decryptedLog.StackTrace, decryptedLog.Message, decryptedLog.DeviceModel, serializedTags}
+	encryptedFields := make([]string, len(fields))
+	for i, field := range fields {
+		encryptedField, err := l.applyCipher(field, cipher)
+		if err != nil {
+			return nil, err
+		}
+		encryptedFields[i] = encryptedField
+	}
+
+	return &models.Log{
+		LogMetadata:                decryptedLog.LogMetadata,
+		DoubleEncryptedStackTrace:  encryptedFields[0],
+		DoubleEncryptedMessage:     encryptedFields[1],
+		DoubleEncryptedDeviceModel: encryptedFields[2],
+		DoubleEncryptedTags:        encryptedFields[3],
+		DataKey:                    &models.LogDataKey{DoubleEncryptedKey: wrappedDataKey},
+	}, nil
+}
+
+// decryptLog The inverse of encryptLog. The given cipher unwraps the data key
+func (l *logger) decryptLog(log *models.Log, unwrapKey fieldCipher) (*models.DecryptedLog, error) {
+	return log.Decrypt(l.logDataKeyRepository.GetByLogId(log.ID), unwrapKey)
+}
+
+func (l *logger) encryptClientAndOwnerLevel(data string) (string, error) {
+	encryptedData, err := l.cryptoService.EncryptClientLevel(data)
+	if err != nil {
+		return "", err
+	}
+
+	return l.cryptoService.EncryptOwnerLevel(encryptedData)
+}
+
+// Returns the cipher wrapping the data key of the copy made for a client, with the shared key of the log
+func (l *logger) clientCopyCipher(sharedKey *encryption.Key) fieldCipher {
+	return func(data string) (string, error) {
+		encryptedData, err := l.cryptoService.EncryptClientLevel(data)
+		if err != nil {
+			return "", err
+		}
+
+		return l.cryptoService.EncryptMessage(encryptedData, sharedKey)
+	}
+}
+
+func (l *logger) prepareLog(logDto dto.Log, apiKey *models.ApiKey) (*models.Log, error) {
+	decryptedLog, err := models.NewDecryptedLog(logDto)
 	if err != nil {
 		return nil, err
 	}
-	doubleEncryptedLog, err := l.cryptoService.EncryptOwnerLevel(encryptedLog)
 
+	// The fingerprint must be computed before the stack trace is encrypted
+	fingerprint, err := l.issueService.Fingerprint(decryptedLog.StackTrace)
 	if err != nil {
 		return nil, err
 	}
 
-	model := models.NewLog(doubleEncryptedLog)
-	err = l.logRepository.Save(&model)
+	model, err := l.encryptLog(decryptedLog, l.encryptClientAndOwnerLevel)
 	if err != nil {
 		return nil, err
 	}
 
-	return &model, nil
+	model.IssueFingerprint = fingerprint
+
+	if apiKey != nil {
+		model.ApiKeyId = &apiKey.ID
+		if logDto.IdempotencyKey != "" {
+			model.IdempotencyKey = &logDto.IdempotencyKey
+		}
+	}
+
+	return model, nil
+}
+
+// findReplayedLog Returns the log previously uploaded with the same idempotency key, if it is still remembered
+func (l *logger) findReplayedLog(logDto dto.Log, apiKey *models.ApiKey) (*models.Log, error) {
+	if apiKey == nil || logDto.IdempotencyKey == "" {
+		return nil, nil
+	}
+
+	log := l.logRepository.GetByIdempotencyKey(apiKey.ID, logDto.IdempotencyKey)
+	if log == nil {
+		return nil, nil
+	}
+
+	windowStart := time.Now().Add(-config.GetIngestionConfig().IdempotencyWindow)
+	if log.CreatedAt.Before(windowStart) {
+		return nil, l.logRepository.ClearIdempotencyKey(log)
+	}
+
+	return log, nil
+}
+
+func (l *logger) SaveLog(logDto dto.Log, apiKey *models.ApiKey) (*models.Log, bool, error) {
+	replayedLog, err := l.findReplayedLog(logDto, apiKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if replayedLog != nil {
+		return replayedLog, true, nil
+	}
+
+	model, err := l.prepareLog(logDto, apiKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = l.logRepository.SaveAllWithIssues([]*models.Log{model})
+	if err != nil {
+		// A concurrent upload with the same idempotency key might have won the race
+		replayedLog, _ := l.findReplayedLog(logDto, apiKey)
+		if replayedLog != nil {
+			return replayedLog, true, nil
+		}
+
+		return nil, false, err
+	}
+
+	return model, false, nil
+}
+
+func (l *logger) SaveLogs(logDtos []dto.Log, apiKey *models.ApiKey) []lib.Pair[*models.Log, error] {
+	results := make([]lib.Pair[*models.Log, error], len(logDtos))
+	modelsToSave := make([]*models.Log, 0, len(logDtos))
+	// Logs of the batch that share an idempotency key with an earlier log of the same batch
+	duplicateOf := make(map[int]int)
+	firstIndexByKey := make(map[string]int)
+
+	for i, logDto := range logDtos {
+		replayedLog, err := l.findReplayedLog(logDto, apiKey)
+		if err != nil || replayedLog != nil {
+			results[i] = lib.Pair[*models.Log, error]{First: replayedLog, Second: err}
+			continue
+		}
+
+		if apiKey != nil && logDto.IdempotencyKey != "" {
+			if firstIndex, exists := firstIndexByKey[logDto.IdempotencyKey]; exists {
+				duplicateOf[i] = firstIndex
+				continue
+			}
+			firstIndexByKey[logDto.IdempotencyKey] = i
+		}
+
+		model, err := l.prepareLog(logDto, apiKey)
+		results[i] = lib.Pair[*models.Log, error]{First: model, Second: err}
+		if err == nil {
+			modelsToSave = append(modelsToSave, model)
+		}
+	}
+
+	if len(modelsToSave) != 0 {
+		err := l.logRepository.SaveAllWithIssues(modelsToSave)
+		if err != nil {
+			// Concurrent uploads with the same idempotency keys might have won the race.
+			// Their logs are replayed and the rest of the batch is saved again
+			modelsToRetry := l.replayConcurrentUploads(logDtos, apiKey, results, modelsToSave)
+			if len(modelsToRetry) != 0 && len(modelsToRetry) != len(modelsToSave) {
+				err = l.logRepository.SaveAllWithIssues(modelsToRetry)
+			}
+			modelsToSave = modelsToRetry
+		}
+
+		if err != nil {
+			for i := range results {
+				if lib.Contains(modelsToSave, results[i].First) {
+					results[i] = lib.Pair[*models.Log, error]{First: nil, Second: err}
+				}
+			}
+		}
+	}
+
+	for i, firstIndex := range duplicateOf {
+		results[i] = results[firstIndex]
+	}
+
+	return results
+}
+
+/*
+replayConcurrentUploads Replaces the results of the unsaved logs whose idempotency key was taken by a concurrent upload
+with the log of that upload. Returns the logs that still have to be saved, reset so they can be inserted again
+*/
+func (l *logger) replayConcurrentUploads(
+	logDtos []dto.Log,
+	apiKey *models.ApiKey,
+	results []lib.Pair[*models.Log, error],
+	unsavedModels []*models.Log,
+) []*models.Log {
+	modelsToRetry := make([]*models.Log, 0, len(unsavedModels))
+	for i, logDto := range logDtos {
+		if !lib.Contains(unsavedModels, results[i].First) {
+			continue
+		}
+
+		replayedLog, _ := l.findReplayedLog(logDto, apiKey)
+		if replayedLog != nil {
+			results[i] = lib.Pair[*models.Log, error]{First: replayedLog, Second: nil}
+			continue
+		}
+
+		// The ids given by the rolled back transaction don't exist
+		model := results[i].First
+		model.Model = gorm.Model{}
+		if model.DataKey != nil {
+			model.DataKey.Model = gorm.Model{}
+			model.DataKey.LogId = 0
+		}
+		modelsToRetry = append(modelsToRetry, model)
+	}
+
+	return modelsToRetry
 }
 
 func (l *logger) HaveAccessToLog(id uint, user *models.User) (bool, error) {
 	log := l.logRepository.GetById(id)
 
 	if log == nil {
-		return false, lib.Error{Msg: "No log with given id"}
+		return false, ErrNoLog
 	}
 
 	if user.Grant == userGrant.Types.GrantOwner {
 		return true, nil
 	} else if user.Grant == userGrant.Types.GrantClient {
-		key, err := l.keyRepository.GetAcquiredSharedKeyForLogId(user.ID, id)
-		hasAccess := key != nil && err == nil
-		return hasAccess, err
+		_, err := l.keyRepository.GetAcquiredSharedKeyForLogId(user.ID, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+
+		return err == nil, err
 	}
 
 	return false, nil
@@ -81,7 +356,7 @@ func (l *logger) GetDecryptedLog(id uint, user *models.User, userSymmetricKey st
 	log := l.getLogForUser(id, user.Grant)
 
 	if log == nil {
-		return nil, lib.Error{Msg: "No log with given id"}
+		return nil, ErrNoLog
 	}
 
 	keys := l.keyManager.GetDecryptionKeysForLog(user, id)
@@ -89,18 +364,32 @@ func (l *logger) GetDecryptedLog(id uint, user *models.User, userSymmetricKey st
 	ownerKey := keys.First
 	clientKey := keys.Second
 
-	stackTrace, err := l.cryptoService.DecryptMessage(&DecryptOptions{
-		Data:            log.DoubleEncryptedStackTrace,
-		Usr:             user,
-		UsrSymmetricKey: userSymmetricKey,
-		ClientLevelKey:  clientKey,
-		OwnerLevelKey:   ownerKey,
+	decryptedLog, err := l.decryptLog(log, func(data string) (string, error) {
+		return l.cryptoService.DecryptMessage(&DecryptOptions{
+			Data:            data,
+			Usr:             user,
+			UsrSymmetricKey: userSymmetricKey,
+			ClientLevelKey:  clientKey,
+			OwnerLevelKey:   ownerKey,
+		})
 	})
-
 	if err != nil {
 		return nil, err
 	}
-	return models.NewDecryptedLog(stackTrace), nil
+
+	decryptedLog.Frames = stackTrace.Parse(decryptedLog.StackTrace)
+	return decryptedLog, nil
+}
+
+func (l *logger) GetSealedLog(id uint, user *models.User) (*dto.SealedLog, error) {
+	log := l.getLogForUser(id, user.Grant)
+
+	if log == nil {
+		return nil, ErrNoLog
+	}
+
+	sealedLog := log.ToSealedDto(id, l.logDataKeyRepository.GetByLogId(log.ID))
+	return &sealedLog, nil
 }
 
 func (l *logger) getLogForUser(logId uint, grant userGrant.Type) *models.Log {
@@ -119,22 +408,111 @@ func (l *logger) CreateWithClientAccess(logId uint, user *models.User, userSymme
 		return err
 	}
 
-	encryptedLog, err := l.cryptoService.EncryptClientLevel(decryptedLog.StackTrace)
+	model, err := l.encryptLog(decryptedLog, l.clientCopyCipher(sharedKey))
 	if err != nil {
 		return err
 	}
-	doubleEncryptedLog, err := l.cryptoService.EncryptMessage(encryptedLog, sharedKey)
 
+	model.RefLogId = &logId
+	sharedKey.LogId = &logId
+	return l.logRepository.SaveClientCopy(model, sharedKey)
+}
+
+func (l *logger) GetLogs(user *models.User, filter models.LogFilter, cursor *uint, limit int) (*repository.Page[models.Log], error) {
+	if user.Grant == userGrant.Types.GrantOwner {
+		return l.logRepository.GetPageOfOriginals(filter, cursor, limit)
+	} else if user.Grant == userGrant.Types.GrantClient {
+		return l.logRepository.GetPageOfAcquired(user.ID, filter, cursor, limit)
+	}
+
+	return nil, lib.Error{Msg: "Unknown user grant for listing logs"}
+}
+
+func (l *logger) DeleteLog(id uint) error {
+	log := l.logRepository.GetById(id)
+	if log == nil || log.RefLogId != nil {
+		return ErrNoLog
+	}
+
+	return l.logRepository.DeletePermanentlyWithRelations([]uint{id})
+}
+
+func (l *logger) ReencryptLog(log *models.Log, unwrapKey func(data string) (string, error)) error {
+	err := l.reencryptOriginal(log, unwrapKey)
 	if err != nil {
 		return err
 	}
 
-	model := models.NewLog(doubleEncryptedLog)
-	model.RefLogId = &logId
-	err = l.logRepository.Save(&model)
+	return l.reencryptClientCopy(log, unwrapKey)
+}
+
+func (l *logger) reencryptOriginal(log *models.Log, unwrapKey fieldCipher) error {
+	dataKey := l.logDataKeyRepository.GetByLogId(log.ID)
+	if dataKey != nil {
+		unwrappedKey, err := unwrapKey(dataKey.DoubleEncryptedKey)
+		if err != nil {
+			return err
+		}
+
+		dataKey.DoubleEncryptedKey, err = l.encryptClientAndOwnerLevel(unwrappedKey)
+		if err != nil {
+			return err
+		}
+
+		return l.logDataKeyRepository.Save(dataKey)
+	}
+
+	decryptedLog, err := l.decryptLog(log, unwrapKey)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	model, err := l.encryptLog(decryptedLog, l.encryptClientAndOwnerLevel)
+	if err != nil {
+		return err
+	}
+
+	log.DoubleEncryptedStackTrace = model.DoubleEncryptedStackTrace
+	log.DoubleEncryptedMessage = model.DoubleEncryptedMessage
+	log.DoubleEncryptedDeviceModel = model.DoubleEncryptedDeviceModel
+	log.DoubleEncryptedTags = model.DoubleEncryptedTags
+	log.DataKey = model.DataKey
+	return l.logRepository.Save(log)
+}
+
+/*
+The data key of the copy is wrapped with the shared key of the log, which only the client can unwrap,
+so the copy is encrypted again from the original under a new data key
+*/
+func (l *logger) reencryptClientCopy(log *models.Log, unwrapKey fieldCipher) error {
+	clientCopy, err := l.logRepository.GetClientCopy(log.ID)
+	if err != nil || clientCopy == nil {
+		return err
+	}
+
+	sharedKey, err := l.keyRepository.GetSharedKeyForLog(log.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The migration deleted the legacy shared keys already acquired, only the public key is needed
+		sharedKey, err = l.keyRepository.GetAcquiredKeyForLog(log.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	decryptedLog, err := l.decryptLog(log, unwrapKey)
+	if err != nil {
+		return err
+	}
+
+	model, err := l.encryptLog(decryptedLog, l.clientCopyCipher(sharedKey))
+	if err != nil {
+		return err
+	}
+
+	clientCopy.DoubleEncryptedStackTrace = model.DoubleEncryptedStackTrace
+	clientCopy.DoubleEncryptedMessage = model.DoubleEncryptedMessage
+	clientCopy.DoubleEncryptedDeviceModel = model.DoubleEncryptedDeviceModel
+	clientCopy.DoubleEncryptedTags = model.DoubleEncryptedTags
+	clientCopy.DataKey = model.DataKey
+	return l.logRepository.SaveWithNewDataKey(clientCopy)
 }
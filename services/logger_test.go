@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"testing"
+)
+
+func TestDeleteLogShredsDataKeys(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey, _, _, log := setupSharedLog(t, env)
+	// The client acquires the shared key, which then also belongs to the log
+	env.signIn(t, "client@test.com")
+
+	clientCopy, err := di.Get[repository.LogRepository]().GetClientCopy(log.ID)
+	if err != nil || clientCopy == nil {
+		t.Fatalf("the log has no client copy: %v", err)
+	}
+
+	dataKeyRepository := di.Get[repository.LogDataKeyRepository]()
+	if dataKeyRepository.GetByLogId(log.ID) == nil || dataKeyRepository.GetByLogId(clientCopy.ID) == nil {
+		t.Fatal("the log isn't sealed with data keys")
+	}
+
+	loggerService := di.Get[Logger]()
+	err = loggerService.DeleteLog(clientCopy.ID)
+	if !errors.Is(err, ErrNoLog) {
+		t.Errorf("deleting the client copy on its own returned %v", err)
+	}
+
+	err = loggerService.DeleteLog(log.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dataKeyRepository.GetByLogId(log.ID) != nil || dataKeyRepository.GetByLogId(clientCopy.ID) != nil {
+		t.Error("a data key of the log outlived it")
+	}
+
+	var keys int64
+	err = env.db.Unscoped().Model(&encryption.Key{}).Where("log_id = ?", log.ID).Count(&keys).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != 0 {
+		t.Errorf("%d keys of the log outlived it", keys)
+	}
+
+	var logs int64
+	err = env.db.Unscoped().Model(&models.Log{}).Where("id IN ?", []uint{log.ID, clientCopy.ID}).Count(&logs).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs != 0 {
+		t.Errorf("%d rows of the log outlived it", logs)
+	}
+
+	_, err = loggerService.GetDecryptedLog(log.ID, owner, ownerSymmetricKey)
+	if !errors.Is(err, ErrNoLog) {
+		t.Errorf("reading the deleted log returned %v", err)
+	}
+
+	err = loggerService.DeleteLog(log.ID)
+	if !errors.Is(err, ErrNoLog) {
+		t.Errorf("deleting the log twice returned %v", err)
+	}
+}
@@ -1,78 +1,88 @@
 package services
 
 import (
-	"shareLog/config"
+	eciesgo "github.com/ecies/go/v2"
 	"shareLog/data/repository"
 	"shareLog/di"
 	"shareLog/lib"
 	"shareLog/models"
-	"shareLog/models/userGrant"
 	"slices"
 )
 
 type permissionRequest struct {
 	logPermissionRepository repository.LogPermissionRepository
-	cryptoService           Crypto
 	keyRepository           repository.KeyRepository
 	loggerService           Logger
+	keyManager              KeyManager
 }
 
 type PermissionRequest interface {
-	RequestPermission(logId uint) error
+	RequestPermission(user *models.User, logId uint) error
+	// ApprovePermission Shares a new key for the log with the requesting user. Only they can acquire it
 	ApprovePermission(user *models.User, userSymmetricKey string, request models.PermissionRequest) error
 	DenyPermission(request models.PermissionRequest) error
-	ResetPermissionRequest(request models.PermissionRequest) error
+	/*
+		ResetPermissionRequest Makes the request pending again. Only the user who made the request can reset it,
+		requests made before the requesting user was recorded are taken over by the given user
+	*/
+	ResetPermissionRequest(user *models.User, request models.PermissionRequest) error
 	GetPermissionRequests(user *models.User) ([]lib.Pair[models.PermissionRequest, bool], error)
 }
 
+// ErrNotRequester The user resetting a permission request isn't the one who made it
+var ErrNotRequester = lib.Error{Msg: "The request was made by another user"}
+
 type PermissionRequestProvider struct {
 }
 
 func (l PermissionRequestProvider) Provide() any {
 	logPermissionRepository := di.Get[repository.LogPermissionRepository]()
-	cryptoService := di.Get[Crypto]()
 	keyRepository := di.Get[repository.KeyRepository]()
 	var instance PermissionRequest = &permissionRequest{
 		logPermissionRepository: logPermissionRepository,
-		cryptoService:           cryptoService,
 		keyRepository:           keyRepository,
 		loggerService:           di.Get[Logger](),
+		keyManager:              di.Get[KeyManager](),
 	}
 	return instance
 }
 
-func (p *permissionRequest) RequestPermission(logId uint) error {
+func (p *permissionRequest) RequestPermission(user *models.User, logId uint) error {
 	request := models.PermissionRequest{
-		LogID:  logId,
-		Status: models.PermissionRequestStatuses.Pending,
+		LogID:         logId,
+		Status:        models.PermissionRequestStatuses.Pending,
+		RequestedById: &user.ID,
 	}
 
 	return p.logPermissionRepository.Save(&request)
 }
 
 func (p *permissionRequest) ApprovePermission(user *models.User, userSymmetricKey string, request models.PermissionRequest) error {
-	salt := p.cryptoService.GenerateSalt()
-	key, err := p.cryptoService.CreateNewEncryptionKey(userGrant.Types.GrantShared, config.GetSecrets().LogSharingSecret, salt)
+	if request.RequestedById == nil {
+		return lib.Error{Msg: "The request has no requesting user, it needs to be made again"}
+	}
+
+	pk, err := eciesgo.GenerateKey()
 	if err != nil {
 		return err
 	}
 
-	err = p.loggerService.CreateWithClientAccess(request.LogID, user, userSymmetricKey, key)
+	key, err := p.keyManager.ShareKey(pk, *request.RequestedById)
 	if err != nil {
 		return err
 	}
 
-	key.LogId = &request.LogID
-	key.Salt = salt
-	err = p.keyRepository.Save(key)
+	// Saves the key along the copy, a copy without its key couldn't be decrypted by anyone
+	err = p.loggerService.CreateWithClientAccess(request.LogID, user, userSymmetricKey, key)
 	if err != nil {
 		return err
 	}
 
 	updatedRequest := models.PermissionRequest{
-		Model:  request.Model,
-		LogID:  request.LogID,
-		Status: models.PermissionRequestStatuses.Approved,
+		Model:         request.Model,
+		LogID:         request.LogID,
+		Status:        models.PermissionRequestStatuses.Approved,
+		RequestedById: request.RequestedById,
 	}
 
 	return p.logPermissionRepository.Save(&updatedRequest)
@@ -80,19 +90,25 @@ func (p *permissionRequest) ApprovePermission(user *models.User, userSymmetricKe
 
 func (p *permissionRequest) DenyPermission(request models.PermissionRequest) error {
 	updatedRequest := models.PermissionRequest{
-		Model:  request.Model,
-		LogID:  request.LogID,
-		Status: models.PermissionRequestStatuses.Denied,
+		Model:         request.Model,
+		LogID:         request.LogID,
+		Status:        models.PermissionRequestStatuses.Denied,
+		RequestedById: request.RequestedById,
 	}
 
 	return p.logPermissionRepository.Save(&updatedRequest)
 }
 
-func (p *permissionRequest) ResetPermissionRequest(request models.PermissionRequest) error {
+func (p *permissionRequest) ResetPermissionRequest(user *models.User, request models.PermissionRequest) error {
+	if request.RequestedById != nil && *request.RequestedById != user.ID {
+		return ErrNotRequester
+	}
+
 	updatedRequest := models.PermissionRequest{
-		Model:  request.Model,
-		LogID:  request.LogID,
-		Status: models.PermissionRequestStatuses.Pending,
+		Model:         request.Model,
+		LogID:         request.LogID,
+		Status:        models.PermissionRequestStatuses.Pending,
+		RequestedById: &user.ID,
 	}
 
 	return p.logPermissionRepository.Save(&updatedRequest)
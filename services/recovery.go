@@ -0,0 +1,278 @@
+package services
+
+import (
+	"crypto/rand"
+	"math/big"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/encryption"
+	"strings"
+)
+
+const recoveryCodeCount = 8
+
+// The first group is the lookup id of the code, the others are its secret
+const recoveryCodeGroups = 5
+const recoveryCodeGroupSize = 5
+
+// Unambiguous characters only, so codes can be copied by hand
+const recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+type recovery struct {
+	userRepository         repository.UserRepository
+	recoveryCodeRepository repository.RecoveryCodeRepository
+	cryptoService          Crypto
+	keyManager             KeyManager
+}
+
+type Recovery interface {
+	// GenerateCodes Replaces the recovery codes of the user. Returns the new codes, they can't be retrieved later
+	GenerateCodes(user *models.User, userSymmetricKey string) ([]string, error)
+	// CreateCodes Returns new codes and their models for a user who isn't saved yet. The models aren't saved
+	CreateCodes(userSymmetricKey string) ([]string, []models.RecoveryCode, error)
+	// RewrapCodes Returns the unused codes of the user, re-wrapped for the new user symmetric key. They aren't saved
+	RewrapCodes(userId uint, oldSymmetricKey string, newSymmetricKey string) ([]models.RecoveryCode, error)
+	/*
+		Recover Uses a recovery code to set a new password.
+		The keys and the other codes are re-wrapped, the code is invalidated and existing tokens are revoked
+	*/
+	Recover(email string, code string, newPassword string) (*models.User, error)
+}
+
+type RecoveryProvider struct {
+}
+
+func (r RecoveryProvider) Provide() any {
+	var instance Recovery = &recovery{
+		userRepository:         di.Get[repository.UserRepository](),
+		recoveryCodeRepository: di.Get[repository.RecoveryCodeRepository](),
+		cryptoService:          di.Get[Crypto](),
+		keyManager:             di.Get[KeyManager](),
+	}
+	return instance
+}
+
+// Returns a code formatted as groups of characters separated by dashes
+func generateRecoveryCode() (string, error) {
+	maxRndInt := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	groups := make([]string, recoveryCodeGroups)
+	for i := range groups {
+		group := make([]byte, recoveryCodeGroupSize)
+		for j := range group {
+			randomInt, err := rand.Int(rand.Reader, maxRndInt)
+			if err != nil {
+				return "", err
+			}
+			group[j] = recoveryCodeAlphabet[randomInt.Int64()]
+		}
+		groups[i] = string(group)
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+// Codes are accepted regardless of case and separators
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// Returns the lookup id of the code, empty if the code is too short to have one
+func getRecoveryCodeLookupId(code string) string {
+	normalizedCode := normalizeRecoveryCode(code)
+	if len(normalizedCode) <= recoveryCodeGroupSize {
+		return ""
+	}
+
+	return normalizedCode[:recoveryCodeGroupSize]
+}
+
+func (r *recovery) createCode(userId uint, code string, userSymmetricKey string) (*models.RecoveryCode, error) {
+	codeSalt := r.cryptoService.GenerateSalt()
+	codeKdf := r.cryptoService.CurrentKdfParams()
+	codeKey := r.cryptoService.DeriveSecurePassphrase(normalizeRecoveryCode(code), codeSalt, codeKdf)
+
+	wrappedSymmetricKey, err := lib.SealEnvelope([]byte(userSymmetricKey), codeKey, lib.DefaultKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedCodeKey, err := lib.SealEnvelope(codeKey, []byte(userSymmetricKey), lib.DefaultKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCode{
+		UserId:              userId,
+		LookupId:            getRecoveryCodeLookupId(code),
+		CodeSalt:            codeSalt,
+		CodeKdf:             codeKdf,
+		WrappedSymmetricKey: wrappedSymmetricKey,
+		WrappedCodeKey:      wrappedCodeKey,
+	}, nil
+}
+
+func (r *recovery) createCodes(userId uint, userSymmetricKey string) ([]string, []models.RecoveryCode, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	codeModels := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codeModel, err := r.createCode(userId, code, userSymmetricKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		codeModels = append(codeModels, *codeModel)
+	}
+
+	return codes, codeModels, nil
+}
+
+func (r *recovery) GenerateCodes(user *models.User, userSymmetricKey string) ([]string, error) {
+	codes, codeModels, err := r.createCodes(user.ID, userSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.recoveryCodeRepository.ReplaceForUser(user.ID, codeModels)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (r *recovery) CreateCodes(userSymmetricKey string) ([]string, []models.RecoveryCode, error) {
+	return r.createCodes(0, userSymmetricKey)
+}
+
+func (r *recovery) rewrapCode(code models.RecoveryCode, oldSymmetricKey string, newSymmetricKey string) (*models.RecoveryCode, error) {
+	codeKey, err := lib.OpenEnvelope(code.WrappedCodeKey, []byte(oldSymmetricKey))
+	if err != nil {
+		return nil, err
+	}
+
+	code.WrappedSymmetricKey, err = lib.SealEnvelope([]byte(newSymmetricKey), codeKey, lib.DefaultKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	code.WrappedCodeKey, err = lib.SealEnvelope(codeKey, []byte(newSymmetricKey), lib.DefaultKeyVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+func (r *recovery) RewrapCodes(userId uint, oldSymmetricKey string, newSymmetricKey string) ([]models.RecoveryCode, error) {
+	codes, err := r.recoveryCodeRepository.GetUnusedByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrappedCodes := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		rewrappedCode, err := r.rewrapCode(code, oldSymmetricKey, newSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+
+		rewrappedCodes = append(rewrappedCodes, *rewrappedCode)
+	}
+
+	return rewrappedCodes, nil
+}
+
+// Returns the unused code of the user matching the given one and the user symmetric key it wraps
+func (r *recovery) unlockCode(userId uint, code string) (*models.RecoveryCode, string, error) {
+	lookupId := getRecoveryCodeLookupId(code)
+	if lookupId == "" {
+		return nil, "", lib.Error{Msg: "Invalid recovery code"}
+	}
+
+	codeModel, err := r.recoveryCodeRepository.GetUnusedByLookupId(userId, lookupId)
+	if err != nil {
+		return nil, "", lib.Error{Msg: "Invalid recovery code"}
+	}
+
+	codeKey := r.cryptoService.DeriveSecurePassphrase(normalizeRecoveryCode(code), codeModel.CodeSalt, codeModel.CodeKdf)
+	userSymmetricKey, err := lib.OpenEnvelope(codeModel.WrappedSymmetricKey, codeKey)
+	if err != nil {
+		return nil, "", lib.Error{Msg: "Invalid recovery code"}
+	}
+
+	return codeModel, string(userSymmetricKey), nil
+}
+
+func (r *recovery) Recover(email string, code string, newPassword string) (*models.User, error) {
+	user, err := r.userRepository.GetByEmail(email)
+	if err != nil {
+		return nil, lib.Error{Msg: "Invalid recovery code"}
+	}
+
+	usedCode, oldSymmetricKey, err := r.unlockCode(user.ID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := r.recoveryCodeRepository.GetUnusedByUserId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	userWithKeys := r.userRepository.GetByIdWithPrivateKeys(user.ID)
+	keySalt := r.cryptoService.GenerateSalt()
+	kdfParams := r.cryptoService.CurrentKdfParams()
+	newSymmetricKey := r.cryptoService.DeriveUserSymmetricKey(newPassword, keySalt, kdfParams)
+
+	keys, err := r.keyManager.RewrapKeys(userWithKeys.EncryptionKeys, oldSymmetricKey, newSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrappedCodes := make([]models.RecoveryCode, 0, len(codes))
+	for _, codeModel := range codes {
+		if codeModel.ID == usedCode.ID {
+			continue
+		}
+
+		rewrappedCode, err := r.rewrapCode(codeModel, oldSymmetricKey, newSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+		rewrappedCodes = append(rewrappedCodes, *rewrappedCode)
+	}
+
+	passwordSalt := r.cryptoService.GenerateSalt()
+	hashedPassword, err := lib.HashPassword(newPassword, passwordSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	userWithKeys.PasswordHash = hashedPassword
+	userWithKeys.PasswordSalt = passwordSalt
+	userWithKeys.EncryptionKeySalt = keySalt
+	userWithKeys.EncryptionKeyKdf = kdfParams
+	userWithKeys.KeyVerifierHash = encryption.KeyVerifierHashFor([]byte(newSymmetricKey))
+	userWithKeys.EncryptionKeys = keys
+	userWithKeys.RecoveryCodes = rewrappedCodes
+	userWithKeys.TokenVersion++
+
+	isClaimed, err := r.userRepository.SaveRecoveredUser(userWithKeys, usedCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isClaimed {
+		return nil, lib.Error{Msg: "Invalid recovery code"}
+	}
+
+	return userWithKeys, nil
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"shareLog/config"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"slices"
+	"time"
+)
+
+const purgePageSize = 500
+
+type retention struct {
+	retentionPolicyRepository repository.RetentionPolicyRepository
+	logRepository             repository.LogRepository
+}
+
+type Retention interface {
+	GetPolicies() ([]models.RetentionPolicy, error)
+	CreatePolicy(policy *models.RetentionPolicy) error
+	DeletePolicy(id uint) error
+	// GetExpiredLogIds Returns the logs that the next purge would delete, without deleting them
+	GetExpiredLogIds() ([]uint, error)
+	// Purge Hard deletes the expired logs. Returns how many logs were deleted
+	Purge() (int, error)
+	// StartPurger Purges the expired logs in the background, every configured interval
+	StartPurger()
+}
+
+type RetentionProvider struct {
+}
+
+func (r RetentionProvider) Provide() any {
+	var instance Retention = &retention{
+		retentionPolicyRepository: di.Get[repository.RetentionPolicyRepository](),
+		logRepository:             di.Get[repository.LogRepository](),
+	}
+	return instance
+}
+
+func (r *retention) GetPolicies() ([]models.RetentionPolicy, error) {
+	return r.retentionPolicyRepository.GetAll()
+}
+
+func (r *retention) CreatePolicy(policy *models.RetentionPolicy) error {
+	return r.retentionPolicyRepository.Save(policy)
+}
+
+func (r *retention) DeletePolicy(id uint) error {
+	policy := r.retentionPolicyRepository.GetById(id)
+	if policy == nil {
+		return lib.Error{Msg: "No retention policy with given id"}
+	}
+
+	return r.retentionPolicyRepository.DeletePermanently(policy)
+}
+
+// Returns the policies, most specific first
+func (r *retention) getSortedPolicies() ([]models.RetentionPolicy, error) {
+	policies, err := r.retentionPolicyRepository.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(policies, func(a, b models.RetentionPolicy) int {
+		return b.Specificity() - a.Specificity()
+	})
+
+	return policies, nil
+}
+
+// Returns how long the log is kept, 0 meaning forever
+func getRetention(log models.Log, sortedPolicies []models.RetentionPolicy) time.Duration {
+	policy := lib.Find(sortedPolicies, func(policy models.RetentionPolicy) bool {
+		return policy.Matches(log)
+	})
+
+	if policy == nil {
+		return config.GetRetentionConfig().DefaultRetention
+	}
+
+	return time.Duration(policy.Days) * 24 * time.Hour
+}
+
+// Returns the shortest retention of all policies. Logs created after now minus this retention can't be expired.
+// Returns false if every log is kept forever
+func getShortestRetention(policies []models.RetentionPolicy) (time.Duration, bool) {
+	retentions := lib.Map(policies, func(policy models.RetentionPolicy) time.Duration {
+		return time.Duration(policy.Days) * 24 * time.Hour
+	})
+	retentions = append(retentions, config.GetRetentionConfig().DefaultRetention)
+	retentions = lib.Filter(retentions, func(retention time.Duration) bool {
+		return retention > 0
+	})
+
+	if len(retentions) == 0 {
+		return 0, false
+	}
+
+	return slices.Min(retentions), true
+}
+
+func (r *retention) GetExpiredLogIds() ([]uint, error) {
+	policies, err := r.getSortedPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	shortestRetention, canExpire := getShortestRetention(policies)
+	expiredIds := make([]uint, 0)
+	if !canExpire {
+		return expiredIds, nil
+	}
+
+	now := time.Now()
+	oldestPossiblyKept := now.Add(-shortestRetention)
+	filter := models.LogFilter{CreatedTo: &oldestPossiblyKept}
+
+	var cursor *uint
+	for {
+		page, err := r.logRepository.GetPageOfOriginals(filter, cursor, purgePageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, log := range page.Items {
+			logRetention := getRetention(log, policies)
+			if logRetention > 0 && log.CreatedAt.Before(now.Add(-logRetention)) {
+				expiredIds = append(expiredIds, log.ID)
+			}
+		}
+
+		if page.NextCursor == nil {
+			return expiredIds, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (r *retention) Purge() (int, error) {
+	expiredIds, err := r.GetExpiredLogIds()
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(expiredIds); start += purgePageSize {
+		end := min(start+purgePageSize, len(expiredIds))
+		err = r.logRepository.DeletePermanentlyWithRelations(expiredIds[start:end])
+		if err != nil {
+			return start, err
+		}
+	}
+
+	return len(expiredIds), nil
+}
+
+func (r *retention) StartPurger() {
+	go func() {
+		ticker := time.NewTicker(config.GetRetentionConfig().PurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := r.Purge()
+			if err != nil {
+				fmt.Println("Failed to purge expired logs: " + err.Error())
+				continue
+			}
+
+			if purged != 0 {
+				fmt.Printf("Purged %d expired logs\n", purged)
+			}
+		}
+	}()
+}
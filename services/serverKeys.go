@@ -0,0 +1,381 @@
+package services
+
+import (
+	cryptoLib "crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/go-jose/go-jose/v4"
+	jwtLib "github.com/golang-jwt/jwt/v5"
+	"os"
+	"shareLog/config"
+	"shareLog/di"
+	"shareLog/lib"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secrets and key files starting with the prefix are wrapped with the key encryption backend
+const wrappedSecretPrefix = "wrapped:"
+
+const jwtKeyIdHeader = "kid"
+const jwtKeyUse = "sig"
+const jweKeyUse = "enc"
+
+type serverKeys struct {
+	backend KeyEncryptionBackend
+	lock    sync.Mutex
+	// The keys and secrets already read and unwrapped, by name
+	cache map[string]any
+	// Until when the tokens issued before key ids and the current key algorithms are accepted
+	legacyTokensUntil time.Time
+}
+
+type ServerKeys interface {
+	// GetJweEncryptionKey Returns the current public key the tokens are encrypted to
+	GetJweEncryptionKey() (*jose.JSONWebKey, error)
+	// GetJweDecryptionKeys Returns the private keys of the current and retired JWE keys, identified by kid. The current key is the first one
+	GetJweDecryptionKeys() (*jose.JSONWebKeySet, error)
+	/*
+		GetJwtVerificationKeys Returns the public keys of the current and retired JWT signing keys, identified by kid.
+		The current key is the first one. The keys of a signing key held by the key encryption backend are fetched from it
+	*/
+	GetJwtVerificationKeys() (*jose.JSONWebKeySet, error)
+	// SignJwt Returns the token signed with the current key. The signing is done by the key encryption backend
+	SignJwt(token *jwtLib.Token) (string, error)
+	GetLogSharingSecret() (string, error)
+	GetFingerprintSecret() (string, error)
+	GetApiKeySecret() (string, error)
+	GetSignInParamsSecret() (string, error)
+	// WrapSecret Returns the secret wrapped with the key encryption backend, in the format the server reads
+	WrapSecret(secret []byte) (string, error)
+	/*
+		AcceptsLegacyTokens Whether the tokens issued before key ids and the current key algorithms are still accepted.
+		They are checked against the current keys until the configured cutoff
+	*/
+	AcceptsLegacyTokens() bool
+}
+
+type ServerKeysProvider struct {
+}
+
+func (s ServerKeysProvider) Provide() any {
+	var instance ServerKeys = &serverKeys{
+		backend:           di.Get[KeyEncryptionBackend](),
+		cache:             make(map[string]any),
+		legacyTokensUntil: config.GetSessionConfig().LegacyTokensUntil,
+	}
+	return instance
+}
+
+func (s *serverKeys) AcceptsLegacyTokens() bool {
+	return time.Now().Before(s.legacyTokensUntil)
+}
+
+// Returns the value, unwrapped with the backend if it is wrapped
+func unwrapSecret(value []byte, unwrap func(wrapped string) ([]byte, error)) ([]byte, error) {
+	wrapped, isWrapped := strings.CutPrefix(strings.TrimSpace(string(value)), wrappedSecretPrefix)
+	if !isWrapped {
+		return value, nil
+	}
+
+	return unwrap(wrapped)
+}
+
+func readSecretFile(path string, unwrap func(wrapped string) ([]byte, error)) ([]byte, error) {
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapSecret(fileBytes, unwrap)
+}
+
+func decodePem(keyBytes []byte) (*pem.Block, error) {
+	pemDecoded, _ := pem.Decode(keyBytes)
+	if pemDecoded == nil {
+		return nil, lib.Error{Msg: "Invalid PEM key"}
+	}
+
+	return pemDecoded, nil
+}
+
+func parsePemPublicKey(keyBytes []byte) (any, error) {
+	pemDecoded, err := decodePem(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(pemDecoded.Bytes)
+}
+
+func parsePemPrivateKey(keyBytes []byte) (any, error) {
+	pemDecoded, err := decodePem(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(pemDecoded.Bytes)
+	if err != nil {
+		// try EC format instead of PKIX format
+		ecKey, err := x509.ParseECPrivateKey(pemDecoded.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return ecKey, nil
+	}
+
+	return key, nil
+}
+
+// Returns the cached value, loading it the first time. Failures aren't cached
+func (s *serverKeys) load(name string, loader func() (any, error)) (any, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if value, ok := s.cache[name]; ok {
+		return value, nil
+	}
+
+	value, err := loader()
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache[name] = value
+	return value, nil
+}
+
+func readKey[T any](s *serverKeys, path string, parse func(keyBytes []byte) (any, error)) (T, error) {
+	var emptyKey T
+	keyBytes, err := readSecretFile(path, s.backend.Unwrap)
+	if err != nil {
+		return emptyKey, err
+	}
+
+	key, err := parse(keyBytes)
+	if err != nil {
+		return emptyKey, err
+	}
+
+	typedKey, ok := key.(T)
+	if !ok {
+		return emptyKey, lib.Error{Msg: "Unexpected key type", Reason: path}
+	}
+
+	return typedKey, nil
+}
+
+// Returns the key identified by the RFC 7638 thumbprint of its public key
+func newJsonWebKey(key any, publicKey any, algorithm string, use string) (jose.JSONWebKey, error) {
+	publicJwk := jose.JSONWebKey{Key: publicKey}
+	thumbprint, err := publicJwk.Thumbprint(cryptoLib.SHA256)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	return jose.JSONWebKey{
+		Key:       key,
+		KeyID:     base64.RawURLEncoding.EncodeToString(thumbprint),
+		Algorithm: algorithm,
+		Use:       use,
+	}, nil
+}
+
+// RSA keys use RSA-OAEP-256, EC keys use ECDH-ES key agreement
+func jweKeyAlgorithm(publicKey any) (jose.KeyAlgorithm, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return jose.RSA_OAEP_256, nil
+	case *ecdsa.PublicKey:
+		return jose.ECDH_ES, nil
+	default:
+		return "", lib.Error{Msg: "The JWE key must be an RSA or EC key"}
+	}
+}
+
+func newJweKey(key any, publicKey any) (jose.JSONWebKey, error) {
+	algorithm, err := jweKeyAlgorithm(publicKey)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	return newJsonWebKey(key, publicKey, string(algorithm), jweKeyUse)
+}
+
+func (s *serverKeys) readJwePrivateKey(path string) (jose.JSONWebKey, error) {
+	privateKey, err := readKey[any](s, path, parsePemPrivateKey)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	switch typedKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return newJweKey(typedKey, &typedKey.PublicKey)
+	case *ecdsa.PrivateKey:
+		return newJweKey(typedKey, &typedKey.PublicKey)
+	default:
+		return jose.JSONWebKey{}, lib.Error{Msg: "The JWE key must be an RSA or EC key", Reason: path}
+	}
+}
+
+func (s *serverKeys) readJwtPublicKey(path string) (jose.JSONWebKey, error) {
+	publicKey, err := readKey[*ecdsa.PublicKey](s, path, parsePemPublicKey)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	return newJsonWebKey(publicKey, publicKey, jwtLib.SigningMethodES512.Alg(), jwtKeyUse)
+}
+
+func (s *serverKeys) loadSecret(name string, value string) (string, error) {
+	secret, err := s.load(name, func() (any, error) {
+		secretBytes, err := unwrapSecret([]byte(value), s.backend.Unwrap)
+		return string(secretBytes), err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret.(string), nil
+}
+
+func (s *serverKeys) GetJweEncryptionKey() (*jose.JSONWebKey, error) {
+	key, err := s.load("jweEncryptionKey", func() (any, error) {
+		publicKey, err := readKey[any](s, config.GetKeyPaths().JwePubKeyPath, parsePemPublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk, err := newJweKey(publicKey, publicKey)
+		return &jwk, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key.(*jose.JSONWebKey), nil
+}
+
+func (s *serverKeys) GetJweDecryptionKeys() (*jose.JSONWebKeySet, error) {
+	keySet, err := s.load("jweDecryptionKeys", func() (any, error) {
+		keyPaths := config.GetKeyPaths()
+		return s.readKeySet(append([]string{keyPaths.JwePkPath}, keyPaths.JweRetiredPkPaths...), s.readJwePrivateKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keySet.(*jose.JSONWebKeySet), nil
+}
+
+func (s *serverKeys) GetJwtVerificationKeys() (*jose.JSONWebKeySet, error) {
+	keySet, err := s.load("jwtVerificationKeys", func() (any, error) {
+		keyPaths := config.GetKeyPaths()
+		backendKeys, err := s.backend.GetSigningPublicKeys()
+		if err != nil {
+			return nil, err
+		}
+		if backendKeys == nil {
+			return s.readKeySet(append([]string{keyPaths.JwtPubKeyPath}, keyPaths.JwtRetiredPubKeyPaths...), s.readJwtPublicKey)
+		}
+
+		// The backend holds the signing key, the local files only hold the keys retired before it
+		keySet, err := s.readKeySet(keyPaths.JwtRetiredPubKeyPaths, s.readJwtPublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		backendKeySet := jose.JSONWebKeySet{}
+		for _, publicKey := range backendKeys {
+			key, err := newJsonWebKey(publicKey, publicKey, jwtLib.SigningMethodES512.Alg(), jwtKeyUse)
+			if err != nil {
+				return nil, err
+			}
+			backendKeySet.Keys = append(backendKeySet.Keys, key)
+		}
+
+		backendKeySet.Keys = append(backendKeySet.Keys, keySet.Keys...)
+		return &backendKeySet, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keySet.(*jose.JSONWebKeySet), nil
+}
+
+// The current key is the first one of the set
+func (s *serverKeys) readKeySet(paths []string, readKey func(path string) (jose.JSONWebKey, error)) (*jose.JSONWebKeySet, error) {
+	keySet := jose.JSONWebKeySet{}
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		keySet.Keys = append(keySet.Keys, key)
+	}
+
+	return &keySet, nil
+}
+
+func (s *serverKeys) SignJwt(token *jwtLib.Token) (string, error) {
+	verificationKeys, err := s.GetJwtVerificationKeys()
+	if err != nil {
+		return "", err
+	}
+
+	// The verifiers find the public key of the signing key with the kid
+	token.Header[jwtKeyIdHeader] = verificationKeys.Keys[0].KeyID
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := s.backend.Sign([]byte(signingString))
+	if err != nil {
+		return "", err
+	}
+
+	return signingString + "." + token.EncodeSegment(signature), nil
+}
+
+func (s *serverKeys) GetLogSharingSecret() (string, error) {
+	return s.loadSecret("logSharingSecret", config.GetSecrets().LogSharingSecret)
+}
+
+func (s *serverKeys) GetFingerprintSecret() (string, error) {
+	// An empty key would make the fingerprints plain hashes of the stack traces
+	if config.GetSecrets().FingerprintSecret == "" {
+		return "", lib.Error{Msg: "The fingerprint secret isn't set"}
+	}
+
+	return s.loadSecret("fingerprintSecret", config.GetSecrets().FingerprintSecret)
+}
+
+func (s *serverKeys) GetApiKeySecret() (string, error) {
+	return s.loadSecret("apiKeySecret", config.GetSecrets().ApiKeySecret)
+}
+
+func (s *serverKeys) GetSignInParamsSecret() (string, error) {
+	// An empty key would let anyone compute the made up parameters and tell the unknown emails apart
+	if config.GetSecrets().SignInParamsSecret == "" {
+		return "", lib.Error{Msg: "The sign-in params secret isn't set"}
+	}
+
+	return s.loadSecret("signInParamsSecret", config.GetSecrets().SignInParamsSecret)
+}
+
+func (s *serverKeys) WrapSecret(secret []byte) (string, error) {
+	wrapped, err := s.backend.Wrap(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return wrappedSecretPrefix + wrapped, nil
+}
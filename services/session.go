@@ -0,0 +1,254 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"shareLog/config"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"time"
+)
+
+const refreshTokenSize = 32 // bytes
+
+// Labels deriving independent values from a refresh token
+const refreshTokenHashLabel = "session lookup"
+const refreshTokenSealingLabel = "session symmetric key"
+
+type sessions struct {
+	sessionRepository repository.SessionRepository
+	userRepository    repository.UserRepository
+	authService       Auth
+	keyManager        KeyManager
+}
+
+// SessionClient The device a session is used from
+type SessionClient struct {
+	Device string
+	Ip     string
+}
+
+type SessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+type Sessions interface {
+	/*
+		StartSession Starts a new session family for the user, returning an access token and its refresh token.
+		Without a user symmetric key the session is zero-knowledge
+	*/
+	StartSession(user *models.User, userSymmetricKey string, client SessionClient) (*SessionTokens, error)
+	/*
+		Refresh Replaces the session of the refresh token with a new one of the same family.
+		A refresh token that was already replaced revokes the whole family
+	*/
+	Refresh(refreshToken string, client SessionClient) (*SessionTokens, error)
+	// GetActiveSessions Returns one session per family that can still be refreshed
+	GetActiveSessions(user *models.User) ([]models.Session, error)
+	// RevokeSession Revokes the family of the session, its access tokens are rejected right away
+	RevokeSession(user *models.User, id uint) error
+	RevokeFamily(familyId string) error
+}
+
+type SessionsProvider struct {
+}
+
+func (s SessionsProvider) Provide() any {
+	var instance Sessions = &sessions{
+		sessionRepository: di.Get[repository.SessionRepository](),
+		userRepository:    di.Get[repository.UserRepository](),
+		authService:       di.Get[Auth](),
+		keyManager:        di.Get[KeyManager](),
+	}
+	return instance
+}
+
+func generateRefreshToken() (string, error) {
+	tokenBytes := make([]byte, refreshTokenSize)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+func deriveFromRefreshToken(refreshToken string, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(refreshToken))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func hashRefreshToken(refreshToken string) string {
+	return hex.EncodeToString(deriveFromRefreshToken(refreshToken, refreshTokenHashLabel))
+}
+
+/*
+Creates the next session of the family with a new refresh token and issues its access token.
+The session is stored with save, nothing is issued when save fails
+*/
+func (s *sessions) issue(user *models.User, session models.Session, userSymmetricKey string, client SessionClient, save func(session *models.Session) error) (*SessionTokens, error) {
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.ZeroKnowledge {
+		session.SealedSymmetricKey, err = lib.SealEnvelope([]byte(userSymmetricKey), deriveFromRefreshToken(refreshToken, refreshTokenSealingLabel), lib.DefaultKeyVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	sessionConfig := config.GetSessionConfig()
+	session.TokenHash = hashRefreshToken(refreshToken)
+	session.Device = client.Device
+	session.Ip = client.Ip
+	session.LastUsedAt = now
+	// Refreshing extends the session, but never past the lifetime of its family
+	session.ExpiresAt = now.Add(sessionConfig.RefreshTokenLifetime)
+	familyExpiresAt := session.StartedAt.Add(sessionConfig.FamilyLifetime)
+	if familyExpiresAt.Before(session.ExpiresAt) {
+		session.ExpiresAt = familyExpiresAt
+	}
+
+	err = save(&session)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.authService.GenerateAccessToken(user, userSymmetricKey, session.FamilyId)
+	if err != nil {
+		return nil, err
+	}
+
+	serializedToken, err := accessToken.CompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		AccessToken:  serializedToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *sessions) StartSession(user *models.User, userSymmetricKey string, client SessionClient) (*SessionTokens, error) {
+	err := s.sessionRepository.DeleteExpired(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	familyId, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		UserId:        user.ID,
+		FamilyId:      familyId,
+		ZeroKnowledge: userSymmetricKey == "",
+		TokenVersion:  user.TokenVersion,
+		StartedAt:     time.Now(),
+	}
+	return s.issue(user, session, userSymmetricKey, client, s.sessionRepository.Save)
+}
+
+func (s *sessions) Refresh(refreshToken string, client SessionClient) (*SessionTokens, error) {
+	session := s.sessionRepository.GetByTokenHash(hashRefreshToken(refreshToken))
+	if session == nil {
+		return nil, lib.Error{Msg: "Invalid refresh token"}
+	}
+
+	if session.RevokedAt != nil {
+		return nil, lib.Error{Msg: "The session was revoked"}
+	}
+
+	if session.ReplacedAt != nil {
+		return nil, s.revokeReusedFamily(session)
+	}
+
+	if session.ExpiresAt.Before(time.Now()) {
+		return nil, lib.Error{Msg: "The session expired"}
+	}
+
+	user := s.userRepository.GetById(session.UserId)
+	if user == nil || user.TokenVersion != session.TokenVersion {
+		// The user was signed out everywhere, by a password change for example
+		err := s.sessionRepository.RevokeFamily(session.FamilyId)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, lib.Error{Msg: "The session was revoked"}
+	}
+
+	userSymmetricKey := ""
+	if !session.ZeroKnowledge {
+		symmetricKeyBytes, err := lib.OpenEnvelope(session.SealedSymmetricKey, deriveFromRefreshToken(refreshToken, refreshTokenSealingLabel))
+		if err != nil {
+			return nil, err
+		}
+
+		userSymmetricKey = string(symmetricKeyBytes)
+
+		// Long sessions would otherwise miss the generations rotated since the sign-in
+		_, err = s.keyManager.AcquireRotatedKeys(user, userSymmetricKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nextSession := models.Session{
+		UserId:        session.UserId,
+		FamilyId:      session.FamilyId,
+		ZeroKnowledge: session.ZeroKnowledge,
+		TokenVersion:  session.TokenVersion,
+		StartedAt:     session.StartedAt,
+	}
+	return s.issue(user, nextSession, userSymmetricKey, client, func(nextSession *models.Session) error {
+		isReplaced, err := s.sessionRepository.Replace(session, nextSession)
+		if err != nil {
+			return err
+		}
+		if !isReplaced {
+			// Another request refreshed it first with the same token
+			return s.revokeReusedFamily(session)
+		}
+
+		return nil
+	})
+}
+
+func (s *sessions) revokeReusedFamily(session *models.Session) error {
+	err := s.sessionRepository.RevokeFamily(session.FamilyId)
+	if err != nil {
+		return err
+	}
+
+	return lib.Error{Msg: "The refresh token was already used, the session was revoked"}
+}
+
+func (s *sessions) GetActiveSessions(user *models.User) ([]models.Session, error) {
+	return s.sessionRepository.GetActiveByUserId(user.ID)
+}
+
+func (s *sessions) RevokeSession(user *models.User, id uint) error {
+	session := s.sessionRepository.GetById(id)
+	if session == nil || session.UserId != user.ID {
+		return lib.Error{Msg: "No session with given id"}
+	}
+
+	return s.sessionRepository.RevokeFamily(session.FamilyId)
+}
+
+func (s *sessions) RevokeFamily(familyId string) error {
+	return s.sessionRepository.RevokeFamily(familyId)
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/models"
+	"shareLog/models/userGrant"
+	"testing"
+	"time"
+)
+
+func TestRefreshAcquiresRotatedKeys(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+	invite, code := env.invite(t, userGrant.Types.GrantClient, owner, ownerSymmetricKey)
+	client, clientSymmetricKey := env.signUpInvited(t, invite, code, "client@test.com")
+
+	sessionsService := di.Get[Sessions]()
+	tokens, err := sessionsService.StartSession(client, clientSymmetricKey, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generation, err := di.Get[KeyManager]().CreateKeyGeneration(owner, ownerSymmetricKey, userGrant.Types.GrantClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sessionsService.Refresh(tokens.RefreshToken, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = env.reloadUser(t, client.ID)
+	newestKey := di.Get[KeyManager]().GetKeyForLevel(client, userGrant.Types.GrantClient)
+	if newestKey.Generation != generation {
+		t.Errorf("newest client generation after the refresh = %d, expected %d", newestKey.Generation, generation)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	env := setupTestEnv(t)
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+
+	sessionsService := di.Get[Sessions]()
+	tokens, err := sessionsService.StartSession(owner, ownerSymmetricKey, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshedTokens, err := sessionsService.Refresh(tokens.RefreshToken, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stolen token used after the rightful client refreshed it
+	_, err = sessionsService.Refresh(tokens.RefreshToken, SessionClient{Device: "attacker"})
+	if err == nil {
+		t.Fatal("a replaced refresh token was accepted")
+	}
+
+	_, err = sessionsService.Refresh(refreshedTokens.RefreshToken, SessionClient{Device: "test"})
+	if err == nil {
+		t.Error("the family wasn't revoked after the reuse")
+	}
+
+	activeSessions, err := sessionsService.GetActiveSessions(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activeSessions) != 0 {
+		t.Errorf("%d sessions are still active", len(activeSessions))
+	}
+}
+
+func TestRefreshNeverExtendsPastFamilyLifetime(t *testing.T) {
+	env := setupTestEnv(t)
+	t.Setenv("sessionFamilyDays", "1")
+	owner, ownerSymmetricKey := env.signUpOwner(t, "owner@test.com")
+
+	sessionsService := di.Get[Sessions]()
+	tokens, err := sessionsService.StartSession(owner, ownerSymmetricKey, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The family started almost a day ago
+	startedAt := time.Now().Add(-23 * time.Hour)
+	err = env.db.Model(&models.Session{}).Where("user_id = ?", owner.ID).Update("started_at", startedAt).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshedTokens, err := sessionsService.Refresh(tokens.RefreshToken, SessionClient{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := di.Get[repository.SessionRepository]().GetByTokenHash(hashRefreshToken(refreshedTokens.RefreshToken))
+	familyExpiresAt := startedAt.Add(24 * time.Hour)
+	if session.ExpiresAt.After(familyExpiresAt.Add(time.Second)) {
+		t.Errorf("the session expires at %v, after its family at %v", session.ExpiresAt, familyExpiresAt)
+	}
+
+	err = env.db.Model(&models.Session{}).Where("id = ?", session.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sessionsService.Refresh(refreshedTokens.RefreshToken, SessionClient{Device: "test"})
+	if err == nil {
+		t.Error("an expired session was refreshed")
+	}
+}
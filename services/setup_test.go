@@ -0,0 +1,270 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+	"os"
+	"path/filepath"
+	"shareLog/data"
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/di/diLib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"testing"
+	"time"
+)
+
+const testPassword = "Test-password-1"
+
+type testDatabaseProvider struct {
+	db *gorm.DB
+}
+
+func (t testDatabaseProvider) Provide() any {
+	return t.db
+}
+
+// Keeps the invite codes, the only way to learn them
+type testMailer struct {
+	codes *[]string
+}
+
+func (t testMailer) Provide() any {
+	var instance Mailer = t
+	return instance
+}
+
+func (t testMailer) EmailInviteCode(code string) {
+	*t.codes = append(*t.codes, code)
+}
+
+type testEnv struct {
+	db          *gorm.DB
+	inviteCodes []string
+}
+
+// Writes a PKCS8 private key and its PKIX public key, returns their paths
+func writeTestKeyPair(t *testing.T, dir string, name string, curve elliptic.Curve) (string, string) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, name+".key")
+	publicPath := filepath.Join(dir, name+".pub")
+	err = os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+/*
+Replaces the container with the repositories and services on a new database, configured with cheap KDF parameters
+and keys of their own. The previous container is restored at the end of the test
+*/
+func setupTestEnv(t *testing.T) *testEnv {
+	dir := t.TempDir()
+	jwtPkPath, jwtPubKeyPath := writeTestKeyPair(t, dir, "jwt", elliptic.P521())
+	jwePkPath, jwePubKeyPath := writeTestKeyPair(t, dir, "jwe", elliptic.P256())
+	kekPath := filepath.Join(dir, "kek")
+	kek := make([]byte, kekSize)
+	_, err := rand.Read(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(kekPath, []byte(base64.StdEncoding.EncodeToString(kek)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range map[string]string{
+		"jwtPkPath":          jwtPkPath,
+		"jwtPubKeyPath":      jwtPubKeyPath,
+		"jwePkPath":          jwePkPath,
+		"jwePubKeyPath":      jwePubKeyPath,
+		"kekPath":            kekPath,
+		"logSharingSecret":   "test log sharing secret",
+		"fingerprintSecret":  "test fingerprint secret",
+		"apiKeySecret":       "test api key secret",
+		"signInParamsSecret": "test sign-in params secret",
+		"kdfIterations":      "1",
+		"kdfMemoryKiB":       "64",
+		"kdfParallelism":     "1",
+	} {
+		t.Setenv(key, value)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "test.db")), &gorm.Config{Logger: gormLogger.Default.LogMode(gormLogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := testEnv{db: db}
+	previousContainer := di.Container
+	di.Container = diLib.NewContainer()
+	t.Cleanup(func() {
+		di.Container = previousContainer
+	})
+
+	container := di.Container
+	diLib.RegisterProvider[*gorm.DB](container, testDatabaseProvider{db: db}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Mailer](container, testMailer{codes: &env.inviteCodes}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.KeyRepository](container, repository.KeyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.LogRepository](container, repository.LogRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.LogDataKeyRepository](container, repository.LogDataKeyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.UserRepository](container, repository.UserRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.SessionRepository](container, repository.SessionRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.AttemptCounterRepository](container, repository.AttemptCounterRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.InviteRepository](container, repository.InviteRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.LogPermissionRepository](container, repository.LogPermissionRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.ApiKeyRepository](container, repository.ApiKeyRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.IssueRepository](container, repository.IssueRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.RecoveryCodeRepository](container, repository.RecoveryCodeRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[repository.KeyRotationRepository](container, repository.KeyRotationRepositoryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[KeyEncryptionBackend](container, KeyEncryptionBackendProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[ServerKeys](container, ServerKeysProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Crypto](container, CryptoProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[KeyManager](container, KeyManagerProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Recovery](container, RecoveryProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Auth](container, AuthProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Sessions](container, SessionsProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Issue](container, IssueProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[Logger](container, LoggerProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[PermissionRequest](container, PermissionRequestProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[KeyRotation](container, KeyRotationProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[AttemptStore](container, AttemptStoreProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[AttemptLimiter](container, AttemptLimiterProvider{}, diLib.SingletonProvider)
+	diLib.RegisterProvider[ApiKeys](container, ApiKeysProvider{}, diLib.SingletonProvider)
+
+	return &env
+}
+
+// Signs up the first owner, who holds the owner and client keys. Returns the user with their keys
+func (e *testEnv) signUpOwner(t *testing.T, email string) (*models.User, string) {
+	authService := di.Get[Auth]()
+	user, _, err := authService.SignUpFirstUser(email, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e.reloadUser(t, user.ID), authService.DeriveUserSymmetricKey(user, testPassword)
+}
+
+// Invites a user with the given grant on behalf of refUser. Returns the invite and its code
+func (e *testEnv) invite(t *testing.T, grant userGrant.Type, refUser *models.User, refUserSymmetricKey string) (*models.Invite, string) {
+	invite, err := di.Get[Auth]().CreateUserInvite(grant, refUser, refUserSymmetricKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return invite, e.inviteCodes[len(e.inviteCodes)-1]
+}
+
+func (e *testEnv) signUpInvited(t *testing.T, invite *models.Invite, code string, email string) (*models.User, string) {
+	authService := di.Get[Auth]()
+	user, _, err := authService.SignUpWithEmail(email, testPassword, code, invite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e.reloadUser(t, user.ID), authService.DeriveUserSymmetricKey(user, testPassword)
+}
+
+func (e *testEnv) reloadUser(t *testing.T, id uint) *models.User {
+	user := di.Get[repository.UserRepository]().GetByIdWithPrivateKeys(id)
+	if user.ID == 0 {
+		t.Fatalf("no user with id %d", id)
+	}
+
+	return user
+}
+
+// Signs in with the password, acquiring the shared and rotated keys. Returns the user with their keys
+func (e *testEnv) signIn(t *testing.T, email string) (*models.User, string) {
+	authService := di.Get[Auth]()
+	user, err := authService.SignInWithEmail(email, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e.reloadUser(t, user.ID), authService.DeriveUserSymmetricKey(user, testPassword)
+}
+
+func (e *testEnv) saveLog(t *testing.T, message string) *models.Log {
+	log, _, err := di.Get[Logger]().SaveLog(dto.Log{
+		StackTrace: "java.lang.IllegalStateException: Boom\n\tat com.app.Foo.bar(Foo.java:12)",
+		Message:    message,
+		Tags:       map[string]string{"env": "test"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return log
+}
+
+// Gives the client access to the log and lets them acquire its shared key
+func (e *testEnv) shareLog(t *testing.T, logId uint, owner *models.User, ownerSymmetricKey string, client *models.User) {
+	permissionService := di.Get[PermissionRequest]()
+	err := permissionService.RequestPermission(client, logId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := di.Get[repository.LogPermissionRepository]().GetByLogId(logId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = permissionService.ApprovePermission(owner, ownerSymmetricKey, *request)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Waits for the background job of the rotation to stop
+func (e *testEnv) waitForRotation(t *testing.T, id uint) *models.KeyRotation {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		rotation, err := di.Get[KeyRotation]().GetRotation(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotation.Status != models.KeyRotationStatuses.Running {
+			return rotation
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("the rotation %d is still running", id)
+	return nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"shareLog/config"
+	"shareLog/lib"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const transitRequestTimeout = 10 * time.Second
+const transitTokenHeader = "X-Vault-Token"
+
+/*
+transitKeyBackend Delegates to the HTTP API of the Vault transit secrets engine, or anything compatible with it.
+The key encryption key and the JWT signing key never leave it
+*/
+type transitKeyBackend struct {
+	address        string
+	token          string
+	mount          string
+	keyName        string
+	signingKeyName string
+	client         *http.Client
+	// Used when the backend doesn't hold a signing key
+	localSigner *keyFileSigner
+}
+
+type transitResponse[T any] struct {
+	Data T `json:"data"`
+}
+
+func newTransitKeyBackend(backendConfig config.KeyBackendConfig) *transitKeyBackend {
+	backend := &transitKeyBackend{
+		address:        strings.TrimSuffix(backendConfig.TransitAddress, "/"),
+		token:          backendConfig.TransitToken,
+		mount:          backendConfig.TransitMount,
+		keyName:        backendConfig.TransitKeyName,
+		signingKeyName: backendConfig.TransitSigningKeyName,
+		client:         &http.Client{Timeout: transitRequestTimeout},
+	}
+	backend.localSigner = &keyFileSigner{unwrap: backend.Unwrap}
+	return backend
+}
+
+func transitPost[T any](t *transitKeyBackend, path string, body any) (*T, error) {
+	serializedBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return transitRequest[T](t, http.MethodPost, path, bytes.NewReader(serializedBody))
+}
+
+func transitRequest[T any](t *transitKeyBackend, method string, path string, body io.Reader) (*T, error) {
+	url := fmt.Sprintf("%s/v1/%s/%s", t.address, t.mount, path)
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(transitTokenHeader, t.token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, lib.Error{Msg: "Transit request failed", Reason: fmt.Sprintf("%d %s", response.StatusCode, responseBody)}
+	}
+
+	var parsedResponse transitResponse[T]
+	err = json.Unmarshal(responseBody, &parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsedResponse.Data, nil
+}
+
+func (t *transitKeyBackend) Wrap(plaintext []byte) (string, error) {
+	response, err := transitPost[struct {
+		Ciphertext string `json:"ciphertext"`
+	}](t, "encrypt/"+t.keyName, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return response.Ciphertext, nil
+}
+
+func (t *transitKeyBackend) Unwrap(wrapped string) ([]byte, error) {
+	response, err := transitPost[struct {
+		Plaintext string `json:"plaintext"`
+	}](t, "decrypt/"+t.keyName, map[string]string{
+		"ciphertext": wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(response.Plaintext)
+}
+
+func (t *transitKeyBackend) Sign(data []byte) ([]byte, error) {
+	if t.signingKeyName == "" {
+		return t.localSigner.Sign(data)
+	}
+
+	// The jws marshaling returns the fixed size r || s signature JWTs use
+	response, err := transitPost[struct {
+		Signature string `json:"signature"`
+	}](t, "sign/"+t.signingKeyName+"/sha2-512", map[string]string{
+		"input":                base64.StdEncoding.EncodeToString(data),
+		"marshaling_algorithm": "jws",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Signatures are prefixed with the key version, like vault:v1:
+	versionEnd := strings.LastIndex(response.Signature, ":")
+	return base64.RawURLEncoding.DecodeString(response.Signature[versionEnd+1:])
+}
+
+func (t *transitKeyBackend) GetSigningPublicKeys() ([]*ecdsa.PublicKey, error) {
+	if t.signingKeyName == "" {
+		return nil, nil
+	}
+
+	response, err := transitRequest[struct {
+		Keys map[string]struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+	}](t, http.MethodGet, "keys/"+t.signingKeyName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The versions are the keys of the map
+	versions := make([]int, 0, len(response.Keys))
+	for version := range response.Keys {
+		parsedVersion, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, lib.Error{Msg: "Invalid transit key version", Reason: version}
+		}
+		versions = append(versions, parsedVersion)
+	}
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	publicKeys := make([]*ecdsa.PublicKey, 0, len(versions))
+	for _, version := range versions {
+		publicKey, err := parsePemPublicKey([]byte(response.Keys[strconv.Itoa(version)].PublicKey))
+		if err != nil {
+			return nil, err
+		}
+
+		ecPublicKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, lib.Error{Msg: "The transit signing key isn't an ecdsa key", Reason: t.signingKeyName}
+		}
+		publicKeys = append(publicKeys, ecPublicKey)
+	}
+
+	if len(publicKeys) == 0 {
+		return nil, lib.Error{Msg: "The transit signing key has no public key", Reason: t.signingKeyName}
+	}
+
+	return publicKeys, nil
+}
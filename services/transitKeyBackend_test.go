@@ -0,0 +1,251 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	jwtLib "github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"shareLog/config"
+	"strings"
+	"testing"
+)
+
+const testTransitToken = "test-token"
+const testCiphertextPrefix = "vault:v1:"
+
+// Stands in for the transit API: wraps by prefixing, signs with the given keys, newest version last
+func newTransitServer(t *testing.T, signingKeys ...*ecdsa.PrivateKey) *httptest.Server {
+	respond := func(w http.ResponseWriter, data any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/transit/encrypt/kek", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		respond(w, map[string]string{"ciphertext": testCiphertextPrefix + body["plaintext"]})
+	})
+	mux.HandleFunc("POST /v1/transit/decrypt/kek", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		plaintext, isWrapped := strings.CutPrefix(body["ciphertext"], testCiphertextPrefix)
+		if !isWrapped {
+			http.Error(w, `{"errors":["invalid ciphertext"]}`, http.StatusBadRequest)
+			return
+		}
+		respond(w, map[string]string{"plaintext": plaintext})
+	})
+	mux.HandleFunc("POST /v1/transit/sign/jwt/sha2-512", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["marshaling_algorithm"] != "jws" {
+			http.Error(w, `{"errors":["unexpected marshaling"]}`, http.StatusBadRequest)
+			return
+		}
+
+		input, _ := base64.StdEncoding.DecodeString(body["input"])
+		signature, err := jwtLib.SigningMethodES512.Sign(string(input), signingKeys[len(signingKeys)-1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respond(w, map[string]string{"signature": "vault:v1:" + base64.RawURLEncoding.EncodeToString(signature)})
+	})
+	mux.HandleFunc("GET /v1/transit/keys/jwt", func(w http.ResponseWriter, r *http.Request) {
+		keys := make(map[string]map[string]string)
+		for i, key := range signingKeys {
+			publicKeyBytes, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			publicKeyPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+			keys[string(rune('1'+i))] = map[string]string{"public_key": string(publicKeyPem)}
+		}
+		respond(w, map[string]any{"keys": keys})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(transitTokenHeader) != testTransitToken {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestTransitBackend(address string, token string) *transitKeyBackend {
+	return newTransitKeyBackend(config.KeyBackendConfig{
+		TransitAddress:        address + "/",
+		TransitToken:          token,
+		TransitMount:          "transit",
+		TransitKeyName:        "kek",
+		TransitSigningKeyName: "jwt",
+	})
+}
+
+func generateSigningKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestTransitKeyBackendWrapAndUnwrap(t *testing.T) {
+	server := newTransitServer(t)
+	backend := newTestTransitBackend(server.URL, testTransitToken)
+
+	wrapped, err := backend.Wrap([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	if !strings.HasPrefix(wrapped, testCiphertextPrefix) {
+		t.Errorf("Wrap() = %q, want the transit ciphertext", wrapped)
+	}
+
+	unwrapped, err := backend.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap() error = %v", err)
+	}
+	if string(unwrapped) != "secret" {
+		t.Errorf("Unwrap() = %q, want %q", unwrapped, "secret")
+	}
+}
+
+func TestTransitKeyBackendSign(t *testing.T) {
+	signingKey := generateSigningKey(t)
+	server := newTransitServer(t, signingKey)
+	backend := newTestTransitBackend(server.URL, testTransitToken)
+
+	signature, err := backend.Sign([]byte("header.payload"))
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	err = jwtLib.SigningMethodES512.Verify("header.payload", signature, &signingKey.PublicKey)
+	if err != nil {
+		t.Errorf("Sign() returned a signature that doesn't verify: %v", err)
+	}
+}
+
+func TestTransitKeyBackendGetSigningPublicKeys(t *testing.T) {
+	oldKey := generateSigningKey(t)
+	newKey := generateSigningKey(t)
+	server := newTransitServer(t, oldKey, newKey)
+	backend := newTestTransitBackend(server.URL, testTransitToken)
+
+	publicKeys, err := backend.GetSigningPublicKeys()
+	if err != nil {
+		t.Fatalf("GetSigningPublicKeys() error = %v", err)
+	}
+	if len(publicKeys) != 2 {
+		t.Fatalf("GetSigningPublicKeys() returned %d keys, want 2", len(publicKeys))
+	}
+	if !publicKeys[0].Equal(&newKey.PublicKey) || !publicKeys[1].Equal(&oldKey.PublicKey) {
+		t.Errorf("GetSigningPublicKeys() didn't return the keys newest first")
+	}
+}
+
+func TestTransitKeyBackendErrors(t *testing.T) {
+	server := newTransitServer(t, generateSigningKey(t))
+
+	tests := []struct {
+		name string
+		call func(backend *transitKeyBackend) error
+		// The backend is built with a wrong token
+		wrongToken bool
+	}{
+		{
+			name: "unwrap of a value the backend didn't wrap",
+			call: func(backend *transitKeyBackend) error {
+				_, err := backend.Unwrap("not wrapped")
+				return err
+			},
+		},
+		{
+			name: "wrap with a wrong token",
+			call: func(backend *transitKeyBackend) error {
+				_, err := backend.Wrap([]byte("secret"))
+				return err
+			},
+			wrongToken: true,
+		},
+		{
+			name: "sign with a wrong token",
+			call: func(backend *transitKeyBackend) error {
+				_, err := backend.Sign([]byte("header.payload"))
+				return err
+			},
+			wrongToken: true,
+		},
+		{
+			name: "signing public keys with a wrong token",
+			call: func(backend *transitKeyBackend) error {
+				_, err := backend.GetSigningPublicKeys()
+				return err
+			},
+			wrongToken: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := testTransitToken
+			if test.wrongToken {
+				token = "wrong-token"
+			}
+
+			err := test.call(newTestTransitBackend(server.URL, token))
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestTransitKeyBackendUnreachable(t *testing.T) {
+	server := newTransitServer(t)
+	server.Close()
+
+	_, err := newTestTransitBackend(server.URL, testTransitToken).Wrap([]byte("secret"))
+	if err == nil {
+		t.Errorf("Wrap() expected an error when the backend is unreachable")
+	}
+}
+
+func TestJwtSignedByTransitVerifiesWithItsPublicKey(t *testing.T) {
+	signingKey := generateSigningKey(t)
+	server := newTransitServer(t, signingKey)
+	keys := &serverKeys{
+		backend: newTestTransitBackend(server.URL, testTransitToken),
+		cache:   make(map[string]any),
+	}
+
+	signedJwt, err := keys.SignJwt(jwtLib.NewWithClaims(jwtLib.SigningMethodES512, jwtLib.RegisteredClaims{Subject: "1"}))
+	if err != nil {
+		t.Fatalf("SignJwt() error = %v", err)
+	}
+
+	verificationKeys, err := keys.GetJwtVerificationKeys()
+	if err != nil {
+		t.Fatalf("GetJwtVerificationKeys() error = %v", err)
+	}
+
+	_, err = jwtLib.Parse(signedJwt, func(token *jwtLib.Token) (any, error) {
+		matchingKeys := verificationKeys.Key(token.Header[jwtKeyIdHeader].(string))
+		if len(matchingKeys) == 0 {
+			t.Fatalf("no verification key for the kid of the token")
+		}
+		return matchingKeys[0].Key, nil
+	}, jwtLib.WithValidMethods([]string{jwtLib.SigningMethodES512.Alg()}))
+	if err != nil {
+		t.Errorf("the token doesn't verify with the key of the backend: %v", err)
+	}
+}
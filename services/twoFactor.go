@@ -0,0 +1,299 @@
+package services
+
+import (
+	"shareLog/data/repository"
+	"shareLog/di"
+	"shareLog/lib"
+	"shareLog/models"
+	"shareLog/models/dto"
+	"shareLog/models/userGrant"
+	"time"
+)
+
+// Shown by authenticator apps next to the account
+const twoFactorIssuer = "shareLog"
+const twoFactorBackupCodeCount = 10
+
+// ErrTwoFactorUnavailable The key encryption backend can't wrap the TOTP secrets, e.g. no key encryption key is configured
+var ErrTwoFactorUnavailable = lib.Error{Msg: "Two-factor authentication is unavailable, the server can't wrap secrets"}
+
+type twoFactor struct {
+	twoFactorRepository       repository.TwoFactorRepository
+	twoFactorPolicyRepository repository.TwoFactorPolicyRepository
+	keyBackend                KeyEncryptionBackend
+	cryptoService             Crypto
+}
+
+type TwoFactor interface {
+	/*
+		GetSignInRequirement Returns whether the user has to pass a second factor to sign in,
+		and whether they have to enroll first because a policy requires it for their grant
+	*/
+	GetSignInRequirement(user *models.User) (required bool, enrollmentRequired bool)
+	GetStatus(user *models.User) (*dto.TwoFactorStatus, error)
+	/*
+		Enroll Starts an enrollment with a new secret, replacing an unconfirmed one. Returns the secret and its otpauth URI.
+		Returns ErrTwoFactorUnavailable if the secret can't be wrapped
+	*/
+	Enroll(user *models.User) (string, string, error)
+	// Confirm Enables two-factor authentication once a first code is valid. Returns the backup codes, they can't be retrieved later
+	Confirm(user *models.User, code string) ([]string, error)
+	// Verify Checks a TOTP code, or a backup code if no TOTP code is given. Codes can only be used once
+	Verify(user *models.User, code string, backupCode string) error
+	// Disable Removes the enrollment and the backup codes, unless a policy requires two-factor authentication for the user
+	Disable(user *models.User, code string, backupCode string) error
+	RegenerateBackupCodes(user *models.User, code string) ([]string, error)
+	GetPolicies() ([]models.TwoFactorPolicy, error)
+	// SetPolicy Returns ErrTwoFactorUnavailable when requiring two-factor authentication while the secrets can't be wrapped
+	SetPolicy(grant userGrant.Type, required bool) (*models.TwoFactorPolicy, error)
+}
+
+type TwoFactorProvider struct {
+}
+
+func (t TwoFactorProvider) Provide() any {
+	var instance TwoFactor = &twoFactor{
+		twoFactorRepository:       di.Get[repository.TwoFactorRepository](),
+		twoFactorPolicyRepository: di.Get[repository.TwoFactorPolicyRepository](),
+		keyBackend:                di.Get[KeyEncryptionBackend](),
+		cryptoService:             di.Get[Crypto](),
+	}
+	return instance
+}
+
+// Returns the confirmed enrollment of the user, nil if they didn't enable two-factor authentication
+func (t *twoFactor) getEnabled(user *models.User) *models.TwoFactor {
+	enrollment := t.twoFactorRepository.GetByUserId(user.ID)
+	if enrollment == nil || enrollment.ConfirmedAt == nil {
+		return nil
+	}
+
+	return enrollment
+}
+
+func (t *twoFactor) isRequiredByPolicy(grant userGrant.Type) bool {
+	policy := t.twoFactorPolicyRepository.GetByGrant(grant)
+	return policy != nil && policy.Required
+}
+
+func (t *twoFactor) GetSignInRequirement(user *models.User) (bool, bool) {
+	if t.getEnabled(user) != nil {
+		return true, false
+	}
+
+	isRequired := t.isRequiredByPolicy(user.Grant)
+	return isRequired, isRequired
+}
+
+func (t *twoFactor) GetStatus(user *models.User) (*dto.TwoFactorStatus, error) {
+	backupCodes, err := t.twoFactorRepository.GetUnusedBackupCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.TwoFactorStatus{
+		Enabled:     t.getEnabled(user) != nil,
+		Required:    t.isRequiredByPolicy(user.Grant),
+		BackupCodes: len(backupCodes),
+	}, nil
+}
+
+func (t *twoFactor) Enroll(user *models.User) (string, string, error) {
+	enrollment := t.twoFactorRepository.GetByUserId(user.ID)
+	if enrollment == nil {
+		enrollment = &models.TwoFactor{UserId: user.ID}
+	} else if enrollment.ConfirmedAt != nil {
+		return "", "", lib.Error{Msg: "Two-factor authentication is already enabled"}
+	}
+
+	secret, err := lib.GenerateTotpSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	enrollment.WrappedSecret, err = t.keyBackend.Wrap([]byte(secret))
+	if err != nil {
+		return "", "", ErrTwoFactorUnavailable
+	}
+
+	enrollment.LastUsedStep = 0
+	err = t.twoFactorRepository.Save(enrollment)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, lib.TotpUri(twoFactorIssuer, user.Email, secret), nil
+}
+
+// Accepts the code if it is valid and its time step wasn't used yet
+func (t *twoFactor) useTotpCode(enrollment *models.TwoFactor, code string) error {
+	secret, err := t.keyBackend.Unwrap(enrollment.WrappedSecret)
+	if err != nil {
+		return err
+	}
+
+	step, isValid := lib.ValidateTotpCode(string(secret), code, time.Now())
+	if !isValid {
+		return lib.Error{Msg: "Invalid two-factor code"}
+	}
+
+	isUnused, err := t.twoFactorRepository.UseStep(enrollment, step)
+	if err != nil {
+		return err
+	}
+	if !isUnused {
+		return lib.Error{Msg: "The two-factor code was already used"}
+	}
+
+	return nil
+}
+
+func (t *twoFactor) useBackupCode(user *models.User, backupCode string) error {
+	codes, err := t.twoFactorRepository.GetUnusedBackupCodes(user.ID)
+	if err != nil {
+		return err
+	}
+
+	normalizedCode := normalizeRecoveryCode(backupCode)
+	for _, code := range codes {
+		if !lib.CompareHashAndPassword(code.CodeHash, normalizedCode, code.HashSalt) {
+			continue
+		}
+
+		isUnused, err := t.twoFactorRepository.UseBackupCode(&code)
+		if err != nil {
+			return err
+		}
+		if isUnused {
+			return nil
+		}
+	}
+
+	return lib.Error{Msg: "Invalid backup code"}
+}
+
+func (t *twoFactor) generateBackupCodes(user *models.User) ([]string, error) {
+	codes := make([]string, 0, twoFactorBackupCodeCount)
+	codeModels := make([]models.TwoFactorBackupCode, 0, twoFactorBackupCodeCount)
+	for range twoFactorBackupCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hashSalt := t.cryptoService.GenerateSalt()
+		codeHash, err := lib.HashPassword(normalizeRecoveryCode(code), hashSalt)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		codeModels = append(codeModels, models.TwoFactorBackupCode{
+			UserId:   user.ID,
+			CodeHash: codeHash,
+			HashSalt: hashSalt,
+		})
+	}
+
+	err := t.twoFactorRepository.ReplaceBackupCodes(user.ID, codeModels)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (t *twoFactor) Confirm(user *models.User, code string) ([]string, error) {
+	enrollment := t.twoFactorRepository.GetByUserId(user.ID)
+	if enrollment == nil {
+		return nil, lib.Error{Msg: "No two-factor enrollment was started"}
+	}
+	if enrollment.ConfirmedAt != nil {
+		return nil, lib.Error{Msg: "Two-factor authentication is already enabled"}
+	}
+
+	err := t.useTotpCode(enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	enrollment.ConfirmedAt = &now
+	err = t.twoFactorRepository.Save(enrollment)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.generateBackupCodes(user)
+}
+
+func (t *twoFactor) Verify(user *models.User, code string, backupCode string) error {
+	enrollment := t.getEnabled(user)
+	if enrollment == nil {
+		return lib.Error{Msg: "Two-factor authentication isn't enabled"}
+	}
+
+	if code == "" {
+		return t.useBackupCode(user, backupCode)
+	}
+
+	return t.useTotpCode(enrollment, code)
+}
+
+func (t *twoFactor) Disable(user *models.User, code string, backupCode string) error {
+	if t.isRequiredByPolicy(user.Grant) {
+		return lib.Error{Msg: "Two-factor authentication is required for " + user.Grant.Name + " users"}
+	}
+
+	err := t.Verify(user, code, backupCode)
+	if err != nil {
+		return err
+	}
+
+	return t.twoFactorRepository.DeleteForUser(user.ID)
+}
+
+func (t *twoFactor) RegenerateBackupCodes(user *models.User, code string) ([]string, error) {
+	enrollment := t.getEnabled(user)
+	if enrollment == nil {
+		return nil, lib.Error{Msg: "Two-factor authentication isn't enabled"}
+	}
+
+	err := t.useTotpCode(enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.generateBackupCodes(user)
+}
+
+func (t *twoFactor) GetPolicies() ([]models.TwoFactorPolicy, error) {
+	return t.twoFactorPolicyRepository.GetAll()
+}
+
+func (t *twoFactor) SetPolicy(grant userGrant.Type, required bool) (*models.TwoFactorPolicy, error) {
+	if grant != userGrant.Types.GrantOwner && grant != userGrant.Types.GrantClient {
+		return nil, lib.Error{Msg: "Policies can only be set for owner and client users", Reason: grant.Name}
+	}
+
+	// Nobody could enroll, the users of the grant would be locked out
+	if required {
+		_, err := t.keyBackend.Wrap([]byte("two-factor policy check"))
+		if err != nil {
+			return nil, ErrTwoFactorUnavailable
+		}
+	}
+
+	policy := t.twoFactorPolicyRepository.GetByGrant(grant)
+	if policy == nil {
+		policy = &models.TwoFactorPolicy{Grant: grant}
+	}
+
+	policy.Required = required
+	err := t.twoFactorPolicyRepository.Save(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}